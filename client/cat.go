@@ -42,14 +42,11 @@ func main() {
 		log.Fatal("Failed to obtain total number of records for file: ", fname)
 	}
 
-	var i uint16
-
-	for i = 0; i < num_recs; i++ {
-		var record rfslib.Record
-		err = rfs.ReadRec(fname, i, &record)
-		if err != nil {
-			log.Fatalf("Failed to obtain record %d for %s\n", i, fname)
-		}
+	records, err := rfs.ReadRecs(fname, 0, num_recs)
+	if err != nil {
+		log.Fatalf("Failed to obtain records for %s\n", fname)
+	}
+	for _, record := range records {
 		fmt.Println(string(record[:]))
 	}
 }
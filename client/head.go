@@ -46,16 +46,14 @@ func main() {
 		log.Fatal("Failed to obtain total number of records for: ", fname)
 	}
 
-	var i uint16
-
-	for i = 0; i < num_recs; i++ {
-		if i < uint16(k) {
-			var record rfslib.Record
-			err := rfs.ReadRec(fname, i, &record)
-			if err != nil {
-				log.Fatalf("Failed to obtain record %d for %s\n", i, fname)
-			}
-			fmt.Println(string(record[:]))
-		}
+	if k < int(num_recs) {
+		num_recs = uint16(k)
+	}
+	records, err := rfs.ReadRecs(fname, 0, num_recs)
+	if err != nil {
+		log.Fatalf("Failed to obtain records for %s\n", fname)
+	}
+	for _, record := range records {
+		fmt.Println(string(record[:]))
 	}
 }
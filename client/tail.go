@@ -30,15 +30,21 @@ func max(a, b int) int {
 }
 
 func main() {
-	if len(os.Args) != 3 {
-		log.Fatal("Usage: go run head.go <k> <fname>")
+	// with -f new records are printed as they get confirmed
+	args := os.Args[1:]
+	follow := len(args) > 0 && args[0] == "-f"
+	if follow {
+		args = args[1:]
+	}
+	if len(args) != 2 {
+		log.Fatal("Usage: go run tail.go [-f] <k> <fname>")
 	}
 
-	k, err := strconv.Atoi(os.Args[1])
+	k, err := strconv.Atoi(args[0])
 	if err != nil {
 		log.Fatal("Failed to convert k to a number.", err)
 	}
-	fname := os.Args[2]
+	fname := args[1]
 	local_ip, miner_address, err := get_local_miner_ip_addresses("./.rfs")
 	if err != nil {
 		log.Fatal("Failed to obtain ip addresses from ./.rfs")
@@ -49,17 +55,41 @@ func main() {
 		log.Fatal("Failed to initialize rfslib")
 	}
 
+	// start watching before counting the records so that none are missed
+	var events <-chan rfslib.WatchEvent
+	if follow {
+		events, err = rfs.Watch(fname, false)
+		if err != nil {
+			log.Fatal("Failed to watch file: ", fname)
+		}
+	}
+
 	num_recs, err := rfs.TotalRecs(fname)
 	if err != nil {
 		log.Fatal("Failed to obtain total number of records for file: ", fname)
 	}
 
-	for i := max(0, int(num_recs)-k); i < int(num_recs); i++ {
-		var record rfslib.Record
-		err := rfs.ReadRec(fname, uint16(i), &record)
-		if err != nil {
-			log.Fatalf("Failed to obtain record %d for %s\n", i, fname)
-		}
+	start := max(0, int(num_recs)-k)
+	records, err := rfs.ReadRecs(fname, uint16(start), num_recs-uint16(start))
+	if err != nil {
+		log.Fatalf("Failed to obtain records for %s\n", fname)
+	}
+	for _, record := range records {
 		fmt.Println(string(record[:]))
 	}
+
+	if !follow {
+		return
+	}
+	for event := range events {
+		switch event.Type {
+		case rfslib.RecordAppended:
+			if event.RecordNum >= num_recs {
+				fmt.Println(string(event.Record[:]))
+			}
+		case rfslib.FileDeleted:
+			log.Fatal("File was deleted: ", fname)
+		}
+	}
+	log.Fatal("Lost the connection to the miner")
 }
@@ -22,15 +22,74 @@ const (
 	CreateFile BlockOpType = iota
 	AppendFile
 	DeleteFile
+	Transaction
+	// Moves coins from the account of the creator to the account of the recipient
+	Transfer
 )
 
 type BlockOp struct {
 	Type BlockOpType
-	// Miner id of the person that create the request
+	// Account paying for the op, the miner id of a miner or the account id of a client, see AccountId
 	Creator string
 	Filename string
 	RecordNumber uint16
 	Data BlockOpData
+	// Coins paid to the miner of the block on top of the base fee of the op
+	Tip uint32
+	// Unique id of the op, either assigned by the miner or an idempotency key given by the client
+	OpId string
+	// Ops of a Transaction, they are applied together or not at all
+	Ops []*BlockOp
+	// Account getting the coins of a Transfer and how many of them
+	Recipient string
+	Amount uint32
+	// Signature of the client whose account is the creator, see Sign. Ops of miners and the ops of
+	// a transaction are not signed
+	Signature []byte
+}
+
+// Identifies an op by its op id, ops without one are identified by their contents
+func (op *BlockOp) Id() string {
+	if op.OpId != "" {
+		return op.OpId
+	}
+
+	buf := &bytes.Buffer{}
+	intBuff := make([]byte, unsafe.Sizeof(uint32(1)))
+
+	binary.LittleEndian.PutUint32(intBuff, uint32(op.Type))
+	buf.Write(intBuff)
+	buf.WriteString(op.Creator)
+	buf.WriteByte(0)
+	buf.WriteString(op.Filename)
+	buf.WriteByte(0)
+	binary.LittleEndian.PutUint32(intBuff, uint32(op.RecordNumber))
+	buf.Write(intBuff)
+	buf.Write(op.Data[:])
+	binary.LittleEndian.PutUint32(intBuff, op.Tip)
+	buf.Write(intBuff)
+	for _, sub := range op.Ops {
+		buf.WriteString(sub.Id())
+	}
+	if op.Type == Transfer {
+		buf.WriteString(op.Recipient)
+		buf.WriteByte(0)
+		binary.LittleEndian.PutUint32(intBuff, op.Amount)
+		buf.Write(intBuff)
+	}
+	return fmt.Sprintf("%x", md5.Sum(buf.Bytes()))
+}
+
+// Ops of a block in order, every transaction followed by its ops
+func FlattenOps(ops []*BlockOp) []*BlockOp {
+	res := make([]*BlockOp, 0, len(ops))
+	for _, op := range ops {
+		res = append(res, op)
+		if op.Type == Transaction {
+			res = append(res, op.Ops...)
+		}
+	}
+	return res
 }
 
 type BlockType int
@@ -58,10 +117,7 @@ func (b *Block) serialize() []byte {
 
 	intBuff := make([]byte, unsafe.Sizeof(uint32(1)))
 	for _, v := range b.Records {
-		buf.Write([]byte(v.Filename))
-		buf.Write(v.Data[:])
-		binary.LittleEndian.PutUint16(intBuff, v.RecordNumber)
-		buf.Write(intBuff)
+		serializeOp(buf, v, intBuff)
 	}
 
 	buf.Write([]byte(b.MinerId))
@@ -70,6 +126,29 @@ func (b *Block) serialize() []byte {
 	return buf.Bytes()
 }
 
+func serializeOp(buf *bytes.Buffer, v *BlockOp, intBuff []byte) {
+	buf.Write([]byte(v.Filename))
+	buf.Write(v.Data[:])
+	binary.LittleEndian.PutUint16(intBuff, v.RecordNumber)
+	buf.Write(intBuff)
+	// only hash the tip when there is one so blocks without tips keep their old hashes
+	if v.Tip > 0 {
+		binary.LittleEndian.PutUint32(intBuff, v.Tip)
+		buf.Write(intBuff)
+	}
+	buf.Write([]byte(v.OpId))
+	for _, sub := range v.Ops {
+		serializeOp(buf, sub, intBuff)
+	}
+	// same for transfers and signatures
+	if v.Type == Transfer {
+		buf.Write([]byte(v.Recipient))
+		binary.LittleEndian.PutUint32(intBuff, v.Amount)
+		buf.Write(intBuff)
+	}
+	buf.Write(v.Signature)
+}
+
 func (b *Block) hash(ser []byte) []byte {
 	switch b.Type {
 	case NoOpBlock, RegularBlock:
@@ -0,0 +1,101 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"unsafe"
+)
+
+// Accounts of clients start with it, what follows is their public key. Any other account is the id
+// of a miner, whose ops aren't signed, so only the miner itself can mine its transfers and tips.
+const ClientAccountPrefix = "client-"
+
+// Key pair of a client, the public key is its account
+func GenerateKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// Account of the client holding the private key of pub
+func AccountId(pub *ecdsa.PublicKey) string {
+	return ClientAccountPrefix + hex.EncodeToString(elliptic.MarshalCompressed(elliptic.P256(), pub.X, pub.Y))
+}
+
+func IsClientAccount(account string) bool {
+	return strings.HasPrefix(account, ClientAccountPrefix)
+}
+
+func accountKey(account string) (*ecdsa.PublicKey, bool) {
+	data, err := hex.DecodeString(strings.TrimPrefix(account, ClientAccountPrefix))
+	if err != nil {
+		return nil, false
+	}
+	x, y := elliptic.UnmarshalCompressed(elliptic.P256(), data)
+	if x == nil {
+		return nil, false
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, true
+}
+
+// Makes the account of key the creator of the op and signs it. The ops of a transaction are signed
+// along with it, they need the same creator.
+func (op *BlockOp) Sign(key *ecdsa.PrivateKey) error {
+	if op.OpId == "" {
+		// the op id keeps the signature from being used for another op with the same contents
+		return errors.New("only ops with an op id can be signed")
+	}
+	op.Creator = AccountId(&key.PublicKey)
+	sum := op.signedHash()
+	sig, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	if err != nil {
+		return err
+	}
+	op.Signature = sig
+	return nil
+}
+
+// Whether the creator of the op signed it, ops of miners are valid as long as they have no signature
+func (op *BlockOp) SignatureValid() bool {
+	if !IsClientAccount(op.Creator) {
+		return len(op.Signature) == 0
+	}
+	pub, ok := accountKey(op.Creator)
+	if !ok || op.OpId == "" {
+		return false
+	}
+	sum := op.signedHash()
+	return ecdsa.VerifyASN1(pub, sum[:], op.Signature)
+}
+
+// Everything but the record numbers is signed, the miner relaying an append gives it the next record
+// number of the file when it's added to a block
+func (op *BlockOp) signedHash() [sha256.Size]byte {
+	buf := &bytes.Buffer{}
+	writeSigned(buf, op, make([]byte, unsafe.Sizeof(uint32(1))))
+	return sha256.Sum256(buf.Bytes())
+}
+
+func writeSigned(buf *bytes.Buffer, op *BlockOp, intBuff []byte) {
+	binary.LittleEndian.PutUint32(intBuff, uint32(op.Type))
+	buf.Write(intBuff)
+	for _, s := range []string{op.Creator, op.Filename, op.OpId, op.Recipient} {
+		buf.WriteString(s)
+		buf.WriteByte(0)
+	}
+	buf.Write(op.Data[:])
+	binary.LittleEndian.PutUint32(intBuff, op.Tip)
+	buf.Write(intBuff)
+	binary.LittleEndian.PutUint32(intBuff, op.Amount)
+	buf.Write(intBuff)
+	binary.LittleEndian.PutUint32(intBuff, uint32(len(op.Ops)))
+	buf.Write(intBuff)
+	for _, sub := range op.Ops {
+		writeSigned(buf, sub, intBuff)
+	}
+}
@@ -0,0 +1,77 @@
+package crypto
+
+import (
+	"testing"
+)
+
+func TestSignature(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	account := AccountId(&key.PublicKey)
+	assert(t, IsClientAccount(account), "the account of a key should be a client account, got %s", account)
+
+	signed := func() *BlockOp {
+		op := &BlockOp{Type: AppendFile, Filename: "file", Data: BlockOpData{1, 2, 3}, Tip: 2, OpId: "op"}
+		if err := op.Sign(key); err != nil {
+			t.Fatal(err)
+		}
+		return op
+	}
+
+	t.Run("should make the account of the key the creator", func(t *testing.T) {
+		op := signed()
+		equals(t, account, op.Creator)
+		assert(t, op.SignatureValid(), "signature should be valid")
+	})
+
+	t.Run("should keep the signature when the record number changes", func(t *testing.T) {
+		op := signed()
+		op.RecordNumber = 7
+		assert(t, op.SignatureValid(), "signature should be valid")
+	})
+
+	t.Run("should reject ops whose contents changed", func(t *testing.T) {
+		op := signed()
+		op.Data[0] = 9
+		assert(t, !op.SignatureValid(), "signature of changed data should be invalid")
+		op = signed()
+		op.Tip = 0
+		assert(t, !op.SignatureValid(), "signature of changed tip should be invalid")
+		op = signed()
+		op.OpId = "other"
+		assert(t, !op.SignatureValid(), "signature of changed op id should be invalid")
+	})
+
+	t.Run("should reject ops charged to another client", func(t *testing.T) {
+		other, err := GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		op := signed()
+		op.Creator = AccountId(&other.PublicKey)
+		assert(t, !op.SignatureValid(), "signature of another client should be invalid")
+		op.Creator = ClientAccountPrefix + "not a key"
+		assert(t, !op.SignatureValid(), "signature of a bad account should be invalid")
+	})
+
+	t.Run("should sign the ops of a transaction with it", func(t *testing.T) {
+		op := &BlockOp{Type: Transaction, OpId: "tx", Ops: []*BlockOp{
+			{Type: CreateFile, Creator: account, Filename: "file"}}}
+		if err := op.Sign(key); err != nil {
+			t.Fatal(err)
+		}
+		assert(t, op.SignatureValid(), "signature should be valid")
+		op.Ops[0].Filename = "other"
+		assert(t, !op.SignatureValid(), "signature of changed ops should be invalid")
+	})
+
+	t.Run("should only accept unsigned ops from miners", func(t *testing.T) {
+		op := &BlockOp{Type: CreateFile, Creator: "miner", Filename: "file"}
+		assert(t, op.SignatureValid(), "unsigned op of a miner should be valid")
+		op.Signature = []byte{1}
+		assert(t, !op.SignatureValid(), "signed op of a miner should be invalid")
+		assert(t, (&BlockOp{Type: CreateFile}).Sign(key) != nil, "should not sign ops without an op id")
+	})
+}
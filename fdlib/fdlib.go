@@ -95,17 +95,25 @@ func InitializeFDLib(EpochNonce uint64, ChCapacity uint8) (fd FD, notifyCh <-cha
 		return nil, nil, errors.New("fdlib: multiple invocations of Initialize() are not permitted")
 	}
 
-	notifyChannel := make(chan FailureDetected, ChCapacity)
-	fdlibInstance =
-		&fdlib{
-			epochNonce:      EpochNonce,
-			notifyCh:        notifyChannel,
-			monitoringNodes: SafeMonitoringMap{monitoringNodes: make(map[string]monitoringInfo)},
-			respondingOn:    respondingInfo{localIpPort: ""},
-			currSeqNum:      SafeCounter{counterValue: 0},
-			roundTripTimes:  SafeTimesMap{roundTripTimes: make(map[string]time.Duration)}}
-
-	return fdlibInstance, notifyChannel, nil
+	fdlibInstance = newFDLib(EpochNonce, ChCapacity)
+	return fdlibInstance, fdlibInstance.notifyCh, nil
+}
+
+// Same as InitializeFDLib, but every call returns a new instance that is
+// independent from the others, e.g. one per connection of a client.
+func NewFDLib(EpochNonce uint64, ChCapacity uint8) (fd FD, notifyCh <-chan FailureDetected, err error) {
+	instance := newFDLib(EpochNonce, ChCapacity)
+	return instance, instance.notifyCh, nil
+}
+
+func newFDLib(EpochNonce uint64, ChCapacity uint8) *fdlib {
+	return &fdlib{
+		epochNonce:      EpochNonce,
+		notifyCh:        make(chan FailureDetected, ChCapacity),
+		monitoringNodes: SafeMonitoringMap{monitoringNodes: make(map[string]monitoringInfo)},
+		respondingOn:    respondingInfo{localIpPort: ""},
+		currSeqNum:      SafeCounter{counterValue: 0},
+		roundTripTimes:  SafeTimesMap{roundTripTimes: make(map[string]time.Duration)}}
 }
 
 // For testing purposes.
@@ -4,7 +4,12 @@ import (
 	"../../fdlib"
 	"../../miner/instance"
 	"../../rfslib"
+	"../../shared"
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"path/filepath"
 	"reflect"
 	"runtime"
@@ -55,6 +60,24 @@ func TestRFSLibMinerIntegration(t *testing.T) {
 	ok(t, err)
 	equals(t, uint16(1), numRecs)
 
+	// Reads at another confirmation depth than the network one
+	numRecs, err = rfs.WithReadDepth(0).TotalRecs(SAMPLE_FNAME)
+	ok(t, err)
+	equals(t, uint16(1), numRecs)
+	var used rfslib.ReadDepth
+	_, err = rfs.WithDepthReport(&used).TotalRecs(SAMPLE_FNAME)
+	ok(t, err)
+	equals(t, rfslib.ReadDepth{Create: 2, Append: 4}, used)
+	_, err = rfs.WithReadDepth(0).WithDepthReport(&used).ListFiles()
+	ok(t, err)
+	equals(t, rfslib.ReadDepth{Create: 0, Append: 0}, used)
+	_, err = rfs.WithReadDepth(255).TotalRecs(SAMPLE_FNAME)
+	_, isMissingFile := err.(rfslib.FileDoesNotExistError)
+	assert(t, isMissingFile, "should fail because the file isn't that deep in the chain")
+	_, err = rfs.WithReadDepth(-1).ListFiles()
+	_, isInvalidDepth := err.(rfslib.InvalidReadDepthError)
+	assert(t, isInvalidDepth, "should fail because the depth is negative")
+
 	// Read record
 	record = new(rfslib.Record)
 	index := uint16(0)
@@ -62,6 +85,270 @@ func TestRFSLibMinerIntegration(t *testing.T) {
 	ok(t, err)
 	equals(t, recordContents, record[:len(recordContents)])
 
+	// Reading a record that wasn't appended fails, right away or once the timeout runs out
+	err = rfs.ReadRecWithWait(SAMPLE_FNAME, 1, record, rfslib.NoWait, 0)
+	_, isMissing := err.(rfslib.RecordDoesNotExistError)
+	assert(t, isMissing, "should fail because record 1 wasn't appended")
+	start := time.Now()
+	err = rfs.ReadRecWithWait(SAMPLE_FNAME, 1, record, rfslib.WaitTimeout, time.Second)
+	_, isMissing = err.(rfslib.RecordDoesNotExistError)
+	assert(t, isMissing, "should fail because record 1 wasn't appended before the timeout")
+	assert(t, time.Since(start) >= time.Second, "should wait for the timeout")
+
+	// Requests from several goroutines share the connection, a waiting read doesn't hold back the others
+	waiting := make(chan error)
+	go func() {
+		waitRecord := new(rfslib.Record)
+		waiting <- rfs.ReadRecWithWait(SAMPLE_FNAME, 1, waitRecord, rfslib.WaitTimeout, time.Second*2)
+	}()
+	time.Sleep(time.Second / 4)
+	start = time.Now()
+	numRecs, err = rfs.TotalRecs(SAMPLE_FNAME)
+	ok(t, err)
+	equals(t, uint16(1), numRecs)
+	assert(t, time.Since(start) < time.Second, "should not wait for the other read")
+	_, isMissing = (<-waiting).(rfslib.RecordDoesNotExistError)
+	assert(t, isMissing, "should fail because record 1 wasn't appended before the timeout")
+
+	// Calls of a context view give up once the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second/2)
+	err = rfs.WithContext(ctx).ReadRecWithWait(SAMPLE_FNAME, 1, record, rfslib.WaitForever, 0)
+	cancel()
+	equals(t, context.DeadlineExceeded, err)
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = rfs.WithContext(ctx).ListFiles()
+	equals(t, context.Canceled, err)
+	numRecs, err = rfs.TotalRecs(SAMPLE_FNAME)
+	ok(t, err)
+	equals(t, uint16(1), numRecs)
+
+	// Append records in one request
+	records := make([]rfslib.Record, 3)
+	for i := range records {
+		copy(records[i][:], fmt.Sprintf("batch record %d", i))
+	}
+	recNums, err := rfs.AppendRecs(SAMPLE_FNAME, records)
+	ok(t, err)
+	equals(t, []uint16{1, 2, 3}, recNums)
+
+	numRecs, err = rfs.TotalRecs(SAMPLE_FNAME)
+	ok(t, err)
+	equals(t, uint16(4), numRecs)
+
+	// Batches bigger than a block are mined over several blocks
+	ok(t, rfs.CreateFile("batch_file"))
+	batch := make([]rfslib.Record, shared.OPS_PER_BLOCK+2)
+	recNums, err = rfs.AppendRecs("batch_file", batch)
+	ok(t, err)
+	for i, recNum := range recNums {
+		equals(t, uint16(i), recNum)
+	}
+	equals(t, len(batch), len(recNums))
+	ok(t, rfs.DeleteFile("batch_file"))
+
+	// Conditional appends only get the record number they expect
+	err = rfs.AppendRecAt(SAMPLE_FNAME, 4, record)
+	ok(t, err)
+	err = rfs.AppendRecAt(SAMPLE_FNAME, 4, record)
+	_, isConflict := err.(rfslib.RecordConflictError)
+	assert(t, isConflict, "should fail because record 4 is taken")
+
+	// Range reads stop at the end of the file
+	readRecords, err := rfs.ReadRecs(SAMPLE_FNAME, 1, 10)
+	ok(t, err)
+	equals(t, 4, len(readRecords))
+	for i := range records {
+		equals(t, records[i], readRecords[i])
+	}
+	readRecords, err = rfs.ReadRecs(SAMPLE_FNAME, 5, 10)
+	ok(t, err)
+	equals(t, 0, len(readRecords))
+
+	// Verified reads check the records against the blocks of the miner
+	conf := rfslib.VerifyConfig{
+		GenesisBlockHash: "83218ac34c1834c26781fe4bde918ee4",
+		PowPerOpBlock:    5,
+		PowPerNoOpBlock:  5,
+		Confirms:         2}
+	verified := rfs.WithVerification(conf)
+	verifiedRecords, err := verified.ReadRecs(SAMPLE_FNAME, 0, 10)
+	ok(t, err)
+	equals(t, 5, len(verifiedRecords))
+	for i := range records {
+		equals(t, records[i], verifiedRecords[i+1])
+	}
+	verifiedRecord := new(rfslib.Record)
+	err = verified.ReadRec(SAMPLE_FNAME, 0, verifiedRecord)
+	ok(t, err)
+	equals(t, recordContents, verifiedRecord[:len(recordContents)])
+	verifiedNumRecs, err := verified.TotalRecs(SAMPLE_FNAME)
+	ok(t, err)
+	equals(t, uint16(5), verifiedNumRecs)
+	verifiedNames, err := verified.ListFiles()
+	ok(t, err)
+	assert(t, len(verifiedNames) > 0, "should list the files of the chain")
+	_, err = verified.TotalRecs("missing_file")
+	_, isMissingFile = err.(rfslib.FileDoesNotExistError)
+	assert(t, isMissingFile, "should prove that the file doesn't exist")
+
+	conf.GenesisBlockHash = "00000000000000000000000000000000"
+	_, err = rfs.WithVerification(conf).ListFiles()
+	_, isUnverified := err.(rfslib.VerificationError)
+	assert(t, isUnverified, "should fail because the genesis block is another one")
+
+	// Files read the records as bytes
+	file, err := rfs.Open(SAMPLE_FNAME)
+	ok(t, err)
+	fileContents := make([]byte, len(recordContents))
+	_, err = file.ReadAt(fileContents, 0)
+	ok(t, err)
+	equals(t, recordContents, fileContents)
+	ok(t, file.Close())
+	_, err = rfs.Open("missing_file")
+	_, isMissingFile = err.(rfslib.FileDoesNotExistError)
+	assert(t, isMissingFile, "should fail because the file doesn't exist")
+
+	// The HTTP gateway serves the same files
+	resp, err := http.Get("http://127.0.0.1:9191/files/" + SAMPLE_FNAME)
+	ok(t, err)
+	stat := make(map[string]interface{})
+	ok(t, json.NewDecoder(resp.Body).Decode(&stat))
+	resp.Body.Close()
+	equals(t, http.StatusOK, resp.StatusCode)
+	equals(t, float64(5), stat["records"])
+
+	// Watch files starting with sample_ while creating a new one
+	events, err := rfs.Watch("sample_", true)
+	ok(t, err)
+
+	// Create and write a file in one transaction
+	const SAMPLE_FNAME2 = "sample_file2"
+	tx := rfslib.NewTransaction().CreateFile(SAMPLE_FNAME2).AppendRec(SAMPLE_FNAME2, record)
+	recNums, err = rfs.CommitTransaction(tx)
+	ok(t, err)
+	equals(t, []uint16{0}, recNums)
+
+	expected := []rfslib.WatchEvent{
+		{Type: rfslib.FileCreated, Filename: SAMPLE_FNAME2},
+		{Type: rfslib.RecordAppended, Filename: SAMPLE_FNAME2, RecordNum: 0, Record: *record},
+	}
+	for _, want := range expected {
+		select {
+		case event := <-events:
+			equals(t, want, event)
+		case <-time.After(time.Second * 10):
+			t.Fatalf("did not get watch event %v", want)
+		}
+	}
+	ok(t, rfs.Unwatch(events))
+
+	// Nothing gets applied if an op of the transaction fails
+	tx = rfslib.NewTransaction().AppendRec(SAMPLE_FNAME2, record).CreateFile(SAMPLE_FNAME)
+	_, err = rfs.CommitTransaction(tx)
+	_, isFileExists := err.(rfslib.FileExistsError)
+	assert(t, isFileExists, "should fail because the file exists")
+	numRecs, err = rfs.TotalRecs(SAMPLE_FNAME2)
+	ok(t, err)
+	equals(t, uint16(1), numRecs)
+
+	_, err = rfs.CommitTransaction(rfslib.NewTransaction().DeleteFile(SAMPLE_FNAME2))
+	ok(t, err)
+
+	// Async operations return a ticket right away
+	const SAMPLE_FNAME3 = "sample_file3"
+	ticket, err := rfs.CreateFileAsync(SAMPLE_FNAME3)
+	ok(t, err)
+	status, err := rfs.TicketStatus(ticket)
+	ok(t, err)
+	assert(t, status.State != rfslib.OpFailed, "should not fail")
+	status, err = rfs.WaitTicket(ticket, 0)
+	ok(t, err)
+	equals(t, rfslib.OpConfirmed, status.State)
+	assert(t, status.Block != "", "should report the block of the op")
+
+	ticket, err = rfs.AppendRecAsync(SAMPLE_FNAME3, record)
+	ok(t, err)
+	status, err = rfs.WaitTicket(ticket, 0)
+	ok(t, err)
+	equals(t, rfslib.OpConfirmed, status.State)
+	equals(t, uint16(0), status.RecordNum)
+
+	ticket, err = rfs.CreateFileAsync(SAMPLE_FNAME3)
+	ok(t, err)
+	status, err = rfs.WaitTicket(ticket, 0)
+	ok(t, err)
+	equals(t, rfslib.OpFailed, status.State)
+	_, isFileExists = status.Err.(rfslib.FileExistsError)
+	assert(t, isFileExists, "should fail because the file exists")
+
+	_, err = rfs.TicketStatus("unknown")
+	_, isUnknown := err.(rfslib.UnknownTicketError)
+	assert(t, isUnknown, "should fail because the miner never got the ticket")
+
+	ticket, err = rfs.DeleteFileAsync(SAMPLE_FNAME3)
+	ok(t, err)
+	status, err = rfs.WaitTicket(ticket, 0)
+	ok(t, err)
+	equals(t, rfslib.OpConfirmed, status.State)
+
+	// Sessions are independent from each other and from the Initialize one
+	session, err := rfslib.NewSession("localhost:5153", minerAddr)
+	ok(t, err)
+	fnames, err = session.ListFiles()
+	ok(t, err)
+	equals(t, []string{SAMPLE_FNAME}, fnames)
+	ok(t, session.Close())
+	_, err = session.ListFiles()
+	_, isDisconnected := err.(rfslib.DisconnectedError)
+	assert(t, isDisconnected, "should fail because the session is closed")
+	_, err = rfs.ListFiles()
+	ok(t, err)
+
+	// Failover sessions connect to the first miner that is up
+	session, err = rfslib.NewFailoverSession("localhost:5154", []string{"localhost:9099", minerAddr})
+	ok(t, err)
+	fnames, err = session.ListFiles()
+	ok(t, err)
+	equals(t, []string{SAMPLE_FNAME}, fnames)
+	ok(t, session.Close())
+
+	// Quorum sessions agree with themselves when asking the same miner twice
+	session, err = rfslib.NewQuorumSession("localhost:5155", []string{minerAddr, minerAddr}, 2)
+	ok(t, err)
+	fnames, err = session.ListFiles()
+	ok(t, err)
+	equals(t, []string{SAMPLE_FNAME}, fnames)
+	ok(t, session.Close())
+
+	// Clients pay for their own ops once the miner transfers them coins
+	key, err := rfslib.GenerateKey()
+	ok(t, err)
+	client := rfs.WithIdentity(key)
+	err = client.WithFundsWait(rfslib.NoWait, 0).CreateFile("client_file")
+	equals(t, rfslib.InsufficientFundsError{Balance: 0, Needed: 4}, err)
+	start = time.Now()
+	err = client.WithFundsWait(rfslib.WaitTimeout, time.Second).CreateFile("client_file")
+	equals(t, rfslib.InsufficientFundsError{Balance: 0, Needed: 4}, err)
+	assert(t, time.Since(start) >= time.Second, "should wait for coins until the timeout")
+	// clients can't spend the coins of the miner, only its operator can
+	_, rejected := rfs.Transfer(rfslib.AccountId(key), 10).(rfslib.BadSignatureError)
+	assert(t, rejected, "should not transfer the coins of the miner")
+	transfer, err := json.Marshal(map[string]interface{}{"recipient": rfslib.AccountId(key), "amount": 10})
+	ok(t, err)
+	resp, err = http.Post("http://127.0.0.1:9291/transfer", "application/json", bytes.NewReader(transfer))
+	ok(t, err)
+	resp.Body.Close()
+	equals(t, http.StatusOK, resp.StatusCode)
+	ok(t, client.CreateFile("client_file"))
+	balance, err := client.Balance()
+	ok(t, err)
+	equals(t, 6, balance)
+	ok(t, client.DeleteFile("client_file"))
+	balance, err = client.Balance()
+	ok(t, err)
+	equals(t, 10, balance)
+
 	// delete record
 	err = rfs.DeleteFile(SAMPLE_FNAME)
 	ok(t, err)
@@ -324,3 +324,19 @@ func ok(tb testing.TB, err error) {
 		tb.FailNow()
 	}
 }
+
+func TestJobWatchers(t *testing.T) {
+	watcherConfig := config
+	watcherConfig.IncomingMinersAddr = "localhost:8086"
+	s := NewMinerState(watcherConfig, connectingNodes)
+
+	// handlers waiting for the same op only stop their own watch
+	job := &crypto.BlockOp{Type: crypto.CreateFile, Creator: config.MinerId, Filename: "watched", OpId: "watched"}
+	first := s.WatchJob(job)
+	second := s.WatchJob(job)
+	s.UnwatchJob(job, first)
+	s.OnJobDropped(job, shared.OP_EVICTED)
+	equals(t, 0, len(first))
+	equals(t, shared.FailureType(shared.OP_EVICTED), <-second)
+	s.UnwatchJob(job, second)
+}
@@ -2,6 +2,7 @@ package block_calculators
 
 import (
 	. "../../crypto"
+	"../../shared"
 	"crypto/md5"
 	"fmt"
 	"math/rand"
@@ -22,6 +23,8 @@ type blkGenList struct {
 	validate        *int
 	longestNum      *int
 	blockOps        []*BlockOp
+	echoOps         bool
+	dropped         map[string]shared.FailureType
 }
 
 func (bg blkGenList) InLongestChain(id string) int {
@@ -64,12 +67,22 @@ func (bg blkGenList) GetMinerId() string {
 
 func (bg blkGenList) ValidateJobSet(bOps []*BlockOp) ([]*BlockOp, error, error) {
 	*bg.validate += 1
-	if len(bOps) == 0 {
+	if len(bOps) == 0 || bg.echoOps {
 		return bOps, nil, nil
 	}
 	return bg.blockOps, nil, nil
 }
 
+func (bg blkGenList) GetOpFee(b *BlockOp) int {
+	return 1 + int(b.Tip)
+}
+
+func (bg blkGenList) OnJobDropped(b *BlockOp, reason shared.FailureType) {
+	if bg.dropped != nil {
+		bg.dropped[b.Filename] = reason
+	}
+}
+
 const minerId = "william"
 
 var validBlockOps = []*BlockOp{
@@ -84,6 +97,13 @@ var validBlockOps = []*BlockOp{
 
 const numberOfZeros = 4
 
+// ops are deduplicated by id, so tests that need many jobs have to make them different
+func distinctOp(i int) *BlockOp {
+	op := *validBlockOps[0]
+	op.Creator = strconv.Itoa(i)
+	return &op
+}
+
 func TestBlockGeneration(t *testing.T) {
 	t.Run("generates no-op blocks", func(t *testing.T) {
 		listener := blkGenList{
@@ -92,7 +112,7 @@ func TestBlockGeneration(t *testing.T) {
 			getHighestRoot: new(int),
 			validate:       new(int),
 		}
-		bc := NewBlockCalculator(listener, numberOfZeros, numberOfZeros, 10, 100, 1)
+		bc := NewBlockCalculator(listener, numberOfZeros, numberOfZeros, 10, 100, 1, NewMempool(0, 0, 0))
 		bc.StartThreads()
 		time.Sleep(time.Second)
 		bc.ShutdownThreads()
@@ -110,7 +130,7 @@ func TestBlockGeneration(t *testing.T) {
 			validate:        new(int),
 			blockOps:        validBlockOps,
 		}
-		bc := NewBlockCalculator(listener, numberOfZeros, numberOfZeros,10, 100, 1)
+		bc := NewBlockCalculator(listener, numberOfZeros, numberOfZeros, 10, 100, 1, NewMempool(0, 0, 0))
 		bc.StartThreads()
 		bc.AddJob(validBlockOps[0])
 		time.Sleep(time.Second)
@@ -128,9 +148,9 @@ func TestBlockGeneration(t *testing.T) {
 			validate:        new(int),
 			blockOps:        validBlockOps,
 		}
-		bc := NewBlockCalculator(listener, numberOfZeros, numberOfZeros,10, 100, 1)
+		bc := NewBlockCalculator(listener, numberOfZeros, numberOfZeros, 10, 100, 1, NewMempool(0, 0, 0))
 		for i := 0; i < 21; i++ {
-			bc.AddJob(validBlockOps[0])
+			bc.AddJob(distinctOp(i))
 		}
 		bc.StartThreads()
 		time.Sleep(time.Second * 3)
@@ -148,9 +168,9 @@ func TestBlockGeneration(t *testing.T) {
 			validate:        new(int),
 			blockOps:        validBlockOps,
 		}
-		bc := NewBlockCalculator(listener, numberOfZeros, numberOfZeros,10, 100, -1)
+		bc := NewBlockCalculator(listener, numberOfZeros, numberOfZeros, 10, 100, -1, NewMempool(0, 0, 0))
 		for i := 0; i < 300; i++ {
-			bc.AddJob(validBlockOps[0])
+			bc.AddJob(distinctOp(i))
 		}
 		bc.StartThreads()
 		time.Sleep(time.Second * 5)
@@ -169,7 +189,7 @@ func TestBlockGeneration(t *testing.T) {
 			validate:        new(int),
 			blockOps:        validBlockOps,
 		}
-		bc := NewBlockCalculator(listener, numberOfZeros, numberOfZeros,10, 100, 1)
+		bc := NewBlockCalculator(listener, numberOfZeros, numberOfZeros, 10, 100, 1, NewMempool(0, 0, 0))
 		for i := 0; i < 300; i++ {
 			bc.AddJob(validBlockOps[0])
 		}
@@ -192,19 +212,15 @@ func TestBlockGeneration(t *testing.T) {
 			validate:        new(int),
 			blockOps:        validBlockOps,
 		}
-		bc := NewBlockCalculator(listener, numberOfZeros, numberOfZeros,10, 500, 1)
+		bc := NewBlockCalculator(listener, numberOfZeros, numberOfZeros, 10, 500, 1, NewMempool(0, 0, 0))
 
 		for i := 0; i < 2; i++ {
-			bop := validBlockOps[0]
-			bop.Creator = strconv.Itoa(rand.Int())
-			bc.AddJob(bop)
+			bc.AddJob(distinctOp(rand.Int()))
 		}
 		bc.StartThreads()
 		time.Sleep(time.Millisecond * 100)
 		for i := 0; i < 18; i++ {
-			bop := validBlockOps[0]
-			bop.Creator = strconv.Itoa(rand.Int())
-			bc.AddJob(bop)
+			bc.AddJob(distinctOp(rand.Int()))
 		}
 		time.Sleep(time.Second * 3)
 		bc.ShutdownThreads()
@@ -223,12 +239,10 @@ func TestBlockGeneration(t *testing.T) {
 			longestNum:      &lgInt,
 			blockOps:        validBlockOps,
 		}
-		bc := NewBlockCalculator(listener, numberOfZeros, numberOfZeros,10, 500, 10)
+		bc := NewBlockCalculator(listener, numberOfZeros, numberOfZeros, 10, 500, 10, NewMempool(0, 0, 0))
 
 		for i := 0; i < 2; i++ {
-			bop := validBlockOps[0]
-			bop.Creator = strconv.Itoa(rand.Int())
-			bc.AddJob(bop)
+			bc.AddJob(distinctOp(rand.Int()))
 		}
 		bc.StartThreads()
 		time.Sleep(time.Second * 3)
@@ -244,6 +258,87 @@ func TestBlockGeneration(t *testing.T) {
 	})
 }
 
+func TestFeePriority(t *testing.T) {
+	t.Run("builds blocks with the highest fees first", func(t *testing.T) {
+		listener := blkGenList{
+			validate: new(int),
+			echoOps:  true,
+		}
+		bc := NewBlockCalculator(listener, numberOfZeros, numberOfZeros, 2, 0, 1, NewMempool(0, 0, 0))
+		for i, tip := range []uint32{1, 5, 0, 3} {
+			bc.AddJob(&BlockOp{
+				Type:     CreateFile,
+				Filename: strconv.Itoa(i),
+				Creator:  minerId,
+				Tip:      tip,
+			})
+		}
+
+		ops := getBlockOps(bc)
+		equals(t, 2, len(ops))
+		equals(t, uint32(5), ops[0].Tip)
+		equals(t, uint32(3), ops[1].Tip)
+
+		ops = getBlockOps(bc)
+		equals(t, 2, len(ops))
+		equals(t, uint32(1), ops[0].Tip)
+		equals(t, uint32(0), ops[1].Tip)
+	})
+
+	t.Run("keeps arrival order for jobs with the same fee", func(t *testing.T) {
+		listener := blkGenList{
+			validate: new(int),
+			echoOps:  true,
+		}
+		bc := NewBlockCalculator(listener, numberOfZeros, numberOfZeros, 10, 0, 1, NewMempool(0, 0, 0))
+		for i := 0; i < 5; i++ {
+			bc.AddJob(&BlockOp{
+				Type:     CreateFile,
+				Filename: strconv.Itoa(i),
+				Creator:  minerId,
+			})
+		}
+
+		ops := getBlockOps(bc)
+		equals(t, 5, len(ops))
+		for i, op := range ops {
+			equals(t, strconv.Itoa(i), op.Filename)
+		}
+	})
+
+	t.Run("reports ops rejected by the validator", func(t *testing.T) {
+		listener := blkGenList{
+			validate: new(int),
+			blockOps: validBlockOps,
+			dropped:  make(map[string]shared.FailureType),
+		}
+		bc := NewBlockCalculator(listener, numberOfZeros, numberOfZeros, 10, 0, 1, NewMempool(0, 0, 0))
+		bc.AddJob(&BlockOp{Type: CreateFile, Filename: "invalid", Creator: minerId})
+
+		getBlockOps(bc)
+		equals(t, shared.FailureType(shared.OP_REJECTED), listener.dropped["invalid"])
+	})
+
+	t.Run("doesn't let a tip reorder appends to the same file", func(t *testing.T) {
+		listener := blkGenList{
+			validate: new(int),
+			echoOps:  true,
+		}
+		bc := NewBlockCalculator(listener, numberOfZeros, numberOfZeros, 10, 0, 1, NewMempool(0, 0, 0))
+		bc.AddJob(&BlockOp{Type: AppendFile, Filename: "a", RecordNumber: 0, Creator: minerId})
+		bc.AddJob(&BlockOp{Type: AppendFile, Filename: "a", RecordNumber: 1, Creator: minerId, Tip: 10})
+		bc.AddJob(&BlockOp{Type: CreateFile, Filename: "b", Creator: minerId, Tip: 5})
+
+		ops := getBlockOps(bc)
+		equals(t, 3, len(ops))
+		equals(t, "a", ops[0].Filename)
+		equals(t, uint16(0), ops[0].RecordNumber)
+		equals(t, "b", ops[1].Filename)
+		equals(t, "a", ops[2].Filename)
+		equals(t, uint16(1), ops[2].RecordNumber)
+	})
+}
+
 // Taken from https://github.com/benbjohnson/testing
 // assert fails the test if the condition is false.
 func assert(tb testing.TB, condition bool, msg string, v ...interface{}) {
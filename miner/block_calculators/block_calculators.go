@@ -2,14 +2,12 @@ package block_calculators
 
 import (
 	"../../crypto"
-	"../../shared/datastruct"
+	"../../shared"
 	"bytes"
-	"container/heap"
 	"crypto/md5"
 	"io/ioutil"
 	"log"
-	"math"
-	"reflect"
+	"sort"
 	"sync"
 	"time"
 )
@@ -21,11 +19,15 @@ type BlockCalculatorListener interface {
 	GetMinerId() string
 	ValidateJobSet(bOps []*crypto.BlockOp) ([]*crypto.BlockOp, error, error)
 	InLongestChain(id string) int
+	// total amount of coins the creator of the op pays, base fee plus tip
+	GetOpFee(b *crypto.BlockOp) int
+	// called when an op leaves the mempool without being mined
+	OnJobDropped(b *crypto.BlockOp, reason shared.FailureType)
 }
 
 type BlockCalculator struct {
 	listener                  BlockCalculatorListener
-	jobSet                    *datastruct.PriorityQueue
+	jobSet                    *Mempool
 	noopSuspended             bool
 	opSuspended               bool
 	shutdownThreads           bool
@@ -38,45 +40,69 @@ type BlockCalculator struct {
 }
 
 var lg = log.New(ioutil.Discard, "calculators: ", log.Lmicroseconds|log.Lshortfile)
-var counter = math.MaxInt32
 
-func (bc *BlockCalculator) AddJob(b *crypto.BlockOp) {
+// Jobs are ordered by the fee they pay, jobs with the same fee are mined in arrival order.
+// Returns false if the job was already pending or the mempool is full of more valuable jobs.
+func (bc *BlockCalculator) AddJob(b *crypto.BlockOp) bool {
 	bc.noopSuspended = true
 	bc.mtx.Lock()
-	defer bc.mtx.Unlock()
-	item := datastruct.Item{
-		Value:    b,
-		Priority: counter,
+	if bc.jobSet.Exists(b) {
+		bc.mtx.Unlock()
+		return false
+	}
+	added, evicted := bc.jobSet.Add(b, bc.listener.GetOpFee(b))
+	bc.mtx.Unlock()
+
+	if !added {
+		evicted = append(evicted, b)
+	}
+	bc.dropJobs(evicted, shared.OP_EVICTED)
+	return added
+}
+
+// Puts back the jobs of a block that didn't make it into the longest chain, they keep the time and
+// height they were first added at
+func (bc *BlockCalculator) requeueJobs(ops []*crypto.BlockOp) {
+	bc.noopSuspended = true
+	for _, b := range ops {
+		bc.mtx.Lock()
+		pending := bc.jobSet.pending(b.Id())
+		added, evicted := bc.jobSet.Requeue(b, bc.listener.GetOpFee(b))
+		bc.mtx.Unlock()
+
+		if !added && !pending {
+			evicted = append(evicted, b)
+		}
+		bc.dropJobs(evicted, shared.OP_EVICTED)
 	}
-	counter -= 1
-	heap.Push(bc.jobSet, &item)
 }
 
-func (bc *BlockCalculator) JobExists(b *crypto.BlockOp) int {
+func (bc *BlockCalculator) JobExists(b *crypto.BlockOp) bool {
 	bc.mtx.Lock()
 	defer bc.mtx.Unlock()
-	return bc.jobExists(b)
-}
-func (bc *BlockCalculator) jobExists(b *crypto.BlockOp) int {
-	eqFn := func(j interface{}) bool {
-		job := j.(*crypto.BlockOp)
-		return reflect.DeepEqual(*job, *b)
-	}
-	return bc.jobSet.Find(eqFn)
+	return bc.jobSet.Exists(b)
 }
 
 func (bc *BlockCalculator) RemoveJobsFromBlock(block *crypto.Block) {
 	bc.mtx.Lock()
-	defer bc.mtx.Unlock()
 	for _, rc := range block.Records {
-		for hpIdx := bc.jobExists(rc); hpIdx >= 0; hpIdx = bc.jobExists(rc) {
-			heap.Remove(bc.jobSet, hpIdx)
-		}
+		bc.jobSet.Remove(rc)
 	}
+	expired := bc.jobSet.OnNewBlock()
+	bc.mtx.Unlock()
+
+	bc.dropJobs(expired, shared.OP_EXPIRED)
 	bc.opSuspended = true
 	bc.noopSuspended = true
 }
 
+func (bc *BlockCalculator) dropJobs(ops []*crypto.BlockOp, reason shared.FailureType) {
+	for _, op := range ops {
+		lg.Printf("Dropping job for file %v due to %v", op.Filename, reason)
+		bc.listener.OnJobDropped(op, reason)
+	}
+}
+
 func (bc *BlockCalculator) RestartBlockCalculation() {
 	bc.opSuspended = true
 	bc.noopSuspended = true
@@ -150,17 +176,13 @@ func JobsCalculator(bc *BlockCalculator) {
 					if !addedToLongestChainValidation(bc, newBlock) {
 						// re-enqueue jobs if we didn't add and start from scratch
 						lg.Printf("Block wasn't added to blockchain, putting it on the backburner")
-						for _, r := range newBlock.Records {
-							bc.AddJob(r)
-						}
+						bc.requeueJobs(newBlock.Records)
 					}
 					break
 				} else if bc.opSuspended {
 					// if the op was suspended, retry doing the job again, worst case we filter out the op
 					// when its repeated
-					for _, r := range newBlock.Records {
-						bc.AddJob(r)
-					}
+					bc.requeueJobs(newBlock.Records)
 					break
 				}
 			}
@@ -174,7 +196,7 @@ func JobsCalculator(bc *BlockCalculator) {
 
 func getBlockOps(bc *BlockCalculator) []*crypto.BlockOp {
 	bc.mtx.Lock()
-	defer bc.mtx.Unlock()
+	expired := bc.jobSet.Expire(time.Now())
 	bOps := make([]*crypto.BlockOp, 0, bc.opsPerBlock)
 	for i := 0; i < (bc.opsPerBlock + 1) && bc.jobSet.Len() > 0; i++ {
 		if i == 0 {
@@ -182,22 +204,63 @@ func getBlockOps(bc *BlockCalculator) []*crypto.BlockOp {
 			time.Sleep(time.Millisecond * bc.timePerBlockTimeoutMillis)
 			bc.mtx.Lock()
 		} else {
-			blk := heap.Pop(bc.jobSet).(*datastruct.Item).Value.(*crypto.BlockOp)
-			bOps = append(bOps, blk)
+			bOps = append(bOps, bc.jobSet.Pop())
 		}
 	}
+	bc.mtx.Unlock()
+	bc.dropJobs(expired, shared.OP_EXPIRED)
 
+	sortAppendsByRecordNumber(bOps)
 	newOps, _, _ := bc.listener.ValidateJobSet(bOps)
+
+	// let whoever submitted the ops that didn't make it know about it
+	valid := make(map[*crypto.BlockOp]bool, len(newOps))
+	for _, op := range newOps {
+		valid[op] = true
+	}
+	for _, op := range bOps {
+		if !valid[op] {
+			bc.mtx.Lock()
+			bc.jobSet.Remove(op)
+			bc.mtx.Unlock()
+			bc.listener.OnJobDropped(op, shared.OP_REJECTED)
+		}
+	}
 	return newOps
 }
 
+// A higher tip on a later record must not put it in front of the earlier records of the same file,
+// otherwise the validator would reject it. Only the slots taken by appends of each file get reordered.
+func sortAppendsByRecordNumber(bOps []*crypto.BlockOp) {
+	slots := make(map[string][]int)
+	for i, op := range bOps {
+		if op.Type == crypto.AppendFile {
+			slots[op.Filename] = append(slots[op.Filename], i)
+		}
+	}
+	for _, idxs := range slots {
+		ops := make([]*crypto.BlockOp, len(idxs))
+		for i, idx := range idxs {
+			ops[i] = bOps[idx]
+		}
+		sort.SliceStable(ops, func(i, j int) bool {
+			return ops[i].RecordNumber < ops[j].RecordNumber
+		})
+		for i, idx := range idxs {
+			bOps[idx] = ops[i]
+		}
+	}
+}
+
 func NewBlockCalculator(state BlockCalculatorListener,
 	opNumberOfZeros int,
 	noOpNumberOfZeros int,
 	opsPerBlock int,
-	blockTimeout time.Duration, maxConfirm int) *BlockCalculator {
+	blockTimeout time.Duration,
+	maxConfirm int,
+	mempool *Mempool) *BlockCalculator {
 	bc := &BlockCalculator{
-		jobSet:                    new(datastruct.PriorityQueue),
+		jobSet:                    mempool,
 		listener:                  state,
 		mtx:                       new(sync.Mutex),
 		opNumberOfZeros:           opNumberOfZeros,
@@ -206,6 +269,5 @@ func NewBlockCalculator(state BlockCalculatorListener,
 		timePerBlockTimeoutMillis: blockTimeout,
 		maxConfirm: maxConfirm,
 	}
-	heap.Init(bc.jobSet)
 	return bc
 }
@@ -0,0 +1,210 @@
+package block_calculators
+
+import (
+	"../../crypto"
+	"../../shared/datastruct"
+	"container/heap"
+	"time"
+)
+
+// Ops waiting to be mined, indexed by op id and ordered by the fee they pay.
+// A capacity, expiryBlocks or expiry of 0 means there is no limit.
+type Mempool struct {
+	jobs         *datastruct.PriorityQueue
+	index        map[string]*datastruct.Item
+	capacity     int
+	expiryBlocks int
+	expiry       time.Duration
+	height       int
+	sequence     int
+	// popped to be mined, kept until they are mined or put back
+	taken map[string]*datastruct.Item
+	// same ops as jobs from the oldest to the newest, expiring them only looks at the ones that expire
+	byAge *ageQueue
+}
+
+type pendingJob struct {
+	op            *crypto.BlockOp
+	id            string
+	addedAt       time.Time
+	addedAtHeight int
+	// position of the job in byAge, -1 once it's out of it
+	ageIndex int
+}
+
+// Items are added in the order of their Sequence, which is the order of the time and height they were
+// added at as well, requeued items keep theirs
+type ageQueue []*datastruct.Item
+
+func (q ageQueue) Len() int { return len(q) }
+
+func (q ageQueue) Less(i, j int) bool { return q[i].Sequence < q[j].Sequence }
+
+func (q ageQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].Value.(*pendingJob).ageIndex = i
+	q[j].Value.(*pendingJob).ageIndex = j
+}
+
+func (q *ageQueue) Push(x interface{}) {
+	item := x.(*datastruct.Item)
+	item.Value.(*pendingJob).ageIndex = len(*q)
+	*q = append(*q, item)
+}
+
+func (q *ageQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	item.Value.(*pendingJob).ageIndex = -1
+	*q = old[:len(old)-1]
+	return item
+}
+
+// Adds an op with the given priority, if the pool is full the op with the lowest priority gets evicted.
+// Returns whether the op was added and the ops that were evicted to make space for it.
+func (m *Mempool) Add(op *crypto.BlockOp, priority int) (bool, []*crypto.BlockOp) {
+	item := &datastruct.Item{
+		Value: &pendingJob{
+			op:            op,
+			id:            op.Id(),
+			addedAt:       time.Now(),
+			addedAtHeight: m.height,
+		},
+		Priority: priority,
+		Sequence: m.sequence,
+	}
+	m.sequence += 1
+	return m.add(item)
+}
+
+// Puts back an op that was popped but didn't make it into the chain. It keeps the time, height and
+// place in the arrival order it was first added with, so it still expires when it would have.
+func (m *Mempool) Requeue(op *crypto.BlockOp, priority int) (bool, []*crypto.BlockOp) {
+	item, ok := m.taken[op.Id()]
+	if !ok {
+		return m.Add(op, priority)
+	}
+	delete(m.taken, op.Id())
+	item.Priority = priority
+	return m.add(item)
+}
+
+func (m *Mempool) add(item *datastruct.Item) (bool, []*crypto.BlockOp) {
+	id := item.Value.(*pendingJob).id
+	if _, taken := m.taken[id]; taken || m.pending(id) {
+		return false, nil
+	}
+
+	evicted := make([]*crypto.BlockOp, 0)
+	if m.capacity > 0 && m.jobs.Len() >= m.capacity {
+		lowest := m.lowest()
+		if lowest == nil || lowest.Priority >= item.Priority {
+			// the new op is the cheapest one, it doesn't get in
+			return false, nil
+		}
+		evicted = append(evicted, m.remove(lowest))
+	}
+
+	m.index[id] = item
+	heap.Push(m.jobs, item)
+	heap.Push(m.byAge, item)
+	return true, evicted
+}
+
+// Whether the op waits in the pool or was popped to be mined and not removed or requeued yet, so that
+// an op being mined can't be added again and mined twice
+func (m *Mempool) Exists(op *crypto.BlockOp) bool {
+	_, taken := m.taken[op.Id()]
+	return taken || m.pending(op.Id())
+}
+
+// Whether the op with the given id waits in the pool
+func (m *Mempool) pending(id string) bool {
+	_, ok := m.index[id]
+	return ok
+}
+
+func (m *Mempool) Remove(op *crypto.BlockOp) bool {
+	delete(m.taken, op.Id())
+	item, ok := m.index[op.Id()]
+	if ok {
+		m.remove(item)
+	}
+	return ok
+}
+
+// Pops the op with the highest priority, nil if the pool is empty. The op is remembered until it is
+// removed or requeued.
+func (m *Mempool) Pop() *crypto.BlockOp {
+	if m.jobs.Len() == 0 {
+		return nil
+	}
+	item := heap.Pop(m.jobs).(*datastruct.Item)
+	job := item.Value.(*pendingJob)
+	heap.Remove(m.byAge, job.ageIndex)
+	delete(m.index, job.id)
+	m.taken[job.id] = item
+	return job.op
+}
+
+func (m *Mempool) Len() int {
+	return m.jobs.Len()
+}
+
+// Call once per new block, returns the ops that have waited for too many blocks
+func (m *Mempool) OnNewBlock() []*crypto.BlockOp {
+	m.height += 1
+	return m.Expire(time.Now())
+}
+
+// Removes and returns all of the ops that are too old either in blocks or in time, oldest first. Only
+// the ops that expire and the next oldest one are looked at.
+func (m *Mempool) Expire(now time.Time) []*crypto.BlockOp {
+	expired := make([]*crypto.BlockOp, 0)
+	for m.byAge.Len() > 0 {
+		item := (*m.byAge)[0]
+		job := item.Value.(*pendingJob)
+		tooManyBlocks := m.expiryBlocks > 0 && m.height-job.addedAtHeight >= m.expiryBlocks
+		tooOld := m.expiry > 0 && now.Sub(job.addedAt) >= m.expiry
+		if !tooManyBlocks && !tooOld {
+			break
+		}
+		expired = append(expired, m.remove(item))
+	}
+	return expired
+}
+
+// Only called when the pool is full, the lowest priority item is always a leaf of the heap
+func (m *Mempool) lowest() *datastruct.Item {
+	var lowest *datastruct.Item
+	for i := m.jobs.Len() / 2; i < m.jobs.Len(); i++ {
+		item := (*m.jobs)[i]
+		if lowest == nil || m.jobs.Less(lowest.Index(), item.Index()) {
+			lowest = item
+		}
+	}
+	return lowest
+}
+
+func (m *Mempool) remove(item *datastruct.Item) *crypto.BlockOp {
+	job := item.Value.(*pendingJob)
+	heap.Remove(m.jobs, item.Index())
+	heap.Remove(m.byAge, job.ageIndex)
+	delete(m.index, job.id)
+	return job.op
+}
+
+func NewMempool(capacity int, expiryBlocks int, expiry time.Duration) *Mempool {
+	m := &Mempool{
+		jobs:         new(datastruct.PriorityQueue),
+		index:        make(map[string]*datastruct.Item),
+		byAge:        new(ageQueue),
+		taken:        make(map[string]*datastruct.Item),
+		capacity:     capacity,
+		expiryBlocks: expiryBlocks,
+		expiry:       expiry,
+	}
+	heap.Init(m.jobs)
+	heap.Init(m.byAge)
+	return m
+}
@@ -0,0 +1,123 @@
+package block_calculators
+
+import (
+	. "../../crypto"
+	"testing"
+	"time"
+)
+
+func TestMempool(t *testing.T) {
+	t.Run("ignores ops that are already pending", func(t *testing.T) {
+		m := NewMempool(0, 0, 0)
+		added, _ := m.Add(distinctOp(1), 1)
+		assert(t, added, "should add the first op")
+		added, _ = m.Add(distinctOp(1), 1)
+		assert(t, !added, "should not add the same op twice")
+		equals(t, 1, m.Len())
+	})
+
+	t.Run("pops ops by priority", func(t *testing.T) {
+		m := NewMempool(0, 0, 0)
+		m.Add(distinctOp(1), 1)
+		m.Add(distinctOp(2), 3)
+		m.Add(distinctOp(3), 2)
+		equals(t, "2", m.Pop().Creator)
+		equals(t, "3", m.Pop().Creator)
+		equals(t, "1", m.Pop().Creator)
+		assert(t, m.Pop() == nil, "should be empty")
+	})
+
+	t.Run("removes ops by id", func(t *testing.T) {
+		m := NewMempool(0, 0, 0)
+		m.Add(distinctOp(1), 1)
+		m.Add(distinctOp(2), 1)
+		assert(t, m.Remove(distinctOp(1)), "should remove a copy of a pending op")
+		assert(t, !m.Exists(distinctOp(1)), "should not exist anymore")
+		assert(t, m.Exists(distinctOp(2)), "other ops stay in the pool")
+	})
+
+	t.Run("evicts the lowest priority op when full", func(t *testing.T) {
+		m := NewMempool(3, 0, 0)
+		m.Add(distinctOp(1), 2)
+		m.Add(distinctOp(2), 1)
+		m.Add(distinctOp(3), 1)
+		added, evicted := m.Add(distinctOp(4), 5)
+		assert(t, added, "should add an op that pays more")
+		equals(t, 1, len(evicted))
+		// between two ops with the same fee the newest one goes
+		equals(t, "3", evicted[0].Creator)
+		equals(t, 3, m.Len())
+	})
+
+	t.Run("rejects ops that pay less than everything in a full pool", func(t *testing.T) {
+		m := NewMempool(2, 0, 0)
+		m.Add(distinctOp(1), 2)
+		m.Add(distinctOp(2), 2)
+		added, evicted := m.Add(distinctOp(3), 2)
+		assert(t, !added, "should not add an op that pays the same as the cheapest")
+		equals(t, 0, len(evicted))
+		equals(t, 2, m.Len())
+	})
+
+	t.Run("expires ops after a number of blocks", func(t *testing.T) {
+		m := NewMempool(0, 2, 0)
+		m.Add(distinctOp(1), 1)
+		equals(t, 0, len(m.OnNewBlock()))
+		m.Add(distinctOp(2), 1)
+		expired := m.OnNewBlock()
+		equals(t, 1, len(expired))
+		equals(t, "1", expired[0].Creator)
+		equals(t, 1, m.Len())
+	})
+
+	t.Run("expires ops after some time", func(t *testing.T) {
+		m := NewMempool(0, 0, time.Minute)
+		m.Add(distinctOp(1), 1)
+		equals(t, 0, len(m.Expire(time.Now())))
+		expired := m.Expire(time.Now().Add(time.Minute))
+		equals(t, []*BlockOp{distinctOp(1)}, expired)
+		equals(t, 0, m.Len())
+	})
+
+	t.Run("expires the oldest ops first", func(t *testing.T) {
+		m := NewMempool(0, 0, time.Minute)
+		m.Add(distinctOp(1), 1)
+		m.Add(distinctOp(2), 5)
+		m.Add(distinctOp(3), 3)
+		m.Requeue(m.Pop(), 5)
+		expired := m.Expire(time.Now().Add(time.Minute))
+		equals(t, []*BlockOp{distinctOp(1), distinctOp(2), distinctOp(3)}, expired)
+		equals(t, 0, m.Len())
+	})
+
+	t.Run("keeps ops that are being mined from being added again", func(t *testing.T) {
+		m := NewMempool(0, 0, 0)
+		m.Add(distinctOp(1), 1)
+		popped := m.Pop()
+		assert(t, m.Exists(distinctOp(1)), "an op being mined should exist")
+		added, _ := m.Add(distinctOp(1), 1)
+		assert(t, !added, "should not add an op that is being mined")
+
+		added, _ = m.Requeue(popped, 1)
+		assert(t, added, "should put the op back")
+		m.Remove(m.Pop())
+		assert(t, !m.Exists(distinctOp(1)), "a mined op should not exist anymore")
+	})
+
+	t.Run("keeps the time and height of a requeued op", func(t *testing.T) {
+		m := NewMempool(0, 2, time.Minute)
+		m.Add(distinctOp(1), 1)
+		m.Add(distinctOp(2), 1)
+		popped := m.Pop()
+		equals(t, 0, len(m.OnNewBlock()))
+
+		added, _ := m.Requeue(popped, 1)
+		assert(t, added, "should put the op back")
+		equals(t, "1", m.Pop().Creator)
+		m.Requeue(popped, 1)
+		equals(t, 2, len(m.OnNewBlock()))
+		m.Add(distinctOp(3), 1)
+		m.Requeue(m.Pop(), 1)
+		equals(t, []*BlockOp{distinctOp(3)}, m.Expire(time.Now().Add(time.Minute)))
+	})
+}
@@ -0,0 +1,115 @@
+package instance
+
+import (
+	"../../shared"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Serves the operator of the miner, the only one who can spend the coins of the miner:
+//
+//   GET    /balance                        coins of the miner, or of ?account= if given
+//   POST   /transfer                       transfer coins of the miner to a recipient
+//
+// A transfer takes a body with the recipient, the amount, a tip and an op id, and blocks until it is
+// confirmed. It fails right away if the miner can't pay for it, unless it takes a ?wait= like the
+// writes of the HTTP gateway. Requests aren't authenticated, so the miner only serves them on a
+// loopback address. Errors are answered like in the HTTP gateway.
+type AdminHandler struct {
+	ListenHost string
+
+	miner *Miner
+}
+
+type adminTransferRequest struct {
+	Recipient string `json:"recipient"`
+	Amount    uint32 `json:"amount"`
+	Tip       uint32 `json:"tip"`
+	OpId      string `json:"opId"`
+}
+
+type adminResponse struct {
+	Account   string `json:"account,omitempty"`
+	Balance   *int   `json:"balance,omitempty"`
+	Recipient string `json:"recipient,omitempty"`
+	Amount    uint32 `json:"amount,omitempty"`
+}
+
+// The miner serves its operator until the listener fails, like the HTTP gateway it doesn't hold up the
+// miner. Addresses that aren't loopback ones are refused.
+func (a AdminHandler) ListenForOperator() error {
+	host, _, err := net.SplitHostPort(a.ListenHost)
+	if err != nil {
+		lg.Printf("Error resolving the admin address: %v\n", err)
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		err = fmt.Errorf("admin address %s is not a loopback address", a.ListenHost)
+		lg.Println(err)
+		return err
+	}
+	err = http.ListenAndServe(a.ListenHost, a)
+	lg.Printf("Error listening for the operator: %v\n", err)
+	return err
+}
+
+func (a AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the handlers stop waiting once the operator is gone
+	cancel := make(chan bool)
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		select {
+		case <-r.Context().Done():
+			close(cancel)
+		case <-done:
+		}
+	}()
+	miner := (*a.miner).AsOperator().WithCancel(cancel)
+
+	switch path := strings.Trim(r.URL.Path, "/"); {
+	case path == "balance" && r.Method == http.MethodGet:
+		a.balance(w, r, miner)
+	case path == "transfer" && r.Method == http.MethodPost:
+		a.transfer(w, r, miner)
+	case path == "balance" || path == "transfer":
+		writeHttpError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED")
+	default:
+		writeHttpError(w, http.StatusNotFound, "NOT_FOUND")
+	}
+}
+
+func (a AdminHandler) balance(w http.ResponseWriter, r *http.Request, miner Miner) {
+	account := r.URL.Query().Get("account")
+	balance, errorType := miner.BalanceHandler(account)
+	if errorType != shared.NO_ERROR {
+		writeFailure(w, errorType)
+		return
+	}
+	writeHttpResponse(w, http.StatusOK, adminResponse{Account: account, Balance: &balance})
+}
+
+func (a AdminHandler) transfer(w http.ResponseWriter, r *http.Request, miner Miner) {
+	body := adminTransferRequest{}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeHttpError(w, http.StatusBadRequest, "BAD_BODY")
+		return
+	}
+	waitMode, timeout, err := queryWait(r)
+	if err != nil {
+		writeHttpError(w, http.StatusBadRequest, "BAD_WAIT")
+		return
+	}
+	miner = miner.WithFundsWait(waitMode, timeout)
+
+	errorType := miner.TransferHandler(body.Recipient, body.Amount, body.Tip, body.OpId)
+	if errorType != shared.NO_ERROR {
+		writeWriteFailure(w, miner, errorType)
+		return
+	}
+	writeHttpResponse(w, http.StatusOK, adminResponse{Recipient: body.Recipient, Amount: body.Amount})
+}
@@ -0,0 +1,71 @@
+package instance
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminHandler(t *testing.T) {
+	var minerInstance Miner = MockMiner{}
+	server := httptest.NewServer(AdminHandler{miner: &minerInstance})
+	defer server.Close()
+
+	request := func(method string, path string, body interface{}) (*http.Response, map[string]interface{}) {
+		buf := new(bytes.Buffer)
+		if body != nil {
+			ok(t, json.NewEncoder(buf).Encode(body))
+		}
+		req, err := http.NewRequest(method, server.URL+path, buf)
+		ok(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		ok(t, err)
+		defer resp.Body.Close()
+		decoded := make(map[string]interface{})
+		ok(t, json.NewDecoder(resp.Body).Decode(&decoded))
+		return resp, decoded
+	}
+
+	t.Run("should transfer the coins of the miner", func(t *testing.T) {
+		resp, body := request(http.MethodPost, "/transfer", map[string]interface{}{"recipient": "client-1", "amount": 5})
+		equals(t, http.StatusOK, resp.StatusCode)
+		equals(t, "client-1", body["recipient"])
+		equals(t, float64(5), body["amount"])
+
+		resp, body = request(http.MethodPost, "/transfer", map[string]interface{}{"recipient": "client-1"})
+		equals(t, http.StatusBadRequest, resp.StatusCode)
+		equals(t, "INVALID_TRANSFER", body["error"])
+	})
+
+	t.Run("should report balances", func(t *testing.T) {
+		resp, body := request(http.MethodGet, "/balance", nil)
+		equals(t, http.StatusOK, resp.StatusCode)
+		equals(t, float64(100), body["balance"])
+
+		resp, body = request(http.MethodGet, "/balance?account=client-1", nil)
+		equals(t, http.StatusOK, resp.StatusCode)
+		equals(t, "client-1", body["account"])
+		equals(t, float64(10), body["balance"])
+	})
+
+	t.Run("should answer unknown paths and methods", func(t *testing.T) {
+		resp, _ := request(http.MethodGet, "/transfer", nil)
+		equals(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+		resp, _ = request(http.MethodGet, "/files", nil)
+		equals(t, http.StatusNotFound, resp.StatusCode)
+
+		resp, body := request(http.MethodPost, "/transfer", "recipient")
+		equals(t, http.StatusBadRequest, resp.StatusCode)
+		equals(t, "BAD_BODY", body["error"])
+	})
+
+	t.Run("should refuse to serve the operator on an address that isn't a loopback one", func(t *testing.T) {
+		assert(t, AdminHandler{ListenHost: "0.0.0.0:0", miner: &minerInstance}.ListenForOperator() != nil,
+			"should refuse to listen on every address")
+		assert(t, AdminHandler{ListenHost: "10.0.0.1:0", miner: &minerInstance}.ListenForOperator() != nil,
+			"should refuse to listen on another host")
+	})
+}
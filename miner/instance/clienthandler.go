@@ -2,8 +2,6 @@ package instance
 
 import (
 	"../../shared"
-	"bytes"
-	"encoding/gob"
 	"io"
 	"net"
 	"sync"
@@ -45,76 +43,305 @@ func (c ClientHandler) ListenForClients() error {
 // Once a client connection has been accepted, the miner is always servicing requests from the client.
 func (c ClientHandler) ServiceClientRequest(conn net.Conn) error {
 	minerInstance := c.miner
-	for {
-		// Make a buffer to hold incoming data.
-		requestBuf := make([]byte, 1024)
+	frames := shared.NewFrameReader(conn)
+
+	// Responses of concurrent requests are written one frame at a time
+	writeMux := &sync.Mutex{}
+	send := func(msg interface{}) error {
+		writeMux.Lock()
+		defer writeMux.Unlock()
+		err := shared.WriteFrame(conn, msg)
+		if err != nil {
+			lg.Println(err)
+			conn.Close()
+		}
+		return err
+	}
+
+	// The requests still being served stop waiting once the client is gone
+	cancels := newRequestCancels()
+	defer cancels.cancelAll()
 
-		// Read the incoming connection into the buffer.
-		readLen, err := conn.Read(requestBuf)
+	// A WATCH takes over the connection, so it has to be the first request on it
+	first := true
+	for {
+		// Read and decode the next client request.
+		clientRequest := shared.RFSClientRequest{}
+		err := frames.Read(&clientRequest)
 		if err != nil {
+			if _, ok := err.(shared.FrameDecodeError); ok {
+				// The frame holds something else than a request, skip it.
+				lg.Println(err)
+				continue
+			}
 			if err == io.EOF {
 				// Client connection has been closed.
 				lg.Println("Closing client connection")
-				conn.Close()
-				return err
 			} else {
-				// Some other sort of error. Continue trying to read.
-				lg.Println(err)
-				continue
+				// Malformed frame or broken connection, nothing else can be read from it.
+				lg.Println(err, ", closing client connection")
 			}
+			conn.Close()
+			return err
 		}
 
-		// Decode the client request.
-		clientRequest := shared.RFSClientRequest{}
-		var reader = bytes.NewReader(requestBuf[:readLen])
-		dec := gob.NewDecoder(reader)
-		err = dec.Decode(&clientRequest)
-		if err != nil {
-			lg.Println(err)
+		if clientRequest.RequestType == shared.WATCH && !first {
+			send(shared.RFSMinerResponse{RequestId: clientRequest.RequestId, ErrorType: shared.WATCH_NOT_FIRST})
 			continue
 		}
+		first = false
 
-		// Direct the request to the proper handler and create response
-		var responseBuf bytes.Buffer
-		enc := gob.NewEncoder(&responseBuf)
-		minerResponse := shared.RFSMinerResponse{ErrorType: shared.NO_ERROR}
-
-		switch clientRequest.RequestType {
-		case shared.CREATE_FILE:
-			createFileError := (*minerInstance).CreateFileHandler(clientRequest.FileName)
-			minerResponse.ErrorType = createFileError
-		case shared.LIST_FILES:
-			fnames, listFilesError := (*minerInstance).ListFilesHandler()
-			minerResponse.FileNames = fnames
-			minerResponse.ErrorType = listFilesError
-		case shared.TOTAL_RECS:
-			numRecs, totalRecsError := (*minerInstance).TotalRecsHandler(clientRequest.FileName)
-			minerResponse.NumRecords = numRecs
-			minerResponse.ErrorType = totalRecsError
-		case shared.READ_REC:
-			readRec, readRecError := (*minerInstance).ReadRecHandler(
-				clientRequest.FileName, clientRequest.RecordNum)
-			minerResponse.ReadRecord = readRec
-			minerResponse.ErrorType = readRecError
-		case shared.APPEND_REC:
-			recordNum, appendRecError :=
-				(*minerInstance).AppendRecHandler(clientRequest.FileName, clientRequest.AppendRecord)
-			minerResponse.RecordNum = recordNum
-			minerResponse.ErrorType = appendRecError
-		case shared.DELETE_FILE:
-			deleteFileError := (*minerInstance).DeleteRecHandler(clientRequest.FileName)
-			minerResponse.ErrorType = deleteFileError
-		default:
-			// Invalid request type, ignore it
+		if clientRequest.RequestType == shared.WATCH {
+			events, unwatch, watchError := (*minerInstance).WatchHandler(clientRequest.FileName, clientRequest.WatchPrefix)
+			minerResponse := shared.RFSMinerResponse{RequestId: clientRequest.RequestId, ErrorType: watchError}
+			if watchError == shared.NO_ERROR {
+				// from now on the connection only carries the events of the watch
+				return streamWatchEvents(conn, send, minerResponse, events, unwatch)
+			}
+			send(minerResponse)
 			continue
 		}
 
-		// Send a response back to the client.
-		err = enc.Encode(minerResponse)
-		if err != nil {
-			lg.Println(err)
+		if clientRequest.RequestType == shared.CANCEL {
+			// the cancelled request answers with REQUEST_CANCELLED, the cancel itself gets no response
+			cancels.cancel(clientRequest.CancelId)
+			continue
+		}
+
+		// Requests are served concurrently so that a slow one doesn't hold back the others
+		cancel := cancels.add(clientRequest.RequestId)
+		go func(clientRequest shared.RFSClientRequest) {
+			// the ops of the request are paid by the client account it comes from, if any, which may wait
+			// for coins as long as the request allows. Writes claiming any other account are rejected.
+			cancellable := (*minerInstance).WithCancel(cancel).WithIdentity(clientRequest.Creator, clientRequest.Signatures).
+				WithFundsWait(clientRequest.FundsWaitMode, clientRequest.FundsWaitTimeout)
+			minerResponse, valid := serveClientRequest(&cancellable, clientRequest)
+			cancels.remove(clientRequest.RequestId, cancel)
+			if valid {
+				send(minerResponse)
+			}
+		}(clientRequest)
+	}
+}
+
+// Directs the request to the proper handler and creates the response, valid is false if the request
+// type is unknown
+func serveClientRequest(minerInstance *Miner, clientRequest shared.RFSClientRequest) (
+	minerResponse shared.RFSMinerResponse, valid bool) {
+	// Direct the request to the proper handler and create response
+	minerResponse = shared.RFSMinerResponse{RequestId: clientRequest.RequestId, ErrorType: shared.NO_ERROR}
+
+	// Reads are answered at the confirmation depth they ask for
+	readDepthError := shared.FailureType(shared.NO_ERROR)
+	switch clientRequest.RequestType {
+	case shared.LIST_FILES, shared.TOTAL_RECS, shared.READ_REC, shared.READ_RECS:
+		minerResponse.ReadDepth, readDepthError =
+			(*minerInstance).ReadDepth(clientRequest.UseReadDepth, clientRequest.ReadDepth)
+	}
+
+	switch clientRequest.RequestType {
+	case shared.CREATE_FILE:
+		if clientRequest.Async {
+			minerResponse.OpId, minerResponse.ErrorType = submit(minerInstance, clientRequest)
+			break
+		}
+		createFileError := (*minerInstance).CreateFileHandler(
+			clientRequest.FileName, clientRequest.Tip, clientRequest.OpId)
+		minerResponse.ErrorType = createFileError
+	case shared.LIST_FILES:
+		if readDepthError != shared.NO_ERROR {
+			minerResponse.ErrorType = readDepthError
+			break
+		}
+		fnames, block, listFilesError := (*minerInstance).ListFilesHandler(minerResponse.ReadDepth)
+		minerResponse.FileNames = fnames
+		minerResponse.ReadBlock = block
+		minerResponse.ErrorType = listFilesError
+	case shared.TOTAL_RECS:
+		if readDepthError != shared.NO_ERROR {
+			minerResponse.ErrorType = readDepthError
+			break
+		}
+		numRecs, block, totalRecsError :=
+			(*minerInstance).TotalRecsHandler(clientRequest.FileName, minerResponse.ReadDepth)
+		minerResponse.NumRecords = numRecs
+		minerResponse.ReadBlock = block
+		minerResponse.ErrorType = totalRecsError
+	case shared.READ_REC:
+		if readDepthError != shared.NO_ERROR {
+			minerResponse.ErrorType = readDepthError
+			break
+		}
+		readRec, block, readRecError := (*minerInstance).ReadRecHandler(clientRequest.FileName,
+			clientRequest.RecordNum, clientRequest.WaitMode, clientRequest.WaitTimeout, minerResponse.ReadDepth)
+		minerResponse.ReadRecord = readRec
+		minerResponse.ReadBlock = block
+		minerResponse.ErrorType = readRecError
+	case shared.READ_RECS:
+		if readDepthError != shared.NO_ERROR {
+			minerResponse.ErrorType = readDepthError
+			break
+		}
+		readRecs, numRecs, block, readRecsError := (*minerInstance).ReadRecsHandler(clientRequest.FileName,
+			clientRequest.RecordNum, clientRequest.ReadCount, minerResponse.ReadDepth)
+		minerResponse.ReadRecords = readRecs
+		minerResponse.NumRecords = numRecs
+		minerResponse.ReadBlock = block
+		minerResponse.ErrorType = readRecsError
+	case shared.APPEND_REC:
+		if clientRequest.Async {
+			minerResponse.OpId, minerResponse.ErrorType = submit(minerInstance, clientRequest)
+			break
+		}
+		recordNum, appendRecError :=
+			(*minerInstance).AppendRecHandler(
+				clientRequest.FileName, clientRequest.AppendRecord, clientRequest.Tip, clientRequest.OpId)
+		minerResponse.RecordNum = recordNum
+		minerResponse.ErrorType = appendRecError
+	case shared.APPEND_REC_AT:
+		appendRecAtError := (*minerInstance).AppendRecAtHandler(clientRequest.FileName,
+			clientRequest.RecordNum, clientRequest.AppendRecord, clientRequest.Tip, clientRequest.OpId)
+		minerResponse.RecordNum = clientRequest.RecordNum
+		minerResponse.ErrorType = appendRecAtError
+	case shared.APPEND_RECS:
+		recordNums, appendRecsError :=
+			(*minerInstance).AppendRecsHandler(
+				clientRequest.FileName, clientRequest.AppendRecords, clientRequest.Tip, clientRequest.OpId)
+		minerResponse.RecordNums = recordNums
+		minerResponse.ErrorType = appendRecsError
+	case shared.TRANSACTION:
+		recordNums, transactionError :=
+			(*minerInstance).TransactionHandler(clientRequest.TransactionOps, clientRequest.Tip, clientRequest.OpId)
+		minerResponse.RecordNums = recordNums
+		minerResponse.ErrorType = transactionError
+	case shared.DELETE_FILE:
+		if clientRequest.Async {
+			minerResponse.OpId, minerResponse.ErrorType = submit(minerInstance, clientRequest)
+			break
+		}
+		deleteFileError := (*minerInstance).DeleteRecHandler(
+			clientRequest.FileName, clientRequest.Tip, clientRequest.OpId)
+		minerResponse.ErrorType = deleteFileError
+	case shared.GET_HEADERS:
+		headers, headersError := (*minerInstance).HeadersHandler(clientRequest.Locator)
+		minerResponse.Headers = headers
+		minerResponse.ErrorType = headersError
+	case shared.TRANSFER:
+		transferError := (*minerInstance).TransferHandler(
+			clientRequest.Recipient, clientRequest.Amount, clientRequest.Tip, clientRequest.OpId)
+		minerResponse.ErrorType = transferError
+	case shared.GET_BALANCE:
+		balance, balanceError := (*minerInstance).BalanceHandler(clientRequest.Creator)
+		minerResponse.Balance = balance
+		minerResponse.ErrorType = balanceError
+	case shared.OP_STATUS:
+		opStatus, opStatusError := (*minerInstance).OpStatusHandler(
+			clientRequest.OpId, clientRequest.WaitMode, clientRequest.WaitTimeout)
+		minerResponse.OpId = clientRequest.OpId
+		minerResponse.OpStatus = opStatus
+		minerResponse.ErrorType = opStatusError
+	default:
+		// Invalid request type, ignore it
+		return minerResponse, false
+	}
+
+	// Writes that can't be paid for tell how short their account is
+	if minerResponse.ErrorType == shared.NOT_ENOUGH_MONEY {
+		minerResponse.Balance, minerResponse.Needed = (*minerInstance).Shortfall()
+	}
+
+	return minerResponse, true
+}
+
+// Starts an async request, the response only carries its ticket
+func submit(minerInstance *Miner, clientRequest shared.RFSClientRequest) (string, shared.FailureType) {
+	return (*minerInstance).SubmitHandler(clientRequest.RequestType, clientRequest.FileName,
+		clientRequest.AppendRecord, clientRequest.Tip, clientRequest.OpId)
+}
+
+// Sends the response to the watch request followed by its events, until the client closes the connection
+func streamWatchEvents(conn net.Conn, send func(interface{}) error, minerResponse shared.RFSMinerResponse,
+	events <-chan shared.WatchEvent, unwatch func()) error {
+	defer unwatch()
+	defer conn.Close()
+
+	// the client doesn't send anything else, reading only tells us when it leaves
+	left := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := conn.Read(buf); err != nil {
+				left <- err
+				return
+			}
+		}
+	}()
+
+	err := send(minerResponse)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case err := <-left:
+			lg.Println("Closing watch connection")
 			return err
+		case event, open := <-events:
+			if !open {
+				lg.Println("Closing watch connection, the client fell behind")
+				return nil
+			}
+			err := send(event)
+			if err != nil {
+				return err
+			}
 		}
-		conn.Write(responseBuf.Bytes())
+	}
+}
+
+// Cancel channels of the requests of a connection that are being served, by request id
+type requestCancels struct {
+	mtx     *sync.Mutex
+	cancels map[uint64]chan bool
+}
+
+func newRequestCancels() *requestCancels {
+	return &requestCancels{mtx: &sync.Mutex{}, cancels: make(map[uint64]chan bool)}
+}
+
+// Returns the channel that is closed once the request gets cancelled
+func (rc *requestCancels) add(requestId uint64) <-chan bool {
+	rc.mtx.Lock()
+	defer rc.mtx.Unlock()
+	cancel := make(chan bool)
+	rc.cancels[requestId] = cancel
+	return cancel
+}
+
+// Forgets a request that was served, unless another request reused its id
+func (rc *requestCancels) remove(requestId uint64, cancel <-chan bool) {
+	rc.mtx.Lock()
+	defer rc.mtx.Unlock()
+	if current, ok := rc.cancels[requestId]; ok && current == cancel {
+		delete(rc.cancels, requestId)
+	}
+}
+
+// Cancels a request, does nothing if it was already served
+func (rc *requestCancels) cancel(requestId uint64) {
+	rc.mtx.Lock()
+	defer rc.mtx.Unlock()
+	if cancel, ok := rc.cancels[requestId]; ok {
+		close(cancel)
+		delete(rc.cancels, requestId)
+	}
+}
+
+func (rc *requestCancels) cancelAll() {
+	rc.mtx.Lock()
+	defer rc.mtx.Unlock()
+	for requestId, cancel := range rc.cancels {
+		close(cancel)
+		delete(rc.cancels, requestId)
 	}
 }
@@ -1,9 +1,8 @@
 package instance
 
 import (
+	"../../crypto"
 	. "../../shared"
-	"bytes"
-	"encoding/gob"
 	"fmt"
 	"io"
 	"math/rand"
@@ -27,32 +26,155 @@ func init() {
 }
 
 type MockMiner struct {
+	cancel <-chan bool
+	creator string
+	fundsWait WaitMode
+	operator bool
 }
 
-func (m MockMiner) DeleteRecHandler(fname string) (errorType FailureType) {
+func (m MockMiner) DeleteRecHandler(fname string, tip uint32, opId string) (errorType FailureType) {
 	return NO_ERROR
 }
 
-func (m MockMiner) CreateFileHandler(fname string) (errorType FailureType) {
+func (m MockMiner) CreateFileHandler(fname string, tip uint32, opId string) (errorType FailureType) {
+	if fname == "Expensive" && m.fundsWait == NO_WAIT {
+		return NOT_ENOUGH_MONEY
+	}
 	return NO_ERROR
 }
 
-func (m MockMiner) ListFilesHandler() (fnames []string, errorType FailureType) {
-	return []string{"File1", "File2", "File3"}, NO_ERROR
+func (m MockMiner) SubmitHandler(requestType RequestType, fname string, record [512]byte, tip uint32, opId string) (ticket string, errorType FailureType) {
+	return "ticket", NO_ERROR
+}
+
+func (m MockMiner) OpStatusHandler(opId string, waitMode WaitMode, timeout time.Duration) (status OpStatus, errorType FailureType) {
+	if opId != "ticket" {
+		return OpStatus{}, UNKNOWN_OP
+	}
+	return OpStatus{State: OP_MINED, FileName: "FileName", Block: "block", Depth: 1}, NO_ERROR
+}
+
+// The op of the ticket is mined and then confirmed
+func (m MockMiner) WatchOpHandler(opId string) (statuses <-chan OpStatus, errorType FailureType) {
+	if opId != "ticket" {
+		return nil, UNKNOWN_OP
+	}
+	changes := make(chan OpStatus, 2)
+	changes <- OpStatus{State: OP_MINED, FileName: "FileName", Block: "block", Depth: 1}
+	changes <- OpStatus{State: OP_CONFIRMED, FileName: "FileName", Block: "block", Depth: 4}
+	close(changes)
+	return changes, NO_ERROR
+}
+
+func (m MockMiner) ReadDepth(useDepth bool, depth int) (readDepth ConfirmDepth, errorType FailureType) {
+	if !useDepth {
+		return ConfirmDepth{Create: 5, Append: 3}, NO_ERROR
+	}
+	if depth < MIN_READ_DEPTH || depth > MAX_READ_DEPTH {
+		return ConfirmDepth{}, INVALID_READ_DEPTH
+	}
+	return ConfirmDepth{Create: depth, Append: depth}, NO_ERROR
 }
 
-func (m MockMiner) TotalRecsHandler(fname string) (numRecs uint16, errorType FailureType) {
-	return 3, NO_ERROR
+func (m MockMiner) ListFilesHandler(depth ConfirmDepth) (fnames []string, block string, errorType FailureType) {
+	return []string{"File1", "File2", "File3"}, "block", NO_ERROR
 }
 
-func (m MockMiner) ReadRecHandler(fname string, recordNum uint16) (record [512]byte, errorType FailureType) {
-	return [512]byte{}, NO_ERROR
+func (m MockMiner) TotalRecsHandler(fname string, depth ConfirmDepth) (numRecs uint16, block string, errorType FailureType) {
+	return 3, "block", NO_ERROR
 }
 
-func (m MockMiner) AppendRecHandler(fname string, record [512]byte) (recordNum uint16, errorType FailureType) {
+func (m MockMiner) ReadRecHandler(fname string, recordNum uint16, waitMode WaitMode, timeout time.Duration, depth ConfirmDepth) (record [512]byte, block string, errorType FailureType) {
+	if recordNum > 0 && waitMode == NO_WAIT {
+		return [512]byte{}, "block", RECORD_DOES_NOT_EXIST
+	}
+	if waitMode == WAIT_TIMEOUT {
+		select {
+		case <-time.After(timeout):
+		case <-m.cancel:
+			return [512]byte{}, "", REQUEST_CANCELLED
+		}
+	}
+	return [512]byte{}, "block", NO_ERROR
+}
+
+func (m MockMiner) ReadRecsHandler(fname string, start uint16, count uint16, depth ConfirmDepth) (records [][512]byte, numRecs uint16, block string, errorType FailureType) {
+	return make([][512]byte, count), count, "block", NO_ERROR
+}
+
+func (m MockMiner) AppendRecHandler(fname string, record [512]byte, tip uint32, opId string) (recordNum uint16, errorType FailureType) {
 	return 0, NO_ERROR
 }
 
+func (m MockMiner) AppendRecAtHandler(fname string, recordNum uint16, record [512]byte, tip uint32, opId string) (errorType FailureType) {
+	return NO_ERROR
+}
+
+func (m MockMiner) AppendRecsHandler(fname string, records [][512]byte, tip uint32, opId string) (recordNums []uint16, errorType FailureType) {
+	return make([]uint16, len(records)), NO_ERROR
+}
+
+func (m MockMiner) TransactionHandler(ops []TransactionOp, tip uint32, opId string) (recordNums []uint16, errorType FailureType) {
+	return []uint16{}, NO_ERROR
+}
+
+func (m MockMiner) WithCancel(cancel <-chan bool) Miner {
+	m.cancel = cancel
+	return m
+}
+
+func (m MockMiner) WithIdentity(creator string, signatures [][]byte) Miner {
+	m.creator = creator
+	return m
+}
+
+func (m MockMiner) WithFundsWait(waitMode WaitMode, timeout time.Duration) Miner {
+	m.fundsWait = waitMode
+	return m
+}
+
+// Creating Expensive costs 200 coins out of the 100 of the miner, it fails unless the request waits for coins
+func (m MockMiner) AsOperator() Miner {
+	m.operator = true
+	return m
+}
+
+func (m MockMiner) Shortfall() (balance int, needed int) {
+	return 100, 200
+}
+
+// Only the operator can transfer the coins of the miner
+func (m MockMiner) TransferHandler(recipient string, amount uint32, tip uint32, opId string) (errorType FailureType) {
+	if m.creator == "" && !m.operator {
+		return BAD_SIGNATURE
+	}
+	if recipient == "" || amount == 0 {
+		return INVALID_TRANSFER
+	}
+	return NO_ERROR
+}
+
+// Clients have 10 coins, the miner has 100
+func (m MockMiner) BalanceHandler(account string) (balance int, errorType FailureType) {
+	if account == "" {
+		account = m.creator
+	}
+	if account == "" {
+		return 100, NO_ERROR
+	}
+	return 10, NO_ERROR
+}
+
+func (m MockMiner) WatchHandler(fname string, prefix bool) (events <-chan WatchEvent, unwatch func(), errorType FailureType) {
+	ch := make(chan WatchEvent, 1)
+	ch <- WatchEvent{Type: FILE_CREATED, FileName: fname}
+	return ch, func() {}, NO_ERROR
+}
+
+func (m MockMiner) HeadersHandler(locator []string) (headers []crypto.Block, errorType FailureType) {
+	return []crypto.Block{{Type: crypto.RegularBlock, MinerId: "miner"}}, NO_ERROR
+}
+
 func TestListenForClients(t *testing.T) {
 
 	t.Run("should return error if given address is invalid", func(t *testing.T) {
@@ -91,12 +213,11 @@ func TestServiceClientRequest(t *testing.T) {
 	serviceClientRequestWrapper := func(testInstance ClientHandler, conn net.Conn) {
 		serviceError = testInstance.ServiceClientRequest(conn)
 	}
+	// listen before any of the clients dials in
+	testInstance.ListenHost = minerAddr
+	listener, err := net.ListenTCP("tcp", maddr)
+	ok(t, err)
 	go func() {
-		testInstance.ListenHost = minerAddr
-		addr, err := net.ResolveTCPAddr("tcp", minerAddr)
-		ok(t, err)
-		listener, err := net.ListenTCP("tcp", addr)
-		ok(t, err)
 		for {
 			conn, err := listener.Accept()
 			ok(t, err)
@@ -132,6 +253,36 @@ func TestServiceClientRequest(t *testing.T) {
 		assert(t, timeout, "should timeout the second time since this request was never parsed")
 	})
 
+	t.Run("should read requests split across several writes", func(t *testing.T) {
+		clientAddr := fmt.Sprintf("127.0.0.1:%v", generateNextPort())
+		caddr, _ := net.ResolveTCPAddr("tcp", clientAddr)
+		serviceError = nil
+		connClient, err := net.DialTCP("tcp", caddr, maddr)
+		ok(t, err)
+		connClient.SetNoDelay(true)
+		frame, err := EncodeFrame(RFSClientRequest{RequestType: LIST_FILES})
+		ok(t, err)
+		for _, b := range frame {
+			_, err = connClient.Write([]byte{b})
+			ok(t, err)
+		}
+		_, timeout := getResponseOrTimeout(connClient, t)
+		assert(t, !timeout, "should get response once the whole request arrived")
+	})
+
+	t.Run("should close connection on a malformed frame", func(t *testing.T) {
+		clientAddr := fmt.Sprintf("127.0.0.1:%v", generateNextPort())
+		caddr, _ := net.ResolveTCPAddr("tcp", clientAddr)
+		serviceError = nil
+		connClient, err := net.DialTCP("tcp", caddr, maddr)
+		ok(t, err)
+		_, err = connClient.Write([]byte{0xff, 0xff, 0xff, 0xff, 1, 2, 3})
+		ok(t, err)
+		connClient.SetReadDeadline(time.Now().Add(time.Second * 3))
+		_, err = connClient.Read(make([]byte, 1))
+		assert(t, err == io.EOF, "connection should be closed by the miner")
+	})
+
 	t.Run("should respond to create file request", func(t *testing.T) {
 		clientAddr := fmt.Sprintf("127.0.0.1:%v", generateNextPort())
 		caddr, _ := net.ResolveTCPAddr("tcp", clientAddr)
@@ -144,6 +295,29 @@ func TestServiceClientRequest(t *testing.T) {
 		assert(t, !timeout, "should get response for create file request")
 	})
 
+	t.Run("should respond with a ticket to async requests and report its status", func(t *testing.T) {
+		clientAddr := fmt.Sprintf("127.0.0.1:%v", generateNextPort())
+		caddr, _ := net.ResolveTCPAddr("tcp", clientAddr)
+		serviceError = nil
+		connClient, err := net.DialTCP("tcp", caddr, maddr)
+		ok(t, err)
+		sendRequest(RFSClientRequest{RequestType: CREATE_FILE, FileName: "FileName", Async: true}, connClient, t)
+		response, timeout := getResponseOrTimeout(connClient, t)
+		assert(t, !timeout, "should get response for async create file request")
+		equals(t, "ticket", response.OpId)
+
+		sendRequest(RFSClientRequest{RequestType: OP_STATUS, OpId: response.OpId}, connClient, t)
+		response, timeout = getResponseOrTimeout(connClient, t)
+		assert(t, !timeout, "should get response for op status request")
+		equals(t, FailureType(NO_ERROR), response.ErrorType)
+		equals(t, OpStatus{State: OP_MINED, FileName: "FileName", Block: "block", Depth: 1}, response.OpStatus)
+
+		sendRequest(RFSClientRequest{RequestType: OP_STATUS, OpId: "other"}, connClient, t)
+		response, timeout = getResponseOrTimeout(connClient, t)
+		assert(t, !timeout, "should get response for op status request")
+		equals(t, FailureType(UNKNOWN_OP), response.ErrorType)
+	})
+
 	t.Run("should respond to delete file request", func(t *testing.T) {
 		clientAddr := fmt.Sprintf("127.0.0.1:%v", generateNextPort())
 		caddr, _ := net.ResolveTCPAddr("tcp", clientAddr)
@@ -164,8 +338,9 @@ func TestServiceClientRequest(t *testing.T) {
 		ok(t, err)
 		validRequest := RFSClientRequest{RequestType: LIST_FILES}
 		sendRequest(validRequest, connClient, t)
-		_, timeout := getResponseOrTimeout(connClient, t)
+		response, timeout := getResponseOrTimeout(connClient, t)
 		assert(t, !timeout, "should get response for list files request")
+		equals(t, "block", response.ReadBlock)
 	})
 
 	t.Run("should respond to total records request", func(t *testing.T) {
@@ -180,6 +355,31 @@ func TestServiceClientRequest(t *testing.T) {
 		assert(t, !timeout, "should get response for total records request")
 	})
 
+	t.Run("should answer reads at the requested confirmation depth", func(t *testing.T) {
+		clientAddr := fmt.Sprintf("127.0.0.1:%v", generateNextPort())
+		caddr, _ := net.ResolveTCPAddr("tcp", clientAddr)
+		serviceError = nil
+		connClient, err := net.DialTCP("tcp", caddr, maddr)
+		ok(t, err)
+		sendRequest(RFSClientRequest{RequestType: TOTAL_RECS, FileName: "FileName"}, connClient, t)
+		response, timeout := getResponseOrTimeout(connClient, t)
+		assert(t, !timeout, "should get response for total records request")
+		equals(t, ConfirmDepth{Create: 5, Append: 3}, response.ReadDepth)
+
+		sendRequest(RFSClientRequest{RequestType: TOTAL_RECS, FileName: "FileName",
+			UseReadDepth: true, ReadDepth: 0}, connClient, t)
+		response, timeout = getResponseOrTimeout(connClient, t)
+		assert(t, !timeout, "should get response for total records request")
+		equals(t, FailureType(NO_ERROR), response.ErrorType)
+		equals(t, ConfirmDepth{Create: 0, Append: 0}, response.ReadDepth)
+
+		sendRequest(RFSClientRequest{RequestType: LIST_FILES,
+			UseReadDepth: true, ReadDepth: MAX_READ_DEPTH + 1}, connClient, t)
+		response, timeout = getResponseOrTimeout(connClient, t)
+		assert(t, !timeout, "should get response for list files request")
+		equals(t, FailureType(INVALID_READ_DEPTH), response.ErrorType)
+	})
+
 	t.Run("should respond to read record request", func(t *testing.T) {
 		clientAddr := fmt.Sprintf("127.0.0.1:%v", generateNextPort())
 		caddr, _ := net.ResolveTCPAddr("tcp", clientAddr)
@@ -192,6 +392,46 @@ func TestServiceClientRequest(t *testing.T) {
 		assert(t, !timeout, "should get response for read record request")
 	})
 
+	t.Run("should respond with error to read record request of a missing record", func(t *testing.T) {
+		clientAddr := fmt.Sprintf("127.0.0.1:%v", generateNextPort())
+		caddr, _ := net.ResolveTCPAddr("tcp", clientAddr)
+		serviceError = nil
+		connClient, err := net.DialTCP("tcp", caddr, maddr)
+		ok(t, err)
+		validRequest := RFSClientRequest{RequestType: READ_REC, FileName: "FileName", RecordNum: 1, WaitMode: NO_WAIT}
+		sendRequest(validRequest, connClient, t)
+		response, timeout := getResponseOrTimeout(connClient, t)
+		assert(t, !timeout, "should get response for read record request")
+		equals(t, FailureType(RECORD_DOES_NOT_EXIST), response.ErrorType)
+	})
+
+	t.Run("should respond to read records request", func(t *testing.T) {
+		clientAddr := fmt.Sprintf("127.0.0.1:%v", generateNextPort())
+		caddr, _ := net.ResolveTCPAddr("tcp", clientAddr)
+		serviceError = nil
+		connClient, err := net.DialTCP("tcp", caddr, maddr)
+		ok(t, err)
+		validRequest := RFSClientRequest{RequestType: READ_RECS, FileName: "FileName", RecordNum: 0, ReadCount: 3}
+		sendRequest(validRequest, connClient, t)
+		response, timeout := getResponseOrTimeout(connClient, t)
+		assert(t, !timeout, "should get response for read records request")
+		equals(t, 3, len(response.ReadRecords))
+		equals(t, uint16(3), response.NumRecords)
+	})
+
+	t.Run("should respond to headers request", func(t *testing.T) {
+		clientAddr := fmt.Sprintf("127.0.0.1:%v", generateNextPort())
+		caddr, _ := net.ResolveTCPAddr("tcp", clientAddr)
+		serviceError = nil
+		connClient, err := net.DialTCP("tcp", caddr, maddr)
+		ok(t, err)
+		sendRequest(RFSClientRequest{RequestType: GET_HEADERS, Locator: []string{"block"}}, connClient, t)
+		response, timeout := getResponseOrTimeout(connClient, t)
+		assert(t, !timeout, "should get response for headers request")
+		equals(t, FailureType(NO_ERROR), response.ErrorType)
+		equals(t, 1, len(response.Headers))
+	})
+
 	t.Run("should respond to append record request", func(t *testing.T) {
 		clientAddr := fmt.Sprintf("127.0.0.1:%v", generateNextPort())
 		caddr, _ := net.ResolveTCPAddr("tcp", clientAddr)
@@ -205,6 +445,163 @@ func TestServiceClientRequest(t *testing.T) {
 		assert(t, !timeout, "should get response for append record request")
 	})
 
+	t.Run("should respond to append record at request", func(t *testing.T) {
+		clientAddr := fmt.Sprintf("127.0.0.1:%v", generateNextPort())
+		caddr, _ := net.ResolveTCPAddr("tcp", clientAddr)
+		serviceError = nil
+		connClient, err := net.DialTCP("tcp", caddr, maddr)
+		ok(t, err)
+		var record [512]byte
+		validRequest := RFSClientRequest{RequestType: APPEND_REC_AT, FileName: "FileName", RecordNum: 3, AppendRecord: record}
+		sendRequest(validRequest, connClient, t)
+		response, timeout := getResponseOrTimeout(connClient, t)
+		assert(t, !timeout, "should get response for append record at request")
+		equals(t, uint16(3), response.RecordNum)
+	})
+
+	t.Run("should stream watch events after responding to watch request", func(t *testing.T) {
+		clientAddr := fmt.Sprintf("127.0.0.1:%v", generateNextPort())
+		caddr, _ := net.ResolveTCPAddr("tcp", clientAddr)
+		serviceError = nil
+		connClient, err := net.DialTCP("tcp", caddr, maddr)
+		ok(t, err)
+		validRequest := RFSClientRequest{RequestType: WATCH, FileName: "FileName"}
+		sendRequest(validRequest, connClient, t)
+		connClient.SetReadDeadline(time.Now().Add(time.Second * 3))
+		frames := NewFrameReader(connClient)
+		response := RFSMinerResponse{}
+		ok(t, frames.Read(&response))
+		equals(t, FailureType(NO_ERROR), response.ErrorType)
+		event := WatchEvent{}
+		ok(t, frames.Read(&event))
+		equals(t, WatchEvent{Type: FILE_CREATED, FileName: "FileName"}, event)
+		connClient.Close()
+	})
+
+	t.Run("should refuse a watch that isn't the first request on its connection", func(t *testing.T) {
+		clientAddr := fmt.Sprintf("127.0.0.1:%v", generateNextPort())
+		caddr, _ := net.ResolveTCPAddr("tcp", clientAddr)
+		serviceError = nil
+		connClient, err := net.DialTCP("tcp", caddr, maddr)
+		ok(t, err)
+		sendRequest(RFSClientRequest{RequestId: 1, RequestType: LIST_FILES}, connClient, t)
+		sendRequest(RFSClientRequest{RequestId: 2, RequestType: WATCH, FileName: "FileName"}, connClient, t)
+		connClient.SetReadDeadline(time.Now().Add(time.Second * 3))
+		frames := NewFrameReader(connClient)
+		errorTypes := make(map[uint64]FailureType)
+		for i := 0; i < 2; i++ {
+			response := RFSMinerResponse{}
+			ok(t, frames.Read(&response))
+			errorTypes[response.RequestId] = response.ErrorType
+		}
+		equals(t, map[uint64]FailureType{1: NO_ERROR, 2: WATCH_NOT_FIRST}, errorTypes)
+
+		// the connection still serves requests
+		sendRequest(RFSClientRequest{RequestId: 3, RequestType: LIST_FILES}, connClient, t)
+		response := RFSMinerResponse{}
+		ok(t, frames.Read(&response))
+		equals(t, uint64(3), response.RequestId)
+		equals(t, []string{"File1", "File2", "File3"}, response.FileNames)
+		connClient.Close()
+	})
+
+	t.Run("should answer requests as they complete with their request ids", func(t *testing.T) {
+		clientAddr := fmt.Sprintf("127.0.0.1:%v", generateNextPort())
+		caddr, _ := net.ResolveTCPAddr("tcp", clientAddr)
+		serviceError = nil
+		connClient, err := net.DialTCP("tcp", caddr, maddr)
+		ok(t, err)
+		slowRequest := RFSClientRequest{RequestId: 1, RequestType: READ_REC, FileName: "FileName",
+			WaitMode: WAIT_TIMEOUT, WaitTimeout: time.Second}
+		fastRequest := RFSClientRequest{RequestId: 2, RequestType: LIST_FILES}
+		sendRequest(slowRequest, connClient, t)
+		sendRequest(fastRequest, connClient, t)
+		connClient.SetReadDeadline(time.Now().Add(time.Second * 3))
+		frames := NewFrameReader(connClient)
+		response := RFSMinerResponse{}
+		ok(t, frames.Read(&response))
+		equals(t, uint64(2), response.RequestId)
+		equals(t, []string{"File1", "File2", "File3"}, response.FileNames)
+		response = RFSMinerResponse{}
+		ok(t, frames.Read(&response))
+		equals(t, uint64(1), response.RequestId)
+		equals(t, FailureType(NO_ERROR), response.ErrorType)
+		connClient.Close()
+	})
+
+	t.Run("should answer a cancelled request right away", func(t *testing.T) {
+		clientAddr := fmt.Sprintf("127.0.0.1:%v", generateNextPort())
+		caddr, _ := net.ResolveTCPAddr("tcp", clientAddr)
+		serviceError = nil
+		connClient, err := net.DialTCP("tcp", caddr, maddr)
+		ok(t, err)
+		slowRequest := RFSClientRequest{RequestId: 1, RequestType: READ_REC, FileName: "FileName",
+			WaitMode: WAIT_TIMEOUT, WaitTimeout: time.Minute}
+		sendRequest(slowRequest, connClient, t)
+		sendRequest(RFSClientRequest{RequestId: 2, RequestType: CANCEL, CancelId: 1}, connClient, t)
+		response, timeout := getResponseOrTimeout(connClient, t)
+		assert(t, !timeout, "should get response once the request is cancelled")
+		equals(t, uint64(1), response.RequestId)
+		equals(t, FailureType(REQUEST_CANCELLED), response.ErrorType)
+		connClient.Close()
+	})
+
+	t.Run("should respond to transfer request", func(t *testing.T) {
+		clientAddr := fmt.Sprintf("127.0.0.1:%v", generateNextPort())
+		caddr, _ := net.ResolveTCPAddr("tcp", clientAddr)
+		serviceError = nil
+		connClient, err := net.DialTCP("tcp", caddr, maddr)
+		ok(t, err)
+		sendRequest(RFSClientRequest{RequestType: TRANSFER, Recipient: "client-1", Amount: 5, Creator: "client-2"},
+			connClient, t)
+		response, timeout := getResponseOrTimeout(connClient, t)
+		assert(t, !timeout, "should get response for transfer request")
+		equals(t, FailureType(NO_ERROR), response.ErrorType)
+		sendRequest(RFSClientRequest{RequestType: TRANSFER, Recipient: "client-1", Amount: 5}, connClient, t)
+		response, timeout = getResponseOrTimeout(connClient, t)
+		assert(t, !timeout, "should get response for transfer request")
+		equals(t, FailureType(BAD_SIGNATURE), response.ErrorType)
+		sendRequest(RFSClientRequest{RequestType: TRANSFER, Recipient: "client-1", Creator: "client-2"}, connClient, t)
+		response, timeout = getResponseOrTimeout(connClient, t)
+		assert(t, !timeout, "should get response for transfer request")
+		equals(t, FailureType(INVALID_TRANSFER), response.ErrorType)
+	})
+
+	t.Run("should respond with the shortfall to requests that can't be paid for", func(t *testing.T) {
+		clientAddr := fmt.Sprintf("127.0.0.1:%v", generateNextPort())
+		caddr, _ := net.ResolveTCPAddr("tcp", clientAddr)
+		serviceError = nil
+		connClient, err := net.DialTCP("tcp", caddr, maddr)
+		ok(t, err)
+		sendRequest(RFSClientRequest{RequestType: CREATE_FILE, FileName: "Expensive"}, connClient, t)
+		response, timeout := getResponseOrTimeout(connClient, t)
+		assert(t, !timeout, "should get response for create file request")
+		equals(t, FailureType(NOT_ENOUGH_MONEY), response.ErrorType)
+		equals(t, 100, response.Balance)
+		equals(t, 200, response.Needed)
+		sendRequest(RFSClientRequest{RequestType: CREATE_FILE, FileName: "Expensive", FundsWaitMode: WAIT_FOREVER},
+			connClient, t)
+		response, timeout = getResponseOrTimeout(connClient, t)
+		assert(t, !timeout, "should get response for create file request")
+		equals(t, FailureType(NO_ERROR), response.ErrorType)
+	})
+
+	t.Run("should respond to balance request of the account of the request", func(t *testing.T) {
+		clientAddr := fmt.Sprintf("127.0.0.1:%v", generateNextPort())
+		caddr, _ := net.ResolveTCPAddr("tcp", clientAddr)
+		serviceError = nil
+		connClient, err := net.DialTCP("tcp", caddr, maddr)
+		ok(t, err)
+		sendRequest(RFSClientRequest{RequestType: GET_BALANCE}, connClient, t)
+		response, timeout := getResponseOrTimeout(connClient, t)
+		assert(t, !timeout, "should get response for balance request")
+		equals(t, 100, response.Balance)
+		sendRequest(RFSClientRequest{RequestType: GET_BALANCE, Creator: "client-1"}, connClient, t)
+		response, timeout = getResponseOrTimeout(connClient, t)
+		assert(t, !timeout, "should get response for balance request")
+		equals(t, 10, response.Balance)
+	})
+
 	t.Run("should fail to parse the current request if invalid request type", func(t *testing.T) {
 		clientAddr := fmt.Sprintf("127.0.0.1:%v", generateNextPort())
 		caddr, _ := net.ResolveTCPAddr("tcp", clientAddr)
@@ -226,29 +623,19 @@ func generateNextPort() int {
 }
 
 func sendRequest(request interface{}, tcpConn *net.TCPConn, t *testing.T) {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(request)
-	ok(t, err)
-	_, err = tcpConn.Write(buf.Bytes())
+	err := WriteFrame(tcpConn, request)
 	ok(t, err)
 }
 
 // Returns the response and/or true if the read timed out
 func getResponseOrTimeout(tcpConn *net.TCPConn, t *testing.T) (RFSMinerResponse, bool) {
 	minerResponse := RFSMinerResponse{}
-	responseBuf := make([]byte, 1024)
 	tcpConn.SetReadDeadline(time.Now().Add(time.Second * 3))
-	readLen, err := tcpConn.Read(responseBuf)
+	err := NewFrameReader(tcpConn).Read(&minerResponse)
 	if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
 		return minerResponse, true
 	}
 	ok(t, err)
-
-	var reader = bytes.NewReader(responseBuf[:readLen])
-	dec := gob.NewDecoder(reader)
-	err = dec.Decode(&minerResponse)
-	ok(t, err)
 	return minerResponse, false
 }
 
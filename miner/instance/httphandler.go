@@ -0,0 +1,433 @@
+package instance
+
+import (
+	"../../shared"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Serves the RFS operations as a REST API with JSON bodies, for clients that can't use rfslib:
+//
+//   GET    /files                          list the files
+//   GET    /files/{name}                   number of records of a file
+//   PUT    /files/{name}                   create a file
+//   DELETE /files/{name}                   delete a file
+//   GET    /files/{name}/records           read records, from ?start= on, at most ?count= of them
+//   POST   /files/{name}/records           append a record
+//   GET    /files/{name}/records/{n}       read a record, ?wait=forever or ?wait=<duration> waits for it
+//
+// Reads take the confirmation depth they need as ?depth=. Writes take an optional body with the
+// record, a tip and an op id, and block until the op is confirmed unless ?stream=true is given, in
+// which case the status of the op is streamed as one JSON object per line until it is confirmed or
+// fails. Writes the miner can't pay for fail right away with the balance it has and the coins it
+// needs, unless they take a ?wait= to wait for it to mine them. Errors are answered with the status
+// code of their FailureType.
+type HttpHandler struct {
+	ListenHost string
+
+	miner *Miner
+}
+
+// Body of a create, append or delete, every field is optional. Data holds up to a record, it is
+// padded with zero bytes
+type httpWriteRequest struct {
+	Data []byte `json:"data"`
+	Tip  uint32 `json:"tip"`
+	OpId string `json:"opId"`
+}
+
+type httpResponse struct {
+	Files     []string `json:"files,omitempty"`
+	Name      string   `json:"name,omitempty"`
+	Records   *uint16  `json:"records,omitempty"`
+	RecordNum *uint16  `json:"recordNum,omitempty"`
+	Data      []byte   `json:"data,omitempty"`
+	Range     [][]byte `json:"range,omitempty"`
+	Block     string   `json:"block,omitempty"`
+}
+
+type httpError struct {
+	Error   string `json:"error"`
+	Balance *int   `json:"balance,omitempty"`
+	Needed  *int   `json:"needed,omitempty"`
+}
+
+// Line of a streamed write
+type httpOpStatus struct {
+	Ticket    string `json:"ticket"`
+	State     string `json:"state"`
+	Block     string `json:"block,omitempty"`
+	Depth     int    `json:"depth,omitempty"`
+	RecordNum uint16 `json:"recordNum,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+var failureNames = map[shared.FailureType]string{
+	shared.BAD_FILENAME:          "BAD_FILENAME",
+	shared.DISCONNECTED:          "DISCONNECTED",
+	shared.FILE_DOES_NOT_EXIST:   "FILE_DOES_NOT_EXIST",
+	shared.FILE_EXISTS:           "FILE_EXISTS",
+	shared.MAX_LEN_REACHED:       "MAX_LEN_REACHED",
+	shared.NOT_ENOUGH_MONEY:      "NOT_ENOUGH_MONEY",
+	shared.APPEND_DUPLICATE:      "APPEND_DUPLICATE",
+	shared.OP_REJECTED:           "OP_REJECTED",
+	shared.OP_EVICTED:            "OP_EVICTED",
+	shared.OP_EXPIRED:            "OP_EXPIRED",
+	shared.OP_DUPLICATE:          "OP_DUPLICATE",
+	shared.TRANSACTION_INVALID:   "TRANSACTION_INVALID",
+	shared.RECORD_CONFLICT:       "RECORD_CONFLICT",
+	shared.RECORD_DOES_NOT_EXIST: "RECORD_DOES_NOT_EXIST",
+	shared.INVALID_READ_DEPTH:    "INVALID_READ_DEPTH",
+	shared.UNKNOWN_OP:            "UNKNOWN_OP",
+	shared.REQUEST_CANCELLED:     "REQUEST_CANCELLED",
+	shared.BAD_SIGNATURE:         "BAD_SIGNATURE",
+	shared.INVALID_TRANSFER:      "INVALID_TRANSFER",
+	shared.OP_TIMED_OUT:          "OP_TIMED_OUT",
+	shared.INTERNAL_ERROR:        "INTERNAL_ERROR",
+}
+
+var failureStatuses = map[shared.FailureType]int{
+	shared.BAD_FILENAME:          http.StatusBadRequest,
+	shared.DISCONNECTED:          http.StatusServiceUnavailable,
+	shared.FILE_DOES_NOT_EXIST:   http.StatusNotFound,
+	shared.FILE_EXISTS:           http.StatusConflict,
+	shared.MAX_LEN_REACHED:       http.StatusConflict,
+	shared.NOT_ENOUGH_MONEY:      http.StatusPaymentRequired,
+	shared.APPEND_DUPLICATE:      http.StatusConflict,
+	shared.OP_REJECTED:           http.StatusConflict,
+	shared.OP_EVICTED:            http.StatusServiceUnavailable,
+	shared.OP_EXPIRED:            http.StatusGatewayTimeout,
+	shared.OP_DUPLICATE:          http.StatusConflict,
+	shared.TRANSACTION_INVALID:   http.StatusBadRequest,
+	shared.RECORD_CONFLICT:       http.StatusConflict,
+	shared.RECORD_DOES_NOT_EXIST: http.StatusNotFound,
+	shared.INVALID_READ_DEPTH:    http.StatusBadRequest,
+	shared.UNKNOWN_OP:            http.StatusNotFound,
+	shared.REQUEST_CANCELLED:     http.StatusRequestTimeout,
+	// the gateway has no client accounts, only miners that pay for their clients serve it
+	shared.BAD_SIGNATURE:         http.StatusForbidden,
+	shared.INVALID_TRANSFER:      http.StatusBadRequest,
+	shared.OP_TIMED_OUT:          http.StatusGatewayTimeout,
+	shared.INTERNAL_ERROR:        http.StatusInternalServerError,
+}
+
+var opStateNames = map[shared.OpState]string{
+	shared.OP_PENDING:   "pending",
+	shared.OP_MINED:     "mined",
+	shared.OP_CONFIRMED: "confirmed",
+	shared.OP_FAILED:    "failed",
+}
+
+// The miner serves HTTP clients until the listener fails, the gateway is optional so it doesn't
+// hold up the miner.
+func (h HttpHandler) ListenForHttpClients() error {
+	err := http.ListenAndServe(h.ListenHost, h)
+	lg.Printf("Error listening for HTTP clients: %v\n", err)
+	return err
+}
+
+func (h HttpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the handlers stop waiting once the client is gone
+	cancel := make(chan bool)
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		select {
+		case <-r.Context().Done():
+			close(cancel)
+		case <-done:
+		}
+	}()
+	miner := (*h.miner).WithCancel(cancel)
+
+	// file names are escaped, they can hold slashes
+	parts := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	for i, part := range parts {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			writeHttpError(w, http.StatusBadRequest, "BAD_PATH")
+			return
+		}
+		parts[i] = unescaped
+	}
+	if parts[0] != "files" || len(parts) > 4 || (len(parts) > 2 && parts[2] != "records") {
+		writeHttpError(w, http.StatusNotFound, "NOT_FOUND")
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		h.listFiles(w, r, miner)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		h.totalRecs(w, r, miner, parts[1])
+	case len(parts) == 2 && (r.Method == http.MethodPut || r.Method == http.MethodDelete):
+		h.write(w, r, miner, parts[1])
+	case len(parts) == 3 && r.Method == http.MethodGet:
+		h.readRecs(w, r, miner, parts[1])
+	case len(parts) == 3 && r.Method == http.MethodPost:
+		h.write(w, r, miner, parts[1])
+	case len(parts) == 4 && r.Method == http.MethodGet:
+		h.readRec(w, r, miner, parts[1], parts[3])
+	default:
+		writeHttpError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED")
+	}
+}
+
+func (h HttpHandler) listFiles(w http.ResponseWriter, r *http.Request, miner Miner) {
+	depth, ok := readDepth(w, r, miner)
+	if !ok {
+		return
+	}
+	fnames, block, errorType := miner.ListFilesHandler(depth)
+	if errorType != shared.NO_ERROR {
+		writeFailure(w, errorType)
+		return
+	}
+	writeHttpResponse(w, http.StatusOK, httpResponse{Files: fnames, Block: block})
+}
+
+func (h HttpHandler) totalRecs(w http.ResponseWriter, r *http.Request, miner Miner, fname string) {
+	depth, ok := readDepth(w, r, miner)
+	if !ok {
+		return
+	}
+	numRecs, block, errorType := miner.TotalRecsHandler(fname, depth)
+	if errorType != shared.NO_ERROR {
+		writeFailure(w, errorType)
+		return
+	}
+	writeHttpResponse(w, http.StatusOK, httpResponse{Name: fname, Records: &numRecs, Block: block})
+}
+
+func (h HttpHandler) readRec(w http.ResponseWriter, r *http.Request, miner Miner, fname string, recordNumParam string) {
+	recordNum, err := strconv.ParseUint(recordNumParam, 10, 16)
+	if err != nil {
+		writeHttpError(w, http.StatusBadRequest, "BAD_RECORD_NUM")
+		return
+	}
+	depth, ok := readDepth(w, r, miner)
+	if !ok {
+		return
+	}
+
+	waitMode, timeout, err := queryWait(r)
+	if err != nil {
+		writeHttpError(w, http.StatusBadRequest, "BAD_WAIT")
+		return
+	}
+
+	record, block, errorType := miner.ReadRecHandler(fname, uint16(recordNum), waitMode, timeout, depth)
+	if errorType != shared.NO_ERROR {
+		writeFailure(w, errorType)
+		return
+	}
+	num := uint16(recordNum)
+	writeHttpResponse(w, http.StatusOK, httpResponse{Name: fname, RecordNum: &num, Data: record[:], Block: block})
+}
+
+func (h HttpHandler) readRecs(w http.ResponseWriter, r *http.Request, miner Miner, fname string) {
+	start, err := queryUint16(r, "start", 0)
+	if err != nil {
+		writeHttpError(w, http.StatusBadRequest, "BAD_START")
+		return
+	}
+	count, err := queryUint16(r, "count", shared.MAX_RECS_PER_READ_REQUEST)
+	if err != nil {
+		writeHttpError(w, http.StatusBadRequest, "BAD_COUNT")
+		return
+	}
+	depth, ok := readDepth(w, r, miner)
+	if !ok {
+		return
+	}
+
+	records, numRecs, block, errorType := miner.ReadRecsHandler(fname, start, count, depth)
+	if errorType != shared.NO_ERROR {
+		writeFailure(w, errorType)
+		return
+	}
+	data := make([][]byte, len(records))
+	for i := range records {
+		data[i] = records[i][:]
+	}
+	writeHttpResponse(w, http.StatusOK, httpResponse{Name: fname, Records: &numRecs, Range: data, Block: block})
+}
+
+// Creates, deletes or appends to fname depending on the method, waiting for the op to be confirmed or
+// streaming its status
+func (h HttpHandler) write(w http.ResponseWriter, r *http.Request, miner Miner, fname string) {
+	body := httpWriteRequest{}
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			writeHttpError(w, http.StatusBadRequest, "BAD_BODY")
+			return
+		}
+	}
+	var record [512]byte
+	if len(body.Data) > len(record) {
+		writeHttpError(w, http.StatusBadRequest, "RECORD_TOO_BIG")
+		return
+	}
+	copy(record[:], body.Data)
+	waitMode, timeout, err := queryWait(r)
+	if err != nil {
+		writeHttpError(w, http.StatusBadRequest, "BAD_WAIT")
+		return
+	}
+	miner = miner.WithFundsWait(waitMode, timeout)
+
+	requestType := shared.APPEND_REC
+	switch r.Method {
+	case http.MethodPut:
+		requestType = shared.CREATE_FILE
+	case http.MethodDelete:
+		requestType = shared.DELETE_FILE
+	}
+
+	if r.URL.Query().Get("stream") == "true" {
+		streamOp(w, r, miner, requestType, fname, record, body)
+		return
+	}
+
+	switch requestType {
+	case shared.CREATE_FILE:
+		errorType := miner.CreateFileHandler(fname, body.Tip, body.OpId)
+		if errorType != shared.NO_ERROR {
+			writeWriteFailure(w, miner, errorType)
+			return
+		}
+		writeHttpResponse(w, http.StatusCreated, httpResponse{Name: fname})
+	case shared.DELETE_FILE:
+		errorType := miner.DeleteRecHandler(fname, body.Tip, body.OpId)
+		if errorType != shared.NO_ERROR {
+			writeWriteFailure(w, miner, errorType)
+			return
+		}
+		writeHttpResponse(w, http.StatusOK, httpResponse{Name: fname})
+	case shared.APPEND_REC:
+		recordNum, errorType := miner.AppendRecHandler(fname, record, body.Tip, body.OpId)
+		if errorType != shared.NO_ERROR {
+			writeWriteFailure(w, miner, errorType)
+			return
+		}
+		writeHttpResponse(w, http.StatusCreated, httpResponse{Name: fname, RecordNum: &recordNum})
+	}
+}
+
+// Submits the op and streams its status every time it changes, until it is confirmed, it fails or the
+// client leaves
+func streamOp(w http.ResponseWriter, r *http.Request, miner Miner, requestType shared.RequestType, fname string,
+	record [512]byte, body httpWriteRequest) {
+	ticket, errorType := miner.SubmitHandler(requestType, fname, record, body.Tip, body.OpId)
+	if errorType != shared.NO_ERROR {
+		writeFailure(w, errorType)
+		return
+	}
+	statuses, errorType := miner.WatchOpHandler(ticket)
+	if errorType != shared.NO_ERROR {
+		writeFailure(w, errorType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusAccepted)
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	// the miner stops the statuses once the client is gone, see ServeHTTP
+	for status := range statuses {
+		line := httpOpStatus{
+			Ticket:    ticket,
+			State:     opStateNames[status.State],
+			Block:     status.Block,
+			Depth:     status.Depth,
+			RecordNum: status.RecordNum}
+		if status.State == shared.OP_FAILED {
+			line.Error = failureNames[status.Reason]
+		}
+		if enc.Encode(line) != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// Confirmation depth of a read, the miner's one unless ?depth= is given. Answers the request and returns
+// false if the depth is not valid
+func readDepth(w http.ResponseWriter, r *http.Request, miner Miner) (shared.ConfirmDepth, bool) {
+	param := r.URL.Query().Get("depth")
+	depth, err := 0, error(nil)
+	if param != "" {
+		depth, err = strconv.Atoi(param)
+		if err != nil {
+			writeHttpError(w, http.StatusBadRequest, "BAD_DEPTH")
+			return shared.ConfirmDepth{}, false
+		}
+	}
+	readDepth, errorType := miner.ReadDepth(param != "", depth)
+	if errorType != shared.NO_ERROR {
+		writeFailure(w, errorType)
+		return shared.ConfirmDepth{}, false
+	}
+	return readDepth, true
+}
+
+// How long to wait, ?wait=forever or ?wait=<duration>, not at all if not given
+func queryWait(r *http.Request) (shared.WaitMode, time.Duration, error) {
+	switch wait := r.URL.Query().Get("wait"); wait {
+	case "":
+		return shared.NO_WAIT, 0, nil
+	case "forever":
+		return shared.WAIT_FOREVER, 0, nil
+	default:
+		timeout, err := time.ParseDuration(wait)
+		return shared.WAIT_TIMEOUT, timeout, err
+	}
+}
+
+func queryUint16(r *http.Request, name string, def uint16) (uint16, error) {
+	param := r.URL.Query().Get(name)
+	if param == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseUint(param, 10, 16)
+	return uint16(v), err
+}
+
+func writeFailure(w http.ResponseWriter, errorType shared.FailureType) {
+	status, ok := failureStatuses[errorType]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	writeHttpError(w, status, failureNames[errorType])
+}
+
+// Same as writeFailure, but writes the account couldn't pay for also tell how short it was
+func writeWriteFailure(w http.ResponseWriter, miner Miner, errorType shared.FailureType) {
+	if errorType != shared.NOT_ENOUGH_MONEY {
+		writeFailure(w, errorType)
+		return
+	}
+	balance, needed := miner.Shortfall()
+	writeHttpResponse(w, failureStatuses[errorType],
+		httpError{Error: failureNames[errorType], Balance: &balance, Needed: &needed})
+}
+
+func writeHttpError(w http.ResponseWriter, status int, name string) {
+	writeHttpResponse(w, status, httpError{Error: name})
+}
+
+func writeHttpResponse(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		lg.Println(err)
+	}
+}
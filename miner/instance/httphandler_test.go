@@ -0,0 +1,123 @@
+package instance
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHttpHandler(t *testing.T) {
+	var minerInstance Miner = MockMiner{}
+	server := httptest.NewServer(HttpHandler{miner: &minerInstance})
+	defer server.Close()
+
+	request := func(method string, path string, body interface{}) (*http.Response, map[string]interface{}) {
+		buf := new(bytes.Buffer)
+		if body != nil {
+			ok(t, json.NewEncoder(buf).Encode(body))
+		}
+		req, err := http.NewRequest(method, server.URL+path, buf)
+		ok(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		ok(t, err)
+		defer resp.Body.Close()
+		decoded := make(map[string]interface{})
+		ok(t, json.NewDecoder(resp.Body).Decode(&decoded))
+		return resp, decoded
+	}
+
+	t.Run("should list files", func(t *testing.T) {
+		resp, body := request(http.MethodGet, "/files", nil)
+		equals(t, http.StatusOK, resp.StatusCode)
+		equals(t, []interface{}{"File1", "File2", "File3"}, body["files"])
+		equals(t, "block", body["block"])
+	})
+
+	t.Run("should stat a file", func(t *testing.T) {
+		resp, body := request(http.MethodGet, "/files/File1?depth=0", nil)
+		equals(t, http.StatusOK, resp.StatusCode)
+		equals(t, float64(3), body["records"])
+	})
+
+	t.Run("should read a record and a range of records", func(t *testing.T) {
+		resp, body := request(http.MethodGet, "/files/File1/records/0", nil)
+		equals(t, http.StatusOK, resp.StatusCode)
+		equals(t, float64(0), body["recordNum"])
+		assert(t, body["data"] != nil, "should carry the record")
+
+		resp, body = request(http.MethodGet, "/files/File1/records?start=1&count=2", nil)
+		equals(t, http.StatusOK, resp.StatusCode)
+		equals(t, 2, len(body["range"].([]interface{})))
+	})
+
+	t.Run("should answer failures with their status code", func(t *testing.T) {
+		resp, body := request(http.MethodGet, "/files/File1/records/1", nil)
+		equals(t, http.StatusNotFound, resp.StatusCode)
+		equals(t, "RECORD_DOES_NOT_EXIST", body["error"])
+
+		resp, body = request(http.MethodGet, "/files?depth=-1", nil)
+		equals(t, http.StatusBadRequest, resp.StatusCode)
+		equals(t, "INVALID_READ_DEPTH", body["error"])
+
+		resp, _ = request(http.MethodPatch, "/files/File1", nil)
+		equals(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+		resp, _ = request(http.MethodGet, "/blocks", nil)
+		equals(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("should create, append and delete", func(t *testing.T) {
+		resp, body := request(http.MethodPut, "/files/a%2Ffile", nil)
+		equals(t, http.StatusCreated, resp.StatusCode)
+		equals(t, "a/file", body["name"])
+
+		resp, body = request(http.MethodPost, "/files/File1/records", map[string]interface{}{"data": []byte("record")})
+		equals(t, http.StatusCreated, resp.StatusCode)
+		equals(t, float64(0), body["recordNum"])
+
+		resp, body = request(http.MethodPost, "/files/File1/records", map[string]interface{}{"data": make([]byte, 513)})
+		equals(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp, _ = request(http.MethodDelete, "/files/File1", nil)
+		equals(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("should tell how short the miner is of coins unless the write waits for them", func(t *testing.T) {
+		resp, body := request(http.MethodPut, "/files/Expensive", nil)
+		equals(t, http.StatusPaymentRequired, resp.StatusCode)
+		equals(t, "NOT_ENOUGH_MONEY", body["error"])
+		equals(t, float64(100), body["balance"])
+		equals(t, float64(200), body["needed"])
+
+		resp, _ = request(http.MethodPut, "/files/Expensive?wait=1s", nil)
+		equals(t, http.StatusCreated, resp.StatusCode)
+		resp, body = request(http.MethodPut, "/files/Expensive?wait=soon", nil)
+		equals(t, http.StatusBadRequest, resp.StatusCode)
+		equals(t, "BAD_WAIT", body["error"])
+	})
+
+	t.Run("should stream the status of a write", func(t *testing.T) {
+		resp, err := http.Post(server.URL+"/files/File1/records?stream=true", "application/json", nil)
+		ok(t, err)
+		defer resp.Body.Close()
+		equals(t, http.StatusAccepted, resp.StatusCode)
+
+		lines := bufio.NewReader(resp.Body)
+		line, err := lines.ReadBytes('\n')
+		ok(t, err)
+		status := httpOpStatus{}
+		ok(t, json.Unmarshal(line, &status))
+		equals(t, httpOpStatus{Ticket: "ticket", State: "mined", Block: "block", Depth: 1}, status)
+		line, err = lines.ReadBytes('\n')
+		ok(t, err)
+		status = httpOpStatus{}
+		ok(t, json.Unmarshal(line, &status))
+		equals(t, httpOpStatus{Ticket: "ticket", State: "confirmed", Block: "block", Depth: 4}, status)
+		_, err = lines.ReadBytes('\n')
+		equals(t, io.EOF, err)
+	})
+}
@@ -13,18 +13,36 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"sort"
 	"sync"
 	"time"
 )
 
 // Miner type declaration
 type Miner interface {
-	CreateFileHandler(fname string) (errorType FailureType)
-	ListFilesHandler() (fnames []string, errorType FailureType)
-	TotalRecsHandler(fname string) (numRecs uint16, errorType FailureType)
-	ReadRecHandler(fname string, recordNum uint16) (record [512]byte, errorType FailureType)
-	AppendRecHandler(fname string, record [512]byte) (recordNum uint16, errorType FailureType)
-	DeleteRecHandler(fname string) (errorType FailureType)
+	CreateFileHandler(fname string, tip uint32, opId string) (errorType FailureType)
+	ReadDepth(useDepth bool, depth int) (readDepth ConfirmDepth, errorType FailureType)
+	ListFilesHandler(depth ConfirmDepth) (fnames []string, block string, errorType FailureType)
+	TotalRecsHandler(fname string, depth ConfirmDepth) (numRecs uint16, block string, errorType FailureType)
+	ReadRecHandler(fname string, recordNum uint16, waitMode WaitMode, timeout time.Duration, depth ConfirmDepth) (record [512]byte, block string, errorType FailureType)
+	ReadRecsHandler(fname string, start uint16, count uint16, depth ConfirmDepth) (records [][512]byte, numRecs uint16, block string, errorType FailureType)
+	AppendRecHandler(fname string, record [512]byte, tip uint32, opId string) (recordNum uint16, errorType FailureType)
+	AppendRecAtHandler(fname string, recordNum uint16, record [512]byte, tip uint32, opId string) (errorType FailureType)
+	AppendRecsHandler(fname string, records [][512]byte, tip uint32, opId string) (recordNums []uint16, errorType FailureType)
+	TransactionHandler(ops []TransactionOp, tip uint32, opId string) (recordNums []uint16, errorType FailureType)
+	DeleteRecHandler(fname string, tip uint32, opId string) (errorType FailureType)
+	SubmitHandler(requestType RequestType, fname string, record [512]byte, tip uint32, opId string) (ticket string, errorType FailureType)
+	OpStatusHandler(opId string, waitMode WaitMode, timeout time.Duration) (status OpStatus, errorType FailureType)
+	WatchOpHandler(opId string) (statuses <-chan OpStatus, errorType FailureType)
+	WatchHandler(fname string, prefix bool) (events <-chan WatchEvent, unwatch func(), errorType FailureType)
+	HeadersHandler(locator []string) (headers []crypto.Block, errorType FailureType)
+	TransferHandler(recipient string, amount uint32, tip uint32, opId string) (errorType FailureType)
+	BalanceHandler(account string) (balance int, errorType FailureType)
+	WithCancel(cancel <-chan bool) Miner
+	WithIdentity(creator string, signatures [][]byte) Miner
+	WithFundsWait(waitMode WaitMode, timeout time.Duration) Miner
+	Shortfall() (balance int, needed int)
+	AsOperator() Miner
 }
 
 type MinerConfiguration struct {
@@ -42,6 +60,19 @@ type MinerConfiguration struct {
 	IncomingMinersAddr string
 	OutgoingMinersIP string
 	IncomingClientsAddr string
+	// Address of the HTTP gateway for clients that don't use rfslib, none if empty
+	IncomingHttpClientsAddr string
+	// Loopback address the operator of the miner transfers its coins from, see AdminHandler, none if empty
+	IncomingAdminAddr string
+	MaxPendingOps uint16
+	PendingOpExpiryBlocks uint16
+	PendingOpExpirySecs uint32
+	// How long handlers wait for their ops to be confirmed before failing with OP_TIMED_OUT, LISTENER_EXPIRATION
+	// if 0
+	ConfirmTimeoutSecs uint32
+	// The miner pays for the requests of clients without an account of their own, which fail with
+	// BAD_SIGNATURE otherwise
+	PayForClients bool
 }
 
 var lg = log.New(os.Stdout, "miner: ", log.Ltime)
@@ -50,6 +81,28 @@ type MinerInstance struct {
 	minerConf MinerConfiguration
 	minerState state.MinerState
 	clientHandler ClientHandler
+	tickets *ticketTable
+	// closed once the client cancels the request being handled, nil if it can't be cancelled
+	cancel <-chan bool
+	// client account paying for the ops of the request being handled and its signatures of them, the
+	// miner pays for them if empty
+	creator string
+	signatures [][]byte
+	// the request comes from the operator of the miner rather than from a client, only then can it
+	// transfer the coins of the miner
+	operator bool
+	// how long the request being handled waits for its account to afford its ops, nil if it waits for as
+	// long as it takes
+	funds *fundsWait
+}
+
+// Deadline of a request for its account to afford its ops, and what the account had and needed the last
+// time it couldn't
+type fundsWait struct {
+	waitMode WaitMode
+	deadline time.Time
+	balance  int
+	needed   int
 }
 
 func NewMinerInstance(configFilename string, group *sync.WaitGroup, singleMinerDisconnected bool) Miner {
@@ -80,16 +133,32 @@ func NewMinerInstance(configFilename string, group *sync.WaitGroup, singleMinerD
 		OutgoingMinersIP: conf.OutgoingMinersIP,
 		ConfirmsPerFileCreate: int(conf.ConfirmsPerFileCreate),
 		ConfirmsPerFileAppend: int(conf.ConfirmsPerFileAppend),
-		OpPerBlock: 10,
+		OpPerBlock: OPS_PER_BLOCK,
 		MinerId: conf.MinerID,
 		GenesisBlockHash: blockHashBytes,
 		GenOpBlockTimeout: conf.GenOpBlockTimeout,
 		SingleMinerDisconnected: singleMinerDisconnected,
+		MaxPendingOps: DEFAULT_MAX_PENDING_OPS,
+		PendingOpExpiryBlocks: DEFAULT_PENDING_OP_EXPIRY_BLOCKS,
+		PendingOpExpiry: DEFAULT_PENDING_OP_EXPIRY,
+	}
+	if conf.MaxPendingOps > 0 {
+		minerStateConf.MaxPendingOps = int(conf.MaxPendingOps)
+	}
+	if conf.PendingOpExpiryBlocks > 0 {
+		minerStateConf.PendingOpExpiryBlocks = int(conf.PendingOpExpiryBlocks)
+	}
+	if conf.PendingOpExpirySecs > 0 {
+		minerStateConf.PendingOpExpiry = time.Duration(conf.PendingOpExpirySecs) * time.Second
 	}
 	ms := state.NewMinerState(minerStateConf, conf.PeerMinersAddrs)
 
 	// Initialize miner instance
-	var minerInstance Miner = MinerInstance{minerConf: conf, minerState: ms}
+	var minerInstance Miner = MinerInstance{
+		minerConf: conf,
+		minerState: ms,
+		tickets: newTicketTable(LISTENER_EXPIRATION),
+	}
 
 	// Initialize failure detector and start responding
 	s1 := rand.NewSource(time.Now().UnixNano())
@@ -109,11 +178,35 @@ func NewMinerInstance(configFilename string, group *sync.WaitGroup, singleMinerD
 	}
 	go ci.ListenForClients()
 
+	if conf.IncomingHttpClientsAddr != "" {
+		hi := HttpHandler{
+			ListenHost: conf.IncomingHttpClientsAddr,
+			miner:      &minerInstance,
+		}
+		go hi.ListenForHttpClients()
+	}
+
+	if conf.IncomingAdminAddr != "" {
+		ai := AdminHandler{
+			ListenHost: conf.IncomingAdminAddr,
+			miner:      &minerInstance,
+		}
+		go ai.ListenForOperator()
+	}
+
 	return minerInstance
 }
 
-// errorType can be one of: FILE_EXISTS, BAD_FILENAME, DISCONNECTED, NO_ERROR
-func (miner MinerInstance) CreateFileHandler(fname string) (errorType FailureType) {
+// errorType can be one of: FILE_EXISTS, BAD_FILENAME, DISCONNECTED, OP_EVICTED, OP_EXPIRED, REQUEST_CANCELLED,
+// NOT_ENOUGH_MONEY, BAD_SIGNATURE, OP_TIMED_OUT, OP_REJECTED, INTERNAL_ERROR, NO_ERROR
+// opId identifies the op in the chain, if empty the miner generates one.
+func (miner MinerInstance) CreateFileHandler(fname string, tip uint32, opId string) (errorType FailureType) {
+	if miner.unpaid() {
+		return BAD_SIGNATURE
+	}
+	if opId == "" {
+		opId = miner.newOpId()
+	}
 	for {
 		lg.Println("Handling create file request")
 		miner.minerState.LogLocalEvent(fmt.Sprintf(" Handling create file [%s] request from client", fname), INFO)
@@ -123,60 +216,73 @@ func (miner MinerInstance) CreateFileHandler(fname string) (errorType FailureTyp
 			return DISCONNECTED
 		}
 
+		fs, errorType := miner.getFileSystemState()
+		if errorType != NO_ERROR {
+			return errorType
+		}
+
+		// the op made it to the chain before it was dropped, wait for it instead of failing on our own file
+		if _, _, inChain := fs.GetOp(opId); inChain {
+			return miner.waitForOp(opId)
+		}
+
 		// create job
 		job := new(crypto.BlockOp)
 		job.Type = crypto.CreateFile
-		job.Creator = miner.minerConf.MinerID
+		job.Creator = miner.account()
 		job.Filename = fname
+		job.Tip = tip
+		job.OpId = opId
+		job.Signature = miner.signature(0)
 
-		// validate against file system, accounts states
-		_, acctsErr, filesErr := miner.minerState.ValidateJobSet([]*crypto.BlockOp{job})
-
-		if acctsErr != nil {
-			singleAcctsErr := getSingleAccountsError(acctsErr)
-			if singleAcctsErr == NOT_ENOUGH_MONEY {
-				time.Sleep(time.Second)
-				continue
-			}
-		}
-
-		if filesErr != nil {
-			singleFilesErr := getSingleFilesError(filesErr)
-			if singleFilesErr == FILE_EXISTS || singleFilesErr == BAD_FILENAME {
-				return singleFilesErr
-			}
+		_, retry, errorType := miner.submitAndWait([]*crypto.BlockOp{job}, nil,
+			func(_ []*crypto.BlockOp, deadline time.Time) <-chan int {
+				ccl := state.CreateConfirmationListener {
+					Creator: miner.account(),
+					Filename: fname,
+					NotifyChannel: make(chan int, 100),
+					ExpirationTime: deadline,
+				}
+				miner.minerState.AddConfirmationListener(ccl)
+				return ccl.NotifyChannel
+			})
+		if !retry {
+			return errorType
 		}
+	}
+}
 
-		// add job wait for it to complete
-		miner.minerState.AddJob(*job)
-		ccl := state.CreateConfirmationListener {
-			Creator: miner.minerConf.MinerID,
-			Filename: fname,
-			MinerState: miner.minerState,
-			ConfirmsPerFileAppend: int(miner.minerConf.ConfirmsPerFileAppend),
-			ConfirmsPerFileCreate: int(miner.minerConf.ConfirmsPerFileCreate),
-			NotifyChannel: make(chan int, 100),
-			ExpirationTime: time.Now().Add(LISTENER_EXPIRATION),
-		}
-		miner.minerState.AddTreeListener(ccl)
-		select {
-		case <- ccl.NotifyChannel:
-			return NO_ERROR
-		}
+// errorType can be one of: INVALID_READ_DEPTH, NO_ERROR
+// Returns the confirmation depth a read asking for depth is answered at, the configured one if
+// useDepth is false.
+func (miner MinerInstance) ReadDepth(useDepth bool, depth int) (readDepth ConfirmDepth, errorType FailureType) {
+	if !useDepth {
+		return ConfirmDepth{
+			Create: int(miner.minerConf.ConfirmsPerFileCreate),
+			Append: int(miner.minerConf.ConfirmsPerFileAppend)}, NO_ERROR
 	}
+	if depth < MIN_READ_DEPTH || depth > MAX_READ_DEPTH {
+		return ConfirmDepth{}, INVALID_READ_DEPTH
+	}
+	return ConfirmDepth{Create: depth, Append: depth}, NO_ERROR
 }
 
-// errorType can be one of: DISCONNECTED, NO_ERROR
-func (miner MinerInstance) ListFilesHandler() (fnames []string, errorType FailureType) {
+// errorType can be one of: DISCONNECTED, INTERNAL_ERROR, NO_ERROR
+// The names are sorted, block is the newest block the read sees, see FilesystemState.GetConfirmedBlock.
+// The other read handlers return it as well.
+func (miner MinerInstance) ListFilesHandler(depth ConfirmDepth) (fnames []string, block string, errorType FailureType) {
 	lg.Println("Handling list files request")
 	miner.minerState.LogLocalEvent(fmt.Sprintf(" Handling list files request from client"), INFO)
 
 	// check if miner is disconnected
 	if miner.minerState.IsDisconnected() {
-		return []string{}, DISCONNECTED
+		return []string{}, "", DISCONNECTED
 	}
 
-	fs := miner.getFileSystemState()
+	fs, errorType := miner.getFileSystemStateAt(depth)
+	if errorType != NO_ERROR {
+		return []string{}, "", errorType
+	}
 
 	files := fs.GetAll()
 	fnames = make([]string, len(files))
@@ -185,62 +291,153 @@ func (miner MinerInstance) ListFilesHandler() (fnames []string, errorType Failur
 		fnames[i] = string(key)
 		i++
 	}
-	return fnames, NO_ERROR
+	sort.Strings(fnames)
+	return fnames, fs.GetConfirmedBlock(), NO_ERROR
 }
 
-// errorType can be one of: FILE_DOES_NOT_EXIST, DISCONNECTED, NO_ERROR
-func (miner MinerInstance) TotalRecsHandler(fname string) (numRecs uint16, errorType FailureType) {
+// errorType can be one of: FILE_DOES_NOT_EXIST, DISCONNECTED, INTERNAL_ERROR, NO_ERROR
+func (miner MinerInstance) TotalRecsHandler(fname string, depth ConfirmDepth) (numRecs uint16, block string, errorType FailureType) {
 	lg.Println("Handling total records request")
 	miner.minerState.LogLocalEvent(fmt.Sprintf(" Handling total records in [%s] request from client", fname), INFO)
 
 	// check if miner is disconnected
 	if miner.minerState.IsDisconnected() {
-		return 0, DISCONNECTED
+		return 0, "", DISCONNECTED
 	}
 
-	fs := miner.getFileSystemState()
+	fs, errorType := miner.getFileSystemStateAt(depth)
+	if errorType != NO_ERROR {
+		return 0, "", errorType
+	}
 
 	file, ok := fs.GetFile(Filename(fname))
 	if !ok {
-		return 0, FILE_DOES_NOT_EXIST
+		return 0, fs.GetConfirmedBlock(), FILE_DOES_NOT_EXIST
 	}
-	return file.NumberOfRecords, NO_ERROR
+	return file.NumberOfRecords, fs.GetConfirmedBlock(), NO_ERROR
 }
 
-// errorType can be one of: FILE_DOES_NOT_EXIST, DISCONNECTED, NO_ERROR
-func (miner MinerInstance) ReadRecHandler(fname string, recordNum uint16) (record [512]byte, errorType FailureType) {
+// errorType can be one of: FILE_DOES_NOT_EXIST, RECORD_DOES_NOT_EXIST, DISCONNECTED, REQUEST_CANCELLED,
+// INTERNAL_ERROR, NO_ERROR
+// waitMode tells whether to fail right away if the record doesn't exist yet, to wait for it until the
+// timeout runs out or to wait for as long as it takes.
+func (miner MinerInstance) ReadRecHandler(fname string, recordNum uint16, waitMode WaitMode, timeout time.Duration, depth ConfirmDepth) (record [512]byte, block string, errorType FailureType) {
 	lg.Println("Handling read record request")
 	miner.minerState.LogLocalEvent(
 		fmt.Sprintf(" Handling read record in [%s] at index [%v] request from client", fname, recordNum), INFO)
 
+	deadline := time.Now().Add(timeout)
+	// the read has a single listener at a time, it's removed once the read stops waiting for it
+	var listener state.ConfirmationListener
+	defer func() {
+		if listener != nil {
+			miner.minerState.RemoveConfirmationListener(listener)
+		}
+	}()
 	for {
 		var read_result [512]byte
 
 		// check if miner is disconnected
 		if miner.minerState.IsDisconnected() {
-			return read_result, DISCONNECTED
+			return read_result, "", DISCONNECTED
 		}
 
-		fs := miner.getFileSystemState()
+		fs, errorType := miner.getFileSystemStateAt(depth)
+		if errorType != NO_ERROR {
+			return read_result, "", errorType
+		}
 
 		file, ok := fs.GetFile(Filename(fname))
 		if !ok {
-			return read_result, FILE_DOES_NOT_EXIST
+			return read_result, fs.GetConfirmedBlock(), FILE_DOES_NOT_EXIST
 		}
 
 		if recordNum >= file.NumberOfRecords {
-			// the record does not exist yet, wait until it does
-			time.Sleep(time.Second)
+			// the record does not exist yet, wait until it does if the client asked to. Listeners expire,
+			// longer waits register another one
+			until := time.Now().Add(LISTENER_EXPIRATION)
+			switch waitMode {
+			case NO_WAIT:
+				return read_result, fs.GetConfirmedBlock(), RECORD_DOES_NOT_EXIST
+			case WAIT_TIMEOUT:
+				if !time.Now().Before(deadline) {
+					return read_result, fs.GetConfirmedBlock(), RECORD_DOES_NOT_EXIST
+				}
+				if deadline.Before(until) {
+					until = deadline
+				}
+			}
+			var notify <-chan int
+			listener, notify = miner.recordListener(fname, recordNum, depth, fs, until)
+			select {
+			case <- notify:
+				// the listener was removed when it notified
+				listener = nil
+			case <- time.After(time.Until(until)):
+				miner.minerState.RemoveConfirmationListener(listener)
+				listener = nil
+			case <- miner.cancel:
+				return read_result, "", REQUEST_CANCELLED
+			}
 		} else {
-			offset := recordNum * 512
+			offset := uint32(recordNum) * 512
 			copy(read_result[:], file.Data[offset:offset+512])
-			return read_result, NO_ERROR
+			return read_result, fs.GetConfirmedBlock(), NO_ERROR
 		}
 	}
 }
 
-// errorType can be one of: FILE_DOES_NOT_EXIST, MAX_LEN_REACHED, DISCONNECTED, NO_ERROR
-func (miner MinerInstance) AppendRecHandler(fname string, record [512]byte) (recordNum uint16, errorType FailureType) {
+// errorType can be one of: FILE_DOES_NOT_EXIST, DISCONNECTED, INTERNAL_ERROR, NO_ERROR
+// Returns the confirmed records from start on, at most count and MAX_RECS_PER_READ_REQUEST of them.
+// Unlike ReadRecHandler it doesn't wait for records past the end of the file, it returns the ones that
+// exist, none if start is past the end, along with the number of records of the file.
+func (miner MinerInstance) ReadRecsHandler(fname string, start uint16, count uint16, depth ConfirmDepth) (records [][512]byte, numRecs uint16, block string, errorType FailureType) {
+	lg.Println("Handling read records request")
+	miner.minerState.LogLocalEvent(
+		fmt.Sprintf(" Handling read of [%v] records in [%s] from index [%v] request from client", count, fname, start), INFO)
+
+	// check if miner is disconnected
+	if miner.minerState.IsDisconnected() {
+		return nil, 0, "", DISCONNECTED
+	}
+
+	fs, errorType := miner.getFileSystemStateAt(depth)
+	if errorType != NO_ERROR {
+		return nil, 0, "", errorType
+	}
+
+	file, ok := fs.GetFile(Filename(fname))
+	if !ok {
+		return nil, 0, fs.GetConfirmedBlock(), FILE_DOES_NOT_EXIST
+	}
+
+	if count > MAX_RECS_PER_READ_REQUEST {
+		count = MAX_RECS_PER_READ_REQUEST
+	}
+	end := uint32(start) + uint32(count)
+	if end > uint32(file.NumberOfRecords) {
+		end = uint32(file.NumberOfRecords)
+	}
+	records = make([][512]byte, 0)
+	for i := uint32(start); i < end; i++ {
+		var record [512]byte
+		copy(record[:], file.Data[i*512:(i+1)*512])
+		records = append(records, record)
+	}
+	return records, file.NumberOfRecords, fs.GetConfirmedBlock(), NO_ERROR
+}
+
+// errorType can be one of: FILE_DOES_NOT_EXIST, MAX_LEN_REACHED, DISCONNECTED, OP_EVICTED, OP_EXPIRED,
+// REQUEST_CANCELLED, NOT_ENOUGH_MONEY, BAD_SIGNATURE, OP_TIMED_OUT, OP_REJECTED, INTERNAL_ERROR, NO_ERROR
+// opId is an idempotency key, an append retried with the same key is only applied once. If empty
+// the miner generates one.
+func (miner MinerInstance) AppendRecHandler(fname string, record [512]byte, tip uint32, opId string) (recordNum uint16, errorType FailureType) {
+	if miner.unpaid() {
+		return 0, BAD_SIGNATURE
+	}
+	if opId == "" {
+		opId = miner.newOpId()
+	}
 	for {
 		lg.Println("Handling append record request")
 		miner.minerState.LogLocalEvent(fmt.Sprintf(" Handling append record to [%s] request from client", fname), INFO)
@@ -250,7 +447,10 @@ func (miner MinerInstance) AppendRecHandler(fname string, record [512]byte) (rec
 			return 0, DISCONNECTED
 		}
 
-		fs := miner.getFileSystemState()
+		fs, errorType := miner.getFileSystemState()
+		if errorType != NO_ERROR {
+			return 0, errorType
+		}
 
 		// check if file already exists
 		file, ok := fs.GetFile(Filename(fname))
@@ -258,61 +458,542 @@ func (miner MinerInstance) AppendRecHandler(fname string, record [512]byte) (rec
 			return 0, FILE_DOES_NOT_EXIST
 		}
 
+		// the append was already sent before, wait for it instead of appending the record twice
+		if op, _, inChain := fs.GetOp(opId); inChain {
+			return miner.waitForAppend(op)
+		}
+
 		// create job
 		job := new(crypto.BlockOp)
 		job.Type = crypto.AppendFile
-		job.Creator = miner.minerConf.MinerID
+		job.Creator = miner.account()
 		job.Filename = fname
 		job.RecordNumber = file.NumberOfRecords
+		job.Tip = tip
+		job.OpId = opId
 		copy(job.Data[:], record[:])
+		job.Signature = miner.signature(0)
+
+		// most likely somebody else got the record number if it's rejected, it's retried with the next one
+		recordNum, retry, errorType := miner.submitAndWait([]*crypto.BlockOp{job}, retryDuplicates,
+			func(_ []*crypto.BlockOp, deadline time.Time) <-chan int {
+				acl := state.AppendConfirmationListener {
+					OpId: opId,
+					Creator: miner.account(),
+					Filename: fname,
+					RecordNumber: job.RecordNumber,
+					Data: record,
+					NotifyChannel: make(chan int, 100),
+					ExpirationTime: deadline,
+				}
+				miner.minerState.AddConfirmationListener(acl)
+				return acl.NotifyChannel
+			})
+		if !retry {
+			// the op might have been mined with a different record number by an earlier retry
+			return uint16(recordNum), errorType
+		}
+	}
+}
 
-		// validate against file system, accounts states
-		_, acctsErr, filesErr := miner.minerState.ValidateJobSet([]*crypto.BlockOp{job})
+// errorType can be one of: FILE_DOES_NOT_EXIST, MAX_LEN_REACHED, DISCONNECTED, OP_EVICTED, OP_EXPIRED,
+// REQUEST_CANCELLED, NOT_ENOUGH_MONEY, BAD_SIGNATURE, OP_TIMED_OUT, OP_REJECTED, INTERNAL_ERROR, NO_ERROR
+// Submits all of the records at once, the block calculator packs as many of them per block as fit, and
+// waits for the last one to be confirmed. Every record gets an op id derived from opId, so that a retried
+// request only appends the records that are not in the chain yet.
+func (miner MinerInstance) AppendRecsHandler(fname string, records [][512]byte, tip uint32, opId string) (recordNums []uint16, errorType FailureType) {
+	if miner.unpaid() {
+		return nil, BAD_SIGNATURE
+	}
+	if opId == "" {
+		opId = miner.newOpId()
+	}
+	opIds := make([]string, len(records))
+	for i := range opIds {
+		opIds[i] = fmt.Sprintf("%s-%d", opId, i)
+	}
 
-		if acctsErr != nil {
-			singleAcctsErr := getSingleAccountsError(acctsErr)
-			if singleAcctsErr == NOT_ENOUGH_MONEY {
-				time.Sleep(time.Second)
-				continue
+	for {
+		lg.Println("Handling append records request")
+		miner.minerState.LogLocalEvent(fmt.Sprintf(" Handling append of %d records to [%s] request from client", len(records), fname), INFO)
+
+		// check if miner is disconnected
+		if miner.minerState.IsDisconnected() {
+			return nil, DISCONNECTED
+		}
+
+		fs, errorType := miner.getFileSystemState()
+		if errorType != NO_ERROR {
+			return nil, errorType
+		}
+
+		// check if file already exists
+		file, ok := fs.GetFile(Filename(fname))
+		if !ok {
+			return nil, FILE_DOES_NOT_EXIST
+		}
+
+		// find the records that still have to be appended, records in the chain that are not
+		// confirmed yet have to be waited for as they are not counted in the file
+		pending := make([]int, 0, len(records))
+		var unconfirmed *crypto.BlockOp
+		for i, id := range opIds {
+			op, confirmed, inChain := fs.GetOp(id)
+			if !inChain {
+				pending = append(pending, i)
+			} else if !confirmed {
+				unconfirmed = op
+			}
+		}
+		if unconfirmed != nil {
+			if _, errorType := miner.waitForAppend(unconfirmed); errorType != NO_ERROR {
+				return nil, errorType
 			}
+			continue
+		}
+		if len(pending) == 0 {
+			recordNums = make([]uint16, len(records))
+			for i, id := range opIds {
+				op, _, _ := fs.GetOp(id)
+				recordNums[i] = op.RecordNumber
+			}
+			return recordNums, NO_ERROR
+		}
+		if int(file.NumberOfRecords) + len(pending) > int(MAX_RECORD_COUNT) {
+			return nil, MAX_LEN_REACHED
 		}
 
-		if filesErr != nil {
-			singleFilesErr := getSingleFilesError(filesErr)
-			if singleFilesErr == FILE_DOES_NOT_EXIST || singleFilesErr == MAX_LEN_REACHED {
-				return 0, singleFilesErr
-			} else if singleFilesErr == APPEND_DUPLICATE {
-				continue
+		// one job for each record still to append, with consecutive record numbers
+		jobs := make([]*crypto.BlockOp, len(pending))
+		for i, idx := range pending {
+			job := new(crypto.BlockOp)
+			job.Type = crypto.AppendFile
+			job.Creator = miner.account()
+			job.Filename = fname
+			job.RecordNumber = file.NumberOfRecords + uint16(i)
+			job.Tip = tip
+			job.OpId = opIds[idx]
+			copy(job.Data[:], records[idx][:])
+			job.Signature = miner.signature(idx)
+			jobs[i] = job
+		}
+
+		// wait for the last job that could be submitted to be confirmed, the record numbers are grabbed
+		// from the chain in the next iteration
+		_, _, errorType = miner.submitAndWait(jobs, retryDuplicates,
+			func(submitted []*crypto.BlockOp, deadline time.Time) <-chan int {
+				last := submitted[len(submitted) - 1]
+				acl := state.AppendConfirmationListener {
+					OpId: last.OpId,
+					Creator: miner.account(),
+					Filename: fname,
+					RecordNumber: last.RecordNumber,
+					Data: last.Data,
+					NotifyChannel: make(chan int, 100),
+					ExpirationTime: deadline,
+				}
+				miner.minerState.AddConfirmationListener(acl)
+				return acl.NotifyChannel
+			})
+		if errorType != NO_ERROR {
+			return nil, errorType
+		}
+	}
+}
+
+// errorType can be one of: FILE_EXISTS, BAD_FILENAME, FILE_DOES_NOT_EXIST, MAX_LEN_REACHED, TRANSACTION_INVALID,
+// DISCONNECTED, OP_EVICTED, OP_EXPIRED, REQUEST_CANCELLED, NOT_ENOUGH_MONEY, BAD_SIGNATURE,
+// OP_TIMED_OUT, OP_REJECTED, INTERNAL_ERROR, NO_ERROR
+// Mines all of the ops as a single transaction, recordNums holds the record numbers of its appends in order.
+// opId works like in AppendRecHandler.
+func (miner MinerInstance) TransactionHandler(ops []TransactionOp, tip uint32, opId string) (recordNums []uint16, errorType FailureType) {
+	if miner.unpaid() {
+		return nil, BAD_SIGNATURE
+	}
+	if opId == "" {
+		opId = miner.newOpId()
+	}
+	for {
+		lg.Println("Handling transaction request")
+		miner.minerState.LogLocalEvent(fmt.Sprintf(" Handling transaction of %d ops request from client", len(ops)), INFO)
+
+		// check if miner is disconnected
+		if miner.minerState.IsDisconnected() {
+			return nil, DISCONNECTED
+		}
+
+		fs, errorType := miner.getFileSystemState()
+		if errorType != NO_ERROR {
+			return nil, errorType
+		}
+
+		// the transaction was already sent before, wait for it instead of applying it twice
+		if op, confirmed, inChain := fs.GetOp(opId); inChain {
+			if confirmed {
+				return transactionRecordNums(op), NO_ERROR
 			}
+			if errorType := miner.waitForOp(opId); errorType != NO_ERROR {
+				return nil, errorType
+			}
+			continue
 		}
 
-		// add job wait for it to complete
-		miner.minerState.AddJob(*job)
-		acl := state.AppendConfirmationListener {
-			Creator: miner.minerConf.MinerID,
-			Filename: fname,
-			RecordNumber: job.RecordNumber,
-			Data: record,
-			MinerState: miner.minerState,
-			ConfirmsPerFileAppend: int(miner.minerConf.ConfirmsPerFileAppend),
-			ConfirmsPerFileCreate: int(miner.minerConf.ConfirmsPerFileCreate),
-			NotifyChannel: make(chan int, 100),
-			ExpirationTime: time.Now().Add(LISTENER_EXPIRATION),
-		}
-		miner.minerState.AddTreeListener(acl)
-		for {
-			select {
-			case <- acl.NotifyChannel:
-				return job.RecordNumber, NO_ERROR
+		// create job, appends get the record numbers that follow the ones before them in the transaction
+		job := new(crypto.BlockOp)
+		job.Type = crypto.Transaction
+		job.Creator = miner.account()
+		job.Tip = tip
+		job.OpId = opId
+		job.Ops = make([]*crypto.BlockOp, len(ops))
+		numRecords := make(map[string]uint16)
+		for i, txOp := range ops {
+			subJob := new(crypto.BlockOp)
+			subJob.Creator = miner.account()
+			subJob.Filename = txOp.FileName
+			switch txOp.RequestType {
+			case CREATE_FILE:
+				subJob.Type = crypto.CreateFile
+				numRecords[txOp.FileName] = 0
+			case APPEND_REC:
+				subJob.Type = crypto.AppendFile
+				n, ok := numRecords[txOp.FileName]
+				if !ok {
+					if file, exists := fs.GetFile(Filename(txOp.FileName)); exists {
+						n = file.NumberOfRecords
+					}
+				}
+				subJob.RecordNumber = n
+				numRecords[txOp.FileName] = n + 1
+				copy(subJob.Data[:], txOp.AppendRecord[:])
+			case DELETE_FILE:
+				subJob.Type = crypto.DeleteFile
+				delete(numRecords, txOp.FileName)
 			default:
-				// do nothing
+				return nil, TRANSACTION_INVALID
 			}
+			job.Ops[i] = subJob
+		}
+		job.Signature = miner.signature(0)
+
+		// grab the record numbers from the chain in the next iteration
+		_, _, errorType = miner.submitAndWait([]*crypto.BlockOp{job}, retryDuplicates,
+			func(_ []*crypto.BlockOp, deadline time.Time) <-chan int {
+				return miner.opConfirmation(opId, deadline)
+			})
+		if errorType != NO_ERROR {
+			return nil, errorType
 		}
 	}
 }
 
-// errorType can be one of: FILE_DOES_NOT_EXIST, DISCONNECTED, NO_ERROR
-func (miner MinerInstance) DeleteRecHandler(fname string) (errorType FailureType) {
+func transactionRecordNums(tx *crypto.BlockOp) []uint16 {
+	recordNums := make([]uint16, 0, len(tx.Ops))
+	for _, op := range tx.Ops {
+		if op.Type == crypto.AppendFile {
+			recordNums = append(recordNums, op.RecordNumber)
+		}
+	}
+	return recordNums
+}
+
+// Registers a listener that notifies once recordNum of fname may be readable at depth, until deadline:
+// once the record is confirmed if depth is the one of the miner, on the next head after fs otherwise
+func (miner MinerInstance) recordListener(fname string, recordNum uint16, depth ConfirmDepth,
+	fs state.FilesystemState, deadline time.Time) (listener state.ConfirmationListener, notify <-chan int) {
+	notifyChannel := make(chan int, 1)
+	if depth.Create == int(miner.minerConf.ConfirmsPerFileCreate) && depth.Append == int(miner.minerConf.ConfirmsPerFileAppend) {
+		listener = state.RecordConfirmationListener{
+			Filename: fname,
+			RecordNumber: recordNum,
+			NotifyChannel: notifyChannel,
+			ExpirationTime: deadline,
+		}
+	} else {
+		listener = state.HeadListener{
+			Head: fs.GetHead(),
+			NotifyChannel: notifyChannel,
+			ExpirationTime: deadline,
+		}
+	}
+	miner.minerState.AddConfirmationListener(listener)
+	return listener, notifyChannel
+}
+
+// Registers a listener that notifies once the op with the given op id is confirmed, until deadline
+func (miner MinerInstance) opConfirmation(opId string, deadline time.Time) <-chan int {
+	ocl := state.OpConfirmationListener {
+		OpId: opId,
+		NotifyChannel: make(chan int, 100),
+		ExpirationTime: deadline,
+	}
+	miner.minerState.AddConfirmationListener(ocl)
+	return ocl.NotifyChannel
+}
+
+// Validates jobs and adds the valid ones to the mempool, the valid jobs always being the first ones, then
+// waits until the deadline of the request for the channel listen registers for them to notify. notified
+// is what the channel got.
+//
+// Jobs that fail validation are never added: they would only get rejected while building a block, and
+// the op would be lost. Only if none of them is valid does the handler find out why, onFilesErr can turn
+// a failure of the file system into another failure or a retry. retry tells the handler to build its
+// jobs again, because its account can afford them now, they were outdated or a block rejected them.
+func (miner MinerInstance) submitAndWait(jobs []*crypto.BlockOp, onFilesErr func(filesErr FailureType) (errorType FailureType, retry bool),
+	listen func(submitted []*crypto.BlockOp, deadline time.Time) <-chan int) (notified int, retry bool, errorType FailureType) {
+	validJobs, acctsErr, filesErr := miner.minerState.ValidateJobSet(jobs)
+	if len(validJobs) == 0 {
+		switch getSingleAccountsError(acctsErr) {
+		case NOT_ENOUGH_MONEY:
+			if fundsErr := miner.waitForFunds(acctsErr); fundsErr != NO_ERROR {
+				return 0, false, fundsErr
+			}
+			return 0, true, NO_ERROR
+		case BAD_SIGNATURE:
+			return 0, false, BAD_SIGNATURE
+		}
+		if onFilesErr != nil && filesErr != nil {
+			if errorType, retry := onFilesErr(getSingleFilesError(filesErr)); errorType != NO_ERROR || retry {
+				return 0, retry, errorType
+			}
+		}
+		rejected := getRejection(acctsErr, filesErr)
+		return 0, rejected == NO_ERROR, rejected
+	}
+
+	// wait for the jobs to be confirmed or for any of them to be dropped from the mempool
+	dropped := miner.minerState.WatchJobs(validJobs)
+	defer miner.minerState.UnwatchJobs(validJobs, dropped)
+	for _, job := range validJobs {
+		miner.minerState.AddJob(*job)
+	}
+	deadline := miner.confirmDeadline()
+	select {
+	case notified := <- listen(validJobs, deadline):
+		return notified, false, NO_ERROR
+	case <- miner.cancel:
+		return 0, false, REQUEST_CANCELLED
+	case <- time.After(time.Until(deadline)):
+		return 0, false, OP_TIMED_OUT
+	case reason := <- dropped:
+		if reason == OP_REJECTED {
+			// validate the jobs again, either they get retried or the handler finds out why they can't be done
+			return 0, true, NO_ERROR
+		}
+		return 0, false, reason
+	}
+}
+
+// Retries jobs that failed validation because another op got their record number or op id first
+func retryDuplicates(filesErr FailureType) (errorType FailureType, retry bool) {
+	return NO_ERROR, filesErr == APPEND_DUPLICATE || filesErr == OP_DUPLICATE
+}
+
+// Waits for an op that is already in the chain to be confirmed
+func (miner MinerInstance) waitForOp(opId string) (errorType FailureType) {
+	deadline := miner.confirmDeadline()
+	select {
+	case <- miner.opConfirmation(opId, deadline):
+		return NO_ERROR
+	case <- miner.cancel:
+		return REQUEST_CANCELLED
+	case <- time.After(time.Until(deadline)):
+		return OP_TIMED_OUT
+	}
+}
+
+// Records what the account of the request lacks to afford its ops and waits a bit for it to earn or get
+// coins, errorType is NOT_ENOUGH_MONEY once the request doesn't wait any longer
+func (miner MinerInstance) waitForFunds(acctsErr error) (errorType FailureType) {
+	if miner.funds == nil {
+		if miner.pause(time.Second) {
+			return REQUEST_CANCELLED
+		}
+		return NO_ERROR
+	}
+
+	if cerr, ok := acctsErr.(state.CompositeError); ok {
+		if moneyErr, ok := cerr.Current.(state.NotEnoughMoneyValidationError); ok {
+			miner.funds.balance = moneyErr.ActualMoney
+			miner.funds.needed = moneyErr.NeededMoney
+		}
+	}
+	wait := time.Second
+	switch miner.funds.waitMode {
+	case NO_WAIT:
+		return NOT_ENOUGH_MONEY
+	case WAIT_TIMEOUT:
+		left := miner.funds.deadline.Sub(time.Now())
+		if left <= 0 {
+			return NOT_ENOUGH_MONEY
+		}
+		if left < wait {
+			wait = left
+		}
+	}
+	if miner.pause(wait) {
+		return REQUEST_CANCELLED
+	}
+	return NO_ERROR
+}
+
+// Time at which handlers stop waiting for the ops they submit now to be confirmed
+func (miner MinerInstance) confirmDeadline() time.Time {
+	if miner.minerConf.ConfirmTimeoutSecs > 0 {
+		return time.Now().Add(time.Duration(miner.minerConf.ConfirmTimeoutSecs) * time.Second)
+	}
+	return time.Now().Add(LISTENER_EXPIRATION)
+}
+
+// Sleeps for d, cancelled is true if the request got cancelled in the meantime
+func (miner MinerInstance) pause(d time.Duration) (cancelled bool) {
+	select {
+	case <- time.After(d):
+		return false
+	case <- miner.cancel:
+		return true
+	}
+}
+
+// errorType can be one of: FILE_DOES_NOT_EXIST, MAX_LEN_REACHED, RECORD_CONFLICT, DISCONNECTED, OP_EVICTED,
+// OP_EXPIRED, REQUEST_CANCELLED, NOT_ENOUGH_MONEY, BAD_SIGNATURE, OP_TIMED_OUT, OP_REJECTED,
+// INTERNAL_ERROR, NO_ERROR
+// Same as AppendRecHandler, but the record is only appended if it gets recordNum. If the file has a different
+// number of records, or another append takes recordNum first, it fails with RECORD_CONFLICT instead of retrying.
+func (miner MinerInstance) AppendRecAtHandler(fname string, recordNum uint16, record [512]byte, tip uint32, opId string) (errorType FailureType) {
+	if miner.unpaid() {
+		return BAD_SIGNATURE
+	}
+	if opId == "" {
+		opId = miner.newOpId()
+	}
+	for {
+		lg.Println("Handling conditional append record request")
+		miner.minerState.LogLocalEvent(fmt.Sprintf(" Handling append record %d to [%s] request from client", recordNum, fname), INFO)
+
+		// check if miner is disconnected
+		if miner.minerState.IsDisconnected() {
+			return DISCONNECTED
+		}
+
+		fs, errorType := miner.getFileSystemState()
+		if errorType != NO_ERROR {
+			return errorType
+		}
+
+		// check if file already exists
+		file, ok := fs.GetFile(Filename(fname))
+		if !ok {
+			return FILE_DOES_NOT_EXIST
+		}
+
+		// the append was already sent before, wait for it instead of failing on our own record
+		if op, _, inChain := fs.GetOp(opId); inChain {
+			_, errorType := miner.waitForAppend(op)
+			return errorType
+		}
+
+		if file.NumberOfRecords != recordNum {
+			return RECORD_CONFLICT
+		}
+
+		// create job
+		job := new(crypto.BlockOp)
+		job.Type = crypto.AppendFile
+		job.Creator = miner.account()
+		job.Filename = fname
+		job.RecordNumber = recordNum
+		job.Tip = tip
+		job.OpId = opId
+		copy(job.Data[:], record[:])
+		job.Signature = miner.signature(0)
+
+		// a rejected job is looked at again to find out whether somebody else got the record number
+		_, retry, errorType := miner.submitAndWait([]*crypto.BlockOp{job},
+			func(filesErr FailureType) (FailureType, bool) {
+				if filesErr == APPEND_DUPLICATE {
+					return RECORD_CONFLICT, false
+				}
+				return NO_ERROR, filesErr == OP_DUPLICATE
+			},
+			func(_ []*crypto.BlockOp, deadline time.Time) <-chan int {
+				acl := state.AppendConfirmationListener {
+					OpId: opId,
+					Creator: miner.account(),
+					Filename: fname,
+					RecordNumber: recordNum,
+					Data: record,
+					NotifyChannel: make(chan int, 100),
+					ExpirationTime: deadline,
+				}
+				miner.minerState.AddConfirmationListener(acl)
+				return acl.NotifyChannel
+			})
+		if !retry {
+			return errorType
+		}
+	}
+}
+
+// errorType can be one of: DISCONNECTED, INTERNAL_ERROR, NO_ERROR
+// Streams the confirmed changes of fname, or of every file whose name starts with fname if prefix is set,
+// until unwatch is called. The events are driven by the changes of the longest chain, events is closed
+// if the client falls behind on them.
+func (miner MinerInstance) WatchHandler(fname string, prefix bool) (events <-chan WatchEvent, unwatch func(), errorType FailureType) {
+	lg.Println("Handling watch request")
+	miner.minerState.LogLocalEvent(fmt.Sprintf(" Handling watch [%s] request from client", fname), INFO)
+
+	// check if miner is disconnected
+	if miner.minerState.IsDisconnected() {
+		return nil, nil, DISCONNECTED
+	}
+
+	fs, errorType := miner.getFileSystemState()
+	if errorType != NO_ERROR {
+		return nil, nil, errorType
+	}
+	fwl := state.NewFileWatchListener(fname, prefix, fs)
+	miner.minerState.AddTreeListener(fwl)
+	return fwl.NotifyChannel, fwl.Close, NO_ERROR
+}
+
+// Waits for an append that is already in the chain to be confirmed
+func (miner MinerInstance) waitForAppend(op *crypto.BlockOp) (recordNum uint16, errorType FailureType) {
+	deadline := miner.confirmDeadline()
+	acl := state.AppendConfirmationListener {
+		OpId: op.OpId,
+		Creator: op.Creator,
+		Filename: op.Filename,
+		RecordNumber: op.RecordNumber,
+		Data: op.Data,
+		NotifyChannel: make(chan int, 100),
+		ExpirationTime: deadline,
+	}
+	miner.minerState.AddConfirmationListener(acl)
+	select {
+	case recordNum := <- acl.NotifyChannel:
+		return uint16(recordNum), NO_ERROR
+	case <- miner.cancel:
+		return 0, REQUEST_CANCELLED
+	case <- time.After(time.Until(deadline)):
+		return 0, OP_TIMED_OUT
+	}
+}
+
+// Op ids generated by the miner are prefixed by its id so that they don't collide with other miners
+func (miner MinerInstance) newOpId() string {
+	return fmt.Sprintf("%s-%016x%016x", miner.minerConf.MinerID, rand.Uint64(), rand.Uint64())
+}
+
+// errorType can be one of: FILE_DOES_NOT_EXIST, DISCONNECTED, OP_EVICTED, OP_EXPIRED, REQUEST_CANCELLED,
+// NOT_ENOUGH_MONEY, BAD_SIGNATURE, OP_TIMED_OUT, OP_REJECTED, INTERNAL_ERROR, NO_ERROR
+// opId identifies the op in the chain, if empty the miner generates one.
+func (miner MinerInstance) DeleteRecHandler(fname string, tip uint32, opId string) (errorType FailureType) {
+	if miner.unpaid() {
+		return BAD_SIGNATURE
+	}
+	if opId == "" {
+		opId = miner.newOpId()
+	}
 	for {
 		lg.Println("Handling delete file request")
 		miner.minerState.LogLocalEvent(fmt.Sprintf(" Handling delete file [%s] request from client", fname), INFO)
@@ -322,42 +1003,395 @@ func (miner MinerInstance) DeleteRecHandler(fname string) (errorType FailureType
 			return DISCONNECTED
 		}
 
+		fs, errorType := miner.getFileSystemState()
+		if errorType != NO_ERROR {
+			return errorType
+		}
+
+		// the op made it to the chain before it was dropped, wait for it instead of failing on the deleted file
+		if _, _, inChain := fs.GetOp(opId); inChain {
+			return miner.waitForOp(opId)
+		}
+
 		// create job
 		job := new(crypto.BlockOp)
 		job.Type = crypto.DeleteFile
-		job.Creator = miner.minerConf.MinerID
+		job.Creator = miner.account()
 		job.Filename = fname
+		job.Tip = tip
+		job.OpId = opId
+		job.Signature = miner.signature(0)
+
+		// only the tip has to be paid for a delete
+		_, retry, errorType := miner.submitAndWait([]*crypto.BlockOp{job}, nil,
+			func(_ []*crypto.BlockOp, deadline time.Time) <-chan int {
+				dcl := state.DeleteConfirmationListener {
+					Filename: fname,
+					NotifyChannel: make(chan int, 100),
+					ExpirationTime: deadline,
+				}
+				miner.minerState.AddConfirmationListener(dcl)
+				return dcl.NotifyChannel
+			})
+		if !retry {
+			return errorType
+		}
+	}
+}
 
-		// validate against file system, accounts states
-		_, _, filesErr := miner.minerState.ValidateJobSet([]*crypto.BlockOp{job})
+// errorType can be one of: DISCONNECTED, BAD_SIGNATURE, NO_ERROR
+// Runs a CREATE_FILE, APPEND_REC or DELETE_FILE request in the background and returns right away with
+// the op id as the ticket to ask OpStatusHandler about. Submitting an op id that has a ticket already
+// only returns the ticket.
+func (miner MinerInstance) SubmitHandler(requestType RequestType, fname string, record [512]byte, tip uint32, opId string) (ticket string, errorType FailureType) {
+	lg.Println("Handling async request")
+	miner.minerState.LogLocalEvent(fmt.Sprintf(" Handling async request on [%s] from client", fname), INFO)
 
-		if filesErr != nil {
-			singleFilesErr := getSingleFilesError(filesErr)
-			if singleFilesErr == FILE_DOES_NOT_EXIST {
-				return singleFilesErr
-			}
+	if miner.unpaid() {
+		return "", BAD_SIGNATURE
+	}
+
+	// check if miner is disconnected
+	if miner.minerState.IsDisconnected() {
+		return "", DISCONNECTED
+	}
+
+	if opId == "" {
+		opId = miner.newOpId()
+	}
+	t, added := miner.tickets.add(opId, fname)
+	if !added {
+		return opId, NO_ERROR
+	}
+	// the op outlives the request, cancelling the request doesn't cancel it
+	miner.cancel = nil
+	// the ticket tells what the account lacked if the op fails with NOT_ENOUGH_MONEY
+	if miner.funds != nil {
+		funds := *miner.funds
+		miner.funds = &funds
+		t.funds = miner.funds
+	}
+	go func() {
+		var recordNum uint16
+		var errorType FailureType
+		switch requestType {
+		case CREATE_FILE:
+			errorType = miner.CreateFileHandler(fname, tip, opId)
+		case APPEND_REC:
+			recordNum, errorType = miner.AppendRecHandler(fname, record, tip, opId)
+		case DELETE_FILE:
+			errorType = miner.DeleteRecHandler(fname, tip, opId)
 		}
+		miner.tickets.finish(t, recordNum, errorType)
+	}()
+	return opId, NO_ERROR
+}
 
-		// add job wait for it to complete
-		miner.minerState.AddJob(*job)
-		ccl := state.DeleteConfirmationListener {
-			Filename: fname,
-			MinerState: miner.minerState,
-			ConfirmsPerFileAppend: int(miner.minerConf.ConfirmsPerFileAppend),
-			ConfirmsPerFileCreate: int(miner.minerConf.ConfirmsPerFileCreate),
-			NotifyChannel: make(chan int, 100),
-			ExpirationTime: time.Now().Add(LISTENER_EXPIRATION),
+// errorType can be one of: UNKNOWN_OP, REQUEST_CANCELLED, INTERNAL_ERROR, NO_ERROR
+// Reports whether the op of a ticket is pending, mined or confirmed, or why it failed. waitMode tells
+// whether to answer right away, or to wait for the op to be confirmed or to fail, until the timeout
+// runs out or for as long as it takes.
+func (miner MinerInstance) OpStatusHandler(opId string, waitMode WaitMode, timeout time.Duration) (status OpStatus, errorType FailureType) {
+	lg.Println("Handling op status request")
+	miner.minerState.LogLocalEvent(fmt.Sprintf(" Handling status of op [%s] request from client", opId), INFO)
+
+	t, ok := miner.tickets.get(opId)
+	if !ok {
+		return OpStatus{}, UNKNOWN_OP
+	}
+	switch waitMode {
+	case WAIT_TIMEOUT:
+		select {
+		case <- t.done:
+		case <- time.After(timeout):
+		case <- miner.cancel:
+			return OpStatus{}, REQUEST_CANCELLED
 		}
-		miner.minerState.AddTreeListener(ccl)
+	case WAIT_FOREVER:
 		select {
-		case <- ccl.NotifyChannel:
-			return NO_ERROR
+		case <- t.done:
+		case <- miner.cancel:
+			return OpStatus{}, REQUEST_CANCELLED
+		}
+	}
+
+	fs, errorType := miner.getFileSystemStateAt(ConfirmDepth{})
+	if errorType != NO_ERROR {
+		return OpStatus{}, errorType
+	}
+	return miner.opStatus(opId, t, fs), NO_ERROR
+}
+
+// errorType can be one of: UNKNOWN_OP, NO_ERROR
+// Streams the status of the op of a ticket, once right away and then every time it changes, until the op
+// is confirmed or fails. The status is looked at again when the longest chain gets a new head and when
+// the op finishes. statuses is closed once the last status is sent, the request is cancelled or the
+// miner can't work out the files of its longest chain.
+func (miner MinerInstance) WatchOpHandler(opId string) (statuses <-chan OpStatus, errorType FailureType) {
+	lg.Println("Handling op watch request")
+	miner.minerState.LogLocalEvent(fmt.Sprintf(" Handling watch of op [%s] request from client", opId), INFO)
+
+	t, ok := miner.tickets.get(opId)
+	if !ok {
+		return nil, UNKNOWN_OP
+	}
+	changes := make(chan OpStatus)
+	go func() {
+		defer close(changes)
+		var last *OpStatus
+		for {
+			// the listener is added for the head the status is worked out at, so no new head is missed
+			fs, errorType := miner.getFileSystemStateAt(ConfirmDepth{})
+			if errorType != NO_ERROR {
+				return
+			}
+			hl := state.HeadListener{
+				Head: fs.GetHead(),
+				NotifyChannel: make(chan int, 1),
+				ExpirationTime: time.Now().Add(LISTENER_EXPIRATION),
+			}
+			miner.minerState.AddConfirmationListener(hl)
+
+			status := miner.opStatus(opId, t, fs)
+			if last == nil || status != *last {
+				select {
+				case changes <- status:
+				case <- miner.cancel:
+					return
+				}
+				last = &status
+			}
+			if status.State == OP_CONFIRMED || status.State == OP_FAILED {
+				return
+			}
+
+			select {
+			case <- hl.NotifyChannel:
+			case <- t.done:
+			case <- miner.cancel:
+				return
+			case <- time.After(time.Until(hl.ExpirationTime)):
+			}
+		}
+	}()
+	return changes, NO_ERROR
+}
+
+// Status of the op of ticket t in the longest chain of fs
+func (miner MinerInstance) opStatus(opId string, t *ticket, fs state.FilesystemState) OpStatus {
+	status := OpStatus{State: OP_PENDING, FileName: t.fname}
+	recordNum, errorType, finished := miner.tickets.result(t)
+	if finished && errorType != NO_ERROR {
+		status.State = OP_FAILED
+		status.Reason = errorType
+		if errorType == NOT_ENOUGH_MONEY && t.funds != nil {
+			status.Balance = t.funds.balance
+			status.Needed = t.funds.needed
+		}
+		return status
+	}
+
+	// look for the op in the longest chain, however deep it is
+	if block, depth, inChain := fs.GetOpBlock(opId); inChain {
+		status.State = OP_MINED
+		status.Block = block
+		status.Depth = depth
+	}
+	if finished {
+		status.State = OP_CONFIRMED
+		status.RecordNum = recordNum
+	}
+	return status
+}
+
+// errorType can be one of: DISCONNECTED, NO_ERROR
+// Returns the blocks of the longest chain that follow the first block of locator found in the chain,
+// oldest first, or the whole chain from the genesis block if none is. The blocks stop before taking
+// MAX_BLOCKS_SIZE_PER_RESPONSE bytes, clients ask again for the rest.
+func (miner MinerInstance) HeadersHandler(locator []string) (headers []crypto.Block, errorType FailureType) {
+	lg.Println("Handling headers request")
+	miner.minerState.LogLocalEvent(" Handling headers request from client", INFO)
+
+	// check if miner is disconnected
+	if miner.minerState.IsDisconnected() {
+		return nil, DISCONNECTED
+	}
+
+	chain := miner.minerState.GetLongestChain()
+	known := make(map[string]bool)
+	for _, id := range locator {
+		known[id] = true
+	}
+	start := 0
+	for i := len(chain) - 1; i >= 0; i-- {
+		if known[chain[i].Id()] {
+			start = i + 1
+			break
+		}
+	}
+
+	headers = make([]crypto.Block, 0)
+	size := 0
+	for _, b := range chain[start:] {
+		size += blockSize(b)
+		if size > MAX_BLOCKS_SIZE_PER_RESPONSE && len(headers) > 0 {
+			break
+		}
+		headers = append(headers, *b)
+	}
+	return headers, NO_ERROR
+}
+
+// errorType can be one of: INVALID_TRANSFER, DISCONNECTED, OP_EVICTED, OP_EXPIRED, REQUEST_CANCELLED,
+// NOT_ENOUGH_MONEY, BAD_SIGNATURE, OP_TIMED_OUT, OP_REJECTED, INTERNAL_ERROR, NO_ERROR
+// Moves amount coins from the client account of the request to the account recipient, it's confirmed
+// like a create. Only the operator of the miner can transfer the coins of the miner, see AsOperator.
+// opId works like in CreateFileHandler.
+func (miner MinerInstance) TransferHandler(recipient string, amount uint32, tip uint32, opId string) (errorType FailureType) {
+	if miner.unpaid() || (miner.creator == "" && !miner.operator) {
+		return BAD_SIGNATURE
+	}
+	if recipient == "" || amount == 0 {
+		return INVALID_TRANSFER
+	}
+	if opId == "" {
+		opId = miner.newOpId()
+	}
+	for {
+		lg.Println("Handling transfer request")
+		miner.minerState.LogLocalEvent(fmt.Sprintf(" Handling transfer of %d coins to [%s] request from client", amount, recipient), INFO)
+
+		// check if miner is disconnected
+		if miner.minerState.IsDisconnected() {
+			return DISCONNECTED
+		}
+
+		fs, errorType := miner.getFileSystemState()
+		if errorType != NO_ERROR {
+			return errorType
+		}
+
+		// the op made it to the chain before it was dropped, wait for it instead of paying twice
+		if _, _, inChain := fs.GetOp(opId); inChain {
+			return miner.waitForOp(opId)
+		}
+
+		// create job
+		job := new(crypto.BlockOp)
+		job.Type = crypto.Transfer
+		job.Creator = miner.account()
+		job.Recipient = recipient
+		job.Amount = amount
+		job.Tip = tip
+		job.OpId = opId
+		job.Signature = miner.signature(0)
+
+		_, retry, errorType := miner.submitAndWait([]*crypto.BlockOp{job}, nil,
+			func(_ []*crypto.BlockOp, deadline time.Time) <-chan int {
+				return miner.opConfirmation(opId, deadline)
+			})
+		if !retry {
+			return errorType
 		}
 	}
 }
 
+// errorType can be one of: DISCONNECTED, INTERNAL_ERROR, NO_ERROR
+// Returns the coins of account in the longest chain, or of the account paying for the request if empty.
+func (miner MinerInstance) BalanceHandler(account string) (balance int, errorType FailureType) {
+	lg.Println("Handling balance request")
+	miner.minerState.LogLocalEvent(fmt.Sprintf(" Handling balance of [%s] request from client", account), INFO)
+
+	// check if miner is disconnected
+	if miner.minerState.IsDisconnected() {
+		return 0, DISCONNECTED
+	}
+
+	if account == "" {
+		account = miner.account()
+	}
+	accounts, err := miner.minerState.GetAccountState(
+		NUM_COINS_PER_FILE_APPEND,
+		int(miner.minerConf.NumCoinsPerFileCreate),
+		int(miner.minerConf.MinedCoinsPerOpBlock),
+		int(miner.minerConf.MinedCoinsPerNoOpBlock))
+	if err != nil {
+		lg.Println("Couldn't compute the balance of", account, ":", err)
+		return 0, INTERNAL_ERROR
+	}
+	return int(accounts.GetAccountBalance(state.Account(account))), NO_ERROR
+}
+
+// Returns a view of the miner whose handlers stop waiting for their ops and fail with REQUEST_CANCELLED
+// once cancel is closed. Ops already in the mempool are not taken back, they may still be mined.
+func (miner MinerInstance) WithCancel(cancel <-chan bool) Miner {
+	miner.cancel = cancel
+	return miner
+}
+
+// Returns a view of the miner whose handlers charge their ops to the client account creator, see
+// crypto.AccountId, instead of to the miner, signatures being the ones of the ops in the order the
+// handlers create them, see RFSClientRequest.Signatures. An empty creator leaves the ops to the miner, any
+// other account than a client's makes the writes fail with BAD_SIGNATURE.
+func (miner MinerInstance) WithIdentity(creator string, signatures [][]byte) Miner {
+	miner.creator = creator
+	miner.signatures = signatures
+	return miner
+}
+
+// Returns a view of the miner whose handlers wait for the account paying for their ops to afford them
+// depending on waitMode: they fail right away with NOT_ENOUGH_MONEY, once timeout runs out, or wait for
+// as long as it takes like the miner does. Shortfall tells what the account lacked.
+func (miner MinerInstance) WithFundsWait(waitMode WaitMode, timeout time.Duration) Miner {
+	miner.funds = &fundsWait{waitMode: waitMode, deadline: time.Now().Add(timeout)}
+	return miner
+}
+
+// Coins the account paying for the ops of the view had and needed when its handlers last failed with
+// NOT_ENOUGH_MONEY, see WithFundsWait
+func (miner MinerInstance) Shortfall() (balance int, needed int) {
+	if miner.funds == nil {
+		return 0, 0
+	}
+	return miner.funds.balance, miner.funds.needed
+}
+
+// Returns a view of the miner for its operator, whose transfers without a client account spend the coins
+// of the miner. Client requests never get it, only the requests of AdminHandler do.
+func (miner MinerInstance) AsOperator() Miner {
+	miner.operator = true
+	return miner
+}
+
 /////////// Helpers ///////////////////////////////////////////////////////
 
+// Account paying for the ops of the request being handled
+func (miner MinerInstance) account() string {
+	if miner.creator != "" {
+		return miner.creator
+	}
+	return miner.minerConf.MinerID
+}
+
+// Signature of the i-th op of the request being handled, ops without one fail validation unless
+// the miner pays for them
+func (miner MinerInstance) signature(i int) []byte {
+	if i < len(miner.signatures) {
+		return miner.signatures[i]
+	}
+	return nil
+}
+
+// Whether the request has nobody to pay for it, the miner only pays for its operator and, if it was configured
+// to, for clients without an account. Requests can only be paid by client accounts, whose ops fail validation
+// unless the client signed them, any other account is a miner's and would be spent without a signature.
+func (miner MinerInstance) unpaid() bool {
+	if miner.creator != "" {
+		return !crypto.IsClientAccount(miner.creator)
+	}
+	return !miner.operator && !miner.minerConf.PayForClients
+}
+
 func ParseConfig(fileName string) (MinerConfiguration, error){
 	var m MinerConfiguration
 
@@ -378,15 +1412,32 @@ func ParseConfig(fileName string) (MinerConfiguration, error){
 	return m, nil
 }
 
-func (miner MinerInstance) getFileSystemState() state.FilesystemState {
-	fs, err := miner.minerState.GetFilesystemState(
-		int(miner.minerConf.ConfirmsPerFileCreate),
-		int(miner.minerConf.ConfirmsPerFileAppend))
+// errorType can be one of: INTERNAL_ERROR, NO_ERROR
+func (miner MinerInstance) getFileSystemState() (fs state.FilesystemState, errorType FailureType) {
+	return miner.getFileSystemStateAt(ConfirmDepth{
+		Create: int(miner.minerConf.ConfirmsPerFileCreate),
+		Append: int(miner.minerConf.ConfirmsPerFileAppend)})
+}
+
+// errorType can be one of: INTERNAL_ERROR, NO_ERROR
+// The handlers fail with INTERNAL_ERROR when the files at depth can't be replayed from the longest chain.
+func (miner MinerInstance) getFileSystemStateAt(depth ConfirmDepth) (fs state.FilesystemState, errorType FailureType) {
+	fs, err := miner.minerState.GetFilesystemState(depth.Create, depth.Append)
 	if err != nil {
-		// todo ksenia what to do about this case?
-		panic(err)
+		lg.Println("Couldn't compute the files at depth", depth, ":", err)
+		return fs, INTERNAL_ERROR
 	}
-	return fs
+	return fs, NO_ERROR
+}
+
+// Rough number of bytes a block takes once encoded, most of it are the records of its ops
+func blockSize(b *crypto.Block) int {
+	size := len(b.MinerId) + 64
+	for _, op := range crypto.FlattenOps(b.Records) {
+		size += 2*crypto.DataBlockSize + len(op.Filename) + len(op.Creator) + len(op.OpId) + len(op.Recipient) +
+			len(op.Signature) + 32
+	}
+	return size
 }
 
 func getSingleFilesError(compositeError error) FailureType {
@@ -396,13 +1447,30 @@ func getSingleFilesError(compositeError error) FailureType {
 	return NO_ERROR
 }
 
+// Reason a job that failed validation gets rejected for, OP_REJECTED if the validator has no failure type
+// for it, NO_ERROR if the job is valid
+func getRejection(acctsErr error, filesErr error) FailureType {
+	for _, err := range []error{acctsErr, filesErr} {
+		if err == nil {
+			continue
+		}
+		if verr, ok := err.(state.BlockChainValidatorError); ok && verr.GetErrorCode() != NO_ERROR {
+			return verr.GetErrorCode()
+		}
+		return OP_REJECTED
+	}
+	return NO_ERROR
+}
+
 func getSingleAccountsError(compositeError error) FailureType {
 	// todo ksenia make this better
 	if cerr, ok := compositeError.(state.CompositeError); ok {
 		if _, ok := cerr.Current.(state.NotEnoughMoneyValidationError); ok {
-			time.Sleep(time.Second)
 			return NOT_ENOUGH_MONEY
 		}
+		if _, ok := cerr.Current.(state.BadSignatureValidationError); ok {
+			return BAD_SIGNATURE
+		}
 	}
 	return NO_ERROR
 }
@@ -1,6 +1,9 @@
 package instance
 
 import (
+	. "../../shared"
+	"../state"
+	"errors"
 	"testing"
 )
 
@@ -37,5 +40,51 @@ func TestConfig(t *testing.T) {
 		equals(t, "127.0.0.1:8080", mc.IncomingMinersAddr)
 		equals(t, "127.0.0.1", mc.OutgoingMinersIP)
 		equals(t, "127.0.0.1:9090", mc.IncomingClientsAddr)
+		equals(t, "127.0.0.1:9190", mc.IncomingHttpClientsAddr)
+		equals(t, "127.0.0.1:9290", mc.IncomingAdminAddr)
+		equals(t, true, mc.PayForClients)
+	})
+}
+
+func TestGetRejection(t *testing.T) {
+	t.Run("valid jobs aren't rejected", func(t *testing.T) {
+		equals(t, FailureType(NO_ERROR), getRejection(nil, nil))
+	})
+
+	t.Run("should report the reason of the validator", func(t *testing.T) {
+		filesErr := state.CompositeError{Current: state.BadFileNameValidationError{FileName: "f"}}
+		equals(t, FailureType(BAD_FILENAME), getRejection(nil, filesErr))
+	})
+
+	t.Run("should reject jobs the validator has no reason for", func(t *testing.T) {
+		equals(t, FailureType(OP_REJECTED), getRejection(state.UnspecifiedValidationError("invalid"), nil))
+		equals(t, FailureType(OP_REJECTED), getRejection(nil, errors.New("invalid")))
+	})
+}
+
+func TestTransferPayer(t *testing.T) {
+	t.Run("clients can't transfer the coins of the miner", func(t *testing.T) {
+		equals(t, FailureType(BAD_SIGNATURE), MinerInstance{}.TransferHandler("client-1", 1, 0, ""))
+	})
+}
+
+func TestRequestPayer(t *testing.T) {
+	t.Run("requests can't be paid by the account of a miner", func(t *testing.T) {
+		miner := MinerInstance{}.WithIdentity("miner-2", nil)
+		equals(t, FailureType(BAD_SIGNATURE), miner.CreateFileHandler("f", 0, "op"))
+		_, errorType := miner.AppendRecHandler("f", [512]byte{}, 0, "op")
+		equals(t, FailureType(BAD_SIGNATURE), errorType)
+		equals(t, FailureType(BAD_SIGNATURE), miner.TransferHandler("client-1", 1, 0, "op"))
+	})
+
+	t.Run("the miner only pays for requests without an account if it was configured to", func(t *testing.T) {
+		equals(t, true, MinerInstance{}.unpaid())
+		equals(t, false, MinerInstance{minerConf: MinerConfiguration{PayForClients: true}}.unpaid())
+		equals(t, false, MinerInstance{creator: "client-1"}.unpaid())
+	})
+
+	t.Run("the miner pays for its operator", func(t *testing.T) {
+		equals(t, false, MinerInstance{}.AsOperator().(MinerInstance).unpaid())
+		equals(t, FailureType(BAD_SIGNATURE), MinerInstance{}.CreateFileHandler("f", 0, "op"))
 	})
 }
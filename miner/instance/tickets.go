@@ -0,0 +1,79 @@
+package instance
+
+import (
+	. "../../shared"
+	"sync"
+	"time"
+)
+
+// Ops submitted by async requests by op id, so that clients can ask about them later
+type ticketTable struct {
+	mtx     *sync.Mutex
+	tickets map[string]*ticket
+	// how long a finished ticket is kept around
+	expiry time.Duration
+}
+
+type ticket struct {
+	fname string
+	// closed once the op is confirmed or failed
+	done      chan bool
+	recordNum uint16
+	errorType FailureType
+	finished  time.Time
+	// set before the op runs for ops that wait for funds, see WithFundsWait
+	funds     *fundsWait
+}
+
+func newTicketTable(expiry time.Duration) *ticketTable {
+	return &ticketTable{
+		mtx:     &sync.Mutex{},
+		tickets: make(map[string]*ticket),
+		expiry:  expiry,
+	}
+}
+
+// Adds a ticket for opId, added is false if there is one already, in which case that one is returned
+func (tt *ticketTable) add(opId string, fname string) (t *ticket, added bool) {
+	tt.mtx.Lock()
+	defer tt.mtx.Unlock()
+
+	// drop the tickets nobody asked about for a while
+	now := time.Now()
+	for id, t := range tt.tickets {
+		if !t.finished.IsZero() && now.Sub(t.finished) > tt.expiry {
+			delete(tt.tickets, id)
+		}
+	}
+
+	if t, exists := tt.tickets[opId]; exists {
+		return t, false
+	}
+	t = &ticket{fname: fname, done: make(chan bool)}
+	tt.tickets[opId] = t
+	return t, true
+}
+
+func (tt *ticketTable) get(opId string) (*ticket, bool) {
+	tt.mtx.Lock()
+	defer tt.mtx.Unlock()
+	t, ok := tt.tickets[opId]
+	return t, ok
+}
+
+// Records the outcome of the op of the ticket and wakes up whoever waits for it
+func (tt *ticketTable) finish(t *ticket, recordNum uint16, errorType FailureType) {
+	tt.mtx.Lock()
+	defer tt.mtx.Unlock()
+	t.recordNum = recordNum
+	t.errorType = errorType
+	t.finished = time.Now()
+	close(t.done)
+}
+
+// Outcome of the op of the ticket, finished is false while it is still running
+func (tt *ticketTable) result(t *ticket) (recordNum uint16, errorType FailureType, finished bool) {
+	tt.mtx.Lock()
+	defer tt.mtx.Unlock()
+	return t.recordNum, t.errorType, !t.finished.IsZero()
+}
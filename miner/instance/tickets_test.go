@@ -0,0 +1,50 @@
+package instance
+
+import (
+	. "../../shared"
+	"testing"
+	"time"
+)
+
+func TestTicketTable(t *testing.T) {
+	t.Run("should only add a ticket once per op id", func(t *testing.T) {
+		tt := newTicketTable(time.Minute)
+		first, added := tt.add("op", "file")
+		assert(t, added, "should add the ticket")
+		second, added := tt.add("op", "file")
+		assert(t, !added, "should not add the ticket twice")
+		assert(t, first == second, "should return the existing ticket")
+	})
+
+	t.Run("should record the outcome of the op", func(t *testing.T) {
+		tt := newTicketTable(time.Minute)
+		tk, _ := tt.add("op", "file")
+		_, _, finished := tt.result(tk)
+		assert(t, !finished, "should not be finished before the op is done")
+
+		tt.finish(tk, 3, NO_ERROR)
+		select {
+		case <-tk.done:
+		default:
+			t.Fatal("should wake up waiters once finished")
+		}
+		recordNum, errorType, finished := tt.result(tk)
+		assert(t, finished, "should be finished")
+		equals(t, uint16(3), recordNum)
+		equals(t, FailureType(NO_ERROR), errorType)
+	})
+
+	t.Run("should drop finished tickets once they expire", func(t *testing.T) {
+		tt := newTicketTable(time.Millisecond)
+		tk, _ := tt.add("op", "file")
+		tt.finish(tk, 0, OP_EXPIRED)
+		pending, _ := tt.add("pending", "file")
+		time.Sleep(time.Millisecond * 5)
+		tt.add("other", "file")
+		_, found := tt.get("op")
+		assert(t, !found, "should drop the finished ticket")
+		_, found = tt.get("pending")
+		assert(t, found, "should keep the ticket of a running op")
+		tt.finish(pending, 0, NO_ERROR)
+	})
+}
@@ -102,7 +102,8 @@ func generateState(
 			award(res, Account(bae.Block.MinerId), opReward)
 
 			// remove money for all involved accounts
-			err := evaluateBalanceBlockOps(res, bae.Block.Records, appendFee, createFee, nodes, idx)
+			err := evaluateBalanceBlockOps(res, Account(bae.Block.MinerId), bae.Block.Records,
+				appendFee, createFee, nodes, idx)
 			if err != nil {
 				return nil, err
 			}
@@ -114,25 +115,36 @@ func generateState(
 	return res, nil
 }
 
-func evaluateBalanceBlockOps(accs map[Account]Balance, bcs []*crypto.BlockOp,
+func evaluateBalanceBlockOps(accs map[Account]Balance, miner Account, bcs []*crypto.BlockOp,
 	appendFee Balance, createFee Balance, nds []*datastruct.Node, currBlockIdx int) error {
-	for idx, tx := range bcs {
+	for idx, tx := range crypto.FlattenOps(bcs) {
 		switch tx.Type {
-		case crypto.CreateFile:
-			err := spend(accs, Account(tx.Creator), createFee)
+		case crypto.CreateFile, crypto.AppendFile, crypto.Transfer:
+			err := spend(accs, Account(tx.Creator), opCost(tx, appendFee, createFee))
 			if err != nil {
 				return err
 			}
-		case crypto.AppendFile:
-			err := spend(accs, Account(tx.Creator), appendFee)
-			if err != nil {
-				return err
+			if tx.Type == crypto.Transfer {
+				award(accs, Account(tx.Recipient), Balance(tx.Amount))
+			}
+		case crypto.DeleteFile, crypto.Transaction:
+			// the ops of a transaction follow it and pay for themselves, only the tip is left
+			if tx.Type == crypto.DeleteFile {
+				refund(accs, tx.Filename, appendFee, createFee, nds, currBlockIdx, idx)
+			}
+			if tx.Tip > 0 {
+				err := spend(accs, Account(tx.Creator), Balance(tx.Tip))
+				if err != nil {
+					return err
+				}
 			}
-		case crypto.DeleteFile:
-			refund(accs, tx.Filename, appendFee, createFee, nds, currBlockIdx, idx)
 		default:
 			return errors.New("Maria Magdalena (You're a victim of the fight You need love)")
 		}
+		// tips go to whoever mined the block
+		if tx.Tip > 0 {
+			award(accs, miner, Balance(tx.Tip))
+		}
 	}
 	return nil
 }
@@ -163,9 +175,9 @@ func refund(accs map[Account]Balance, filename string, appendFee Balance, create
 		return false
 	}
 
-	bae := nds[currBlockIdx].Value.(crypto.BlockElement).Block
+	records := crypto.FlattenOps(nds[currBlockIdx].Value.(crypto.BlockElement).Block.Records)
 	for j := curTnxId - 1; j >= 0; j-- {
-		tx := bae.Records[j]
+		tx := records[j]
 		if fnApplyTx(tx) {
 			return
 		}
@@ -177,8 +189,9 @@ func refund(accs map[Account]Balance, filename string, appendFee Balance, create
 		if bae.Type != crypto.RegularBlock {
 			continue
 		}
-		for j := len(bae.Records) - 1; j >= 0; j-- {
-			tx := bae.Records[j]
+		records := crypto.FlattenOps(bae.Records)
+		for j := len(records) - 1; j >= 0; j-- {
+			tx := records[j]
 			if fnApplyTx(tx) {
 				return
 			}
@@ -186,6 +199,32 @@ func refund(accs map[Account]Balance, filename string, appendFee Balance, create
 	}
 }
 
+// Total amount the creator of an op pays, the base fee of its type plus the tip.
+// Deletes have no base fee since they refund the file instead, transactions pay for all of their ops.
+func opFee(tx *crypto.BlockOp, appendFee Balance, createFee Balance) Balance {
+	fee := Balance(tx.Tip)
+	switch tx.Type {
+	case crypto.CreateFile:
+		fee += createFee
+	case crypto.AppendFile:
+		fee += appendFee
+	case crypto.Transaction:
+		for _, sub := range tx.Ops {
+			fee += opFee(sub, appendFee, createFee)
+		}
+	}
+	return fee
+}
+
+// Total amount taken from the creator of an op, its fee plus the coins it transfers
+func opCost(tx *crypto.BlockOp, appendFee Balance, createFee Balance) Balance {
+	cost := opFee(tx, appendFee, createFee)
+	if tx.Type == crypto.Transfer {
+		cost += Balance(tx.Amount)
+	}
+	return cost
+}
+
 func spend(accs map[Account]Balance, act Account, fee Balance) error {
 	lg.Printf("Account %v spent %v", act, fee)
 	if v, ok := accs[act]; ok {
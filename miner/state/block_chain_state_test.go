@@ -319,6 +319,45 @@ func TestAccountConfig(t *testing.T) {
 		tree.PrependElement(ee, tree.GetLongestChain())
 	}
 
+	t.Run("test tip is paid to the miner of the block", func(t *testing.T) {
+		treeDef := treeBuilderTest{
+			height: 2,
+			roots:  1,
+			addOrder: []int{
+				// true chain
+				0, 100, 1, int(crypto.NoOpBlock), 0, 1},
+		}
+		tree := buildTree(treeDef)
+		hd := tree.GetLongestChain()
+		prevBlk := [md5.Size]byte{}
+		copy(prevBlk[:], hd.Value.(crypto.BlockElement).Block.Hash())
+		ee := crypto.BlockElement{
+			Block: &crypto.Block{
+				MinerId:   strconv.Itoa(2),
+				Type:      crypto.RegularBlock,
+				PrevBlock: prevBlk,
+				Records: []*crypto.BlockOp{{
+					Type:     crypto.CreateFile,
+					Filename: filenames[0],
+					Creator:  strconv.Itoa(1),
+					Tip:      5,
+				}},
+				Nonce: 12324,
+			},
+		}
+		tree.PrependElement(ee, hd)
+
+		bkState, err := NewAccountsState(appendFee, 10, opReward, noOpReward, tree.GetLongestChain())
+		if err != nil {
+			t.Fatal(err)
+			t.Fail()
+		}
+		mp := make(map[Account]Balance)
+		mp[Account(strconv.Itoa(1))] = 85 // 100 - 10 - 5
+		mp[Account(strconv.Itoa(2))] = 6  // 1 + 5
+		equals(t, mp, bkState.GetAll())
+	})
+
 	t.Run("test create fee", func(t *testing.T) {
 		treeDef := treeBuilderTest{
 			height: 2,
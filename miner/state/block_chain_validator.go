@@ -102,6 +102,52 @@ func (e NotEnoughMoneyValidationError) Error() string {
 		" but it needs " + fmt.Sprintf("%v", e.NeededMoney)
 }
 
+type DuplicateOpValidationError struct {
+	OpId string
+}
+
+func (e DuplicateOpValidationError) GetErrorCode() FailureType {
+	return OP_DUPLICATE
+}
+
+func (e DuplicateOpValidationError) Error() string {
+	return fmt.Sprintf("op %s is already in the chain", e.OpId)
+}
+
+type TransactionValidationError struct {
+	OpId string
+	Reason string
+	// error of the op that made the transaction fail, nil if the transaction itself is malformed
+	Cause BlockChainValidatorError
+}
+
+func (e TransactionValidationError) GetErrorCode() FailureType {
+	if e.Cause != nil {
+		return e.Cause.GetErrorCode()
+	}
+	return TRANSACTION_INVALID
+}
+
+func (e TransactionValidationError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("transaction %s rejected: %v", e.OpId, e.Cause)
+	}
+	return fmt.Sprintf("transaction %s is invalid: %s", e.OpId, e.Reason)
+}
+
+type BadSignatureValidationError struct {
+	OpId string
+	Account string
+}
+
+func (e BadSignatureValidationError) GetErrorCode() FailureType {
+	return BAD_SIGNATURE
+}
+
+func (e BadSignatureValidationError) Error() string {
+	return fmt.Sprintf("op %s is not signed by account %s", e.OpId, e.Account)
+}
+
 type UnspecifiedValidationError string
 
 func (e UnspecifiedValidationError) GetErrorCode() FailureType {
@@ -180,7 +226,7 @@ func (bcv *BlockChainValidator) Validate(b crypto.BlockElement) (*datastruct.Nod
 		bcv.lastFilesystemState = fss
 	}
 
-	fsUp, deletedFiles, err := bcv.validateNewFSState(b)
+	fsUp, deletedFiles, newOps, err := bcv.validateNewFSState(b)
 	if err != nil {
 		return nil, err
 	}
@@ -193,7 +239,7 @@ func (bcv *BlockChainValidator) Validate(b crypto.BlockElement) (*datastruct.Nod
 	bcv.generatingNodeId = b.Id()
 
 	bcv.lastStateAccount.update(accUp)
-	bcv.lastFilesystemState.update(fsUp, deletedFiles)
+	bcv.lastFilesystemState.update(fsUp, deletedFiles, newOps)
 
 	return root, nil
 }
@@ -233,14 +279,14 @@ func (bcv *BlockChainValidator) ValidateJobSet(
 		original = len(newOps)
 		nFile := make(map[Filename]*FileInfo)
 		var err error
-		newOps, _, err = bcv.validateNewFSBlockOps(newOps, nFile)
+		newOps, _, err = bcv.validateNewFSBlockOps(newOps, nFile, make(map[string]*crypto.BlockOp))
 		if err != nil {
 			filesError = err
 			lg.Printf("Rejected some ops, the following is a sample error: %v\n", err)
 		}
 
 		nAcc := make(map[Account]Balance)
-		newOps, err = bcv.validateNewAccountBlockOps(newOps, bcv.mTree.GetLongestChain().Id, nAcc)
+		newOps, err = bcv.validateNewAccountBlockOps(newOps, bcv.mTree.GetLongestChain().Id, bcv.cnf.MinerId, nAcc)
 		if err != nil {
 			accountsError = err
 			lg.Printf("Rejected some ops, the following is a sample error: %v\n", err)
@@ -249,20 +295,45 @@ func (bcv *BlockChainValidator) ValidateJobSet(
 	return newOps, accountsError, filesError
 }
 
-func (bcv *BlockChainValidator) validateNewFSState(b crypto.BlockElement) (map[Filename]*FileInfo, map[string]bool, error) {
+func (bcv *BlockChainValidator) validateNewFSState(b crypto.BlockElement) (
+	map[Filename]*FileInfo, map[string]bool, map[string]*crypto.BlockOp, error) {
 	res := make(map[Filename]*FileInfo)
+	newOps := make(map[string]*crypto.BlockOp)
 	bcs := b.Block.Records
-	_, deletedFiles, err := bcv.validateNewFSBlockOps(bcs, res)
-	return res, deletedFiles, err
+	_, deletedFiles, err := bcv.validateNewFSBlockOps(bcs, res, newOps)
+	return res, deletedFiles, newOps, err
 }
 
 func (bcv *BlockChainValidator) validateNewFSBlockOps(bcs []*crypto.BlockOp,
-		res map[Filename]*FileInfo) ([]*crypto.BlockOp, map[string]bool, error) {
-	validOps := make([]*crypto.BlockOp, 0, len(bcs))
+		res map[Filename]*FileInfo, newOps map[string]*crypto.BlockOp) ([]*crypto.BlockOp, map[string]bool, error) {
 	deletedFiles := make(map[string]bool)
+	validOps, err := bcv.validateFSBlockOps(bcs, res, deletedFiles, newOps)
+	if err != nil {
+		return validOps, deletedFiles, err
+	}
+	return validOps, deletedFiles, nil
+}
+
+func (bcv *BlockChainValidator) validateFSBlockOps(bcs []*crypto.BlockOp, res map[Filename]*FileInfo,
+		deletedFiles map[string]bool, newOps map[string]*crypto.BlockOp) ([]*crypto.BlockOp, BlockChainValidatorError) {
+	validOps := make([]*crypto.BlockOp, 0, len(bcs))
 	var err BlockChainValidatorError = nil
 	fs := bcv.lastFilesystemState.GetAll()
 	for _, tx := range bcs {
+		if tx.Type != crypto.Transaction && len(tx.Ops) > 0 {
+			err = CompositeError{err, UnspecifiedValidationError("only transactions can have ops")}
+			continue
+		}
+
+		// an op id can only be used once in the whole chain
+		if tx.OpId != "" {
+			_, _, inChain := bcv.lastFilesystemState.GetOp(tx.OpId)
+			if _, inRes := newOps[tx.OpId]; inChain || inRes {
+				err = CompositeError{err, DuplicateOpValidationError{tx.OpId}}
+				continue
+			}
+		}
+
 		switch tx.Type {
 		case crypto.CreateFile:
 			if len(tx.Filename) > MAX_FILENAME_LENGTH {
@@ -392,6 +463,54 @@ func (bcv *BlockChainValidator) validateNewFSBlockOps(bcs []*crypto.BlockOp,
 			lg.Printf("validator: removing file %v", tx.Filename)
 			validOps = append(validOps, tx)
 			deletedFiles[tx.Filename] = true
+		case crypto.Transfer:
+			// only moves coins, the file system stays the same
+			if tx.Recipient == "" || tx.Amount == 0 {
+				err = CompositeError{err, UnspecifiedValidationError("transfers need a recipient and an amount")}
+				continue
+			}
+			validOps = append(validOps, tx)
+		case crypto.Transaction:
+			if reason := checkTransaction(tx); reason != "" {
+				err = CompositeError{err, TransactionValidationError{tx.OpId, reason, nil}}
+				continue
+			}
+
+			// validate the ops on a copy of the state, it only gets updated if all of them are valid
+			txRes := make(map[Filename]*FileInfo, len(res))
+			for k, v := range res {
+				txRes[k] = v
+			}
+			txDeletedFiles := make(map[string]bool, len(deletedFiles))
+			for k, v := range deletedFiles {
+				txDeletedFiles[k] = v
+			}
+			txNewOps := make(map[string]*crypto.BlockOp, len(newOps))
+			for k, v := range newOps {
+				txNewOps[k] = v
+			}
+			txOps, txErr := bcv.validateFSBlockOps(tx.Ops, txRes, txDeletedFiles, txNewOps)
+			if len(txOps) != len(tx.Ops) {
+				err = CompositeError{err, TransactionValidationError{tx.OpId, "", txErr}}
+				continue
+			}
+
+			lg.Printf("validator: applying transaction %v", tx.OpId)
+			for k, v := range txRes {
+				res[k] = v
+			}
+			for k := range deletedFiles {
+				if !txDeletedFiles[k] {
+					delete(deletedFiles, k)
+				}
+			}
+			for k, v := range txDeletedFiles {
+				deletedFiles[k] = v
+			}
+			for k, v := range txNewOps {
+				newOps[k] = v
+			}
+			validOps = append(validOps, tx)
 		default:
 
 			err = CompositeError {
@@ -399,8 +518,39 @@ func (bcv *BlockChainValidator) validateNewFSBlockOps(bcs []*crypto.BlockOp,
 				UnspecifiedValidationError("invalid fs op")}
 			continue
 		}
+
+		// only valid ops make it here
+		if tx.OpId != "" {
+			newOps[tx.OpId] = tx
+		}
+	}
+	return validOps, err
+}
+
+// Returns why a transaction is malformed, empty if it isn't. The ops of a transaction
+// are paid and signed by its creator and the transaction carries the tip.
+func checkTransaction(tx *crypto.BlockOp) string {
+	if len(tx.Ops) == 0 {
+		return "it has no ops"
+	}
+	for _, sub := range tx.Ops {
+		if sub.Type == crypto.Transaction {
+			return "transactions cannot be nested"
+		}
+		if sub.Type == crypto.Transfer {
+			return "transfers cannot be part of a transaction"
+		}
+		if len(sub.Signature) > 0 {
+			return "its ops are signed along with it"
+		}
+		if sub.Creator != tx.Creator {
+			return "all of its ops must have the same creator"
+		}
+		if sub.Tip > 0 {
+			return "its ops cannot have tips"
+		}
 	}
-	return validOps, deletedFiles, err
+	return ""
 }
 
 func (bcv *BlockChainValidator) validateNewAccountState(b crypto.BlockElement, parentBlock string) (map[Account]Balance, error) {
@@ -417,68 +567,114 @@ func (bcv *BlockChainValidator) validateNewAccountState(b crypto.BlockElement, p
 		return nil, errors.New("not a valid block type")
 	}
 
-	_, err := bcv.validateNewAccountBlockOps(bcs, parentBlock, res)
+	_, err := bcv.validateNewAccountBlockOps(bcs, parentBlock, b.Block.MinerId, res)
 	return res, err
 }
 
-func (bcv *BlockChainValidator) validateNewAccountBlockOps(bcs []*crypto.BlockOp, parentBlock string, res map[Account]Balance) ([]*crypto.BlockOp, error) {
+func (bcv *BlockChainValidator) validateNewAccountBlockOps(bcs []*crypto.BlockOp, parentBlock string, minerId string,
+	res map[Account]Balance) ([]*crypto.BlockOp, error) {
 	accs := bcv.lastStateAccount
 	validOps := make([]*crypto.BlockOp, 0, len(bcs))
 	var err BlockChainValidatorError = nil
-	for idx, tx := range bcs {
+
+	// refunds need the chain up to the op being refunded, the ops of transactions included
+	refundDelete := func(filename string, flatIdx int) bool {
+		// stupidly expensive way of doing this, better options?
+		parent, ok := bcv.mTree.Find(parentBlock)
+		if !ok {
+			return false
+		}
+
+		// create node chain
+		nds := transverseChain(parent)
+
+		// fake block to make things work
+		fakeBlock := &crypto.Block{
+			Type: crypto.RegularBlock,
+			Records: bcs,
+		}
+		fakeNode := datastruct.Node{
+			Value: crypto.BlockElement{
+				Block: fakeBlock,
+			},
+		}
+		nds = append(nds, &fakeNode)
+		refund(res, filename, bcv.cnf.AppendFee, bcv.cnf.CreateFee, nds, len(nds) - 1, flatIdx)
+		return true
+	}
+
+	nextFlatIdx := 0
+	for _, tx := range bcs {
+		flatIdx := nextFlatIdx
+		nextFlatIdx += 1
+		if tx.Type == crypto.Transaction {
+			nextFlatIdx += len(tx.Ops)
+		}
+
 		act := Account(tx.Creator)
-		var txFee Balance
+		txFee := opCost(tx, bcv.cnf.AppendFee, bcv.cnf.CreateFee)
+
+		// Only clients can spend from their accounts, miners spend from theirs by mining the op
+		if !tx.SignatureValid() {
+			err = CompositeError{err, BadSignatureValidationError{tx.OpId, string(act)}}
+			continue
+		}
+		// Nothing proves a miner created its unsigned ops, so only the miner itself can mine the ones that
+		// hand its coins to someone else
+		if !crypto.IsClientAccount(tx.Creator) && tx.Creator != minerId && (tx.Type == crypto.Transfer || tx.Tip > 0) {
+			err = CompositeError{err, BadSignatureValidationError{tx.OpId, string(act)}}
+			continue
+		}
+
+		// Verify creator has enough balance to pay for the base fee plus the tip and the coins it transfers
+		if _, ok := res[act]; !ok {
+			res[act] = 0
+		}
+		if b := accs.GetAccountBalance(act) + res[act]; b < txFee {
+			err = CompositeError{
+				err,
+				NotEnoughMoneyValidationError{string(act), int(b), int(txFee)}}
+			continue
+		}
+
 		switch tx.Type {
-		case crypto.CreateFile:
-			txFee = bcv.cnf.CreateFee
-		case crypto.AppendFile:
-			txFee = bcv.cnf.AppendFee
+		case crypto.CreateFile, crypto.AppendFile, crypto.Transfer:
+			// only the fee has to be paid, the recipient of a transfer gets its coins below
 		case crypto.DeleteFile:
-			// stupidly expensive way of doing this, better options?
-			parent, ok := bcv.mTree.Find(parentBlock)
-			if !ok {
+			if !refundDelete(tx.Filename, flatIdx) {
 				// todo add error types for this once there is client support for this
 				err = CompositeError{
 					err,
 					UnspecifiedValidationError("coudn't find parent block to calculate refund")}
 				continue
 			}
-
-			// create node chain
-			nds := transverseChain(parent)
-
-			// fake block to make things work
-			fakeBlock := &crypto.Block{
-				Type: crypto.RegularBlock,
-				Records: bcs,
+		case crypto.Transaction:
+			// the creator pays for all of the ops and gets refunded for its deletes
+			refunded := true
+			for k, sub := range tx.Ops {
+				if sub.Type == crypto.DeleteFile {
+					refunded = refunded && refundDelete(sub.Filename, flatIdx + 1 + k)
+				}
 			}
-			fakeNode := datastruct.Node{
-				Value: crypto.BlockElement{
-					Block: fakeBlock,
-				},
+			if !refunded {
+				err = CompositeError{
+					err,
+					UnspecifiedValidationError("coudn't find parent block to calculate refund")}
+				continue
 			}
-			nds = append(nds, &fakeNode)
-			refund(res, tx.Filename, bcv.cnf.AppendFee, bcv.cnf.CreateFee, nds, len(nds) - 1, idx)
-			validOps = append(validOps, tx)
-			continue
 		default:
 			return []*crypto.BlockOp{}, errors.New("not a valid file op")
 		}
 
-		// Verify miner has enough balance to perform transaction
-		if _, ok := res[act]; !ok {
-			res[act] = 0
+		// Apply fee to the account and pay the tip to the miner
+		res[act] -= txFee
+		if tx.Type == crypto.Transfer {
+			award(res, Account(tx.Recipient), Balance(tx.Amount))
 		}
-		if b := accs.GetAccountBalance(act) + res[act]; b < txFee {
-			err = CompositeError{
-				err,
-				NotEnoughMoneyValidationError{string(act), int(b), int(txFee)}}
-			continue
-		} else {
-			// Apply fee to the account
-			res[act] -= txFee
-			validOps = append(validOps, tx)
+		if tx.Tip > 0 {
+			award(res, Account(minerId), Balance(tx.Tip))
 		}
+		validOps = append(validOps, tx)
 	}
 	return validOps, err
 }
@@ -0,0 +1,140 @@
+package state
+
+import (
+	"../../crypto"
+	. "../../shared"
+	"sync"
+	"time"
+)
+
+type confirmationKind int
+
+const (
+	opConfirmation confirmationKind = iota
+	recordConfirmation
+	createConfirmation
+	deleteConfirmation
+	headConfirmation
+)
+
+// How often the index looks at all of its listeners to remove the expired ones
+const confirmationSweepInterval = time.Minute
+
+// What a confirmation listener waits for: an op by its op id, a record by its file and record number,
+// the create or delete of a file, or just the next head
+type confirmationKey struct {
+	kind      confirmationKind
+	opId      string
+	fname     Filename
+	recordNum uint16
+}
+
+// Confirmation listeners by what they wait for. Each new head builds its confirmed state once and only
+// looks up the keys of the ops it confirmed, the listeners only block on their channels in the meantime.
+type confirmationIndex struct {
+	mtx       *sync.Mutex
+	listeners map[confirmationKey][]ConfirmationListener
+	// state of the last head that was checked, nil before the first one
+	last *FilesystemState
+	// time of the last check that looked at every listener
+	sweptAt time.Time
+}
+
+func newConfirmationIndex() *confirmationIndex {
+	return &confirmationIndex{
+		mtx:       new(sync.Mutex),
+		listeners: make(map[confirmationKey][]ConfirmationListener),
+	}
+}
+
+// The listener is notified right away if the last head checked already confirmed its change
+func (ci *confirmationIndex) add(listener ConfirmationListener) {
+	ci.mtx.Lock()
+	defer ci.mtx.Unlock()
+	if ci.last != nil && listener.confirm(*ci.last) {
+		return
+	}
+	key := listener.key()
+	ci.listeners[key] = append(ci.listeners[key], listener)
+}
+
+// Removes listener if it is still waiting, listeners are compared by value so that the copy the caller
+// kept matches the one that was added
+func (ci *confirmationIndex) remove(listener ConfirmationListener) {
+	ci.mtx.Lock()
+	defer ci.mtx.Unlock()
+	key := listener.key()
+	listeners := ci.listeners[key]
+	for i, l := range listeners {
+		if l != listener {
+			continue
+		}
+		if len(listeners) == 1 {
+			delete(ci.listeners, key)
+		} else {
+			ci.listeners[key] = append(listeners[:i:i], listeners[i+1:]...)
+		}
+		return
+	}
+}
+
+// Notifies the listeners whose change fs has confirmed and removes them. If fs follows the last head
+// checked only the keys of the ops it confirmed are looked up. Otherwise the chain switched branches, so
+// every key is, as it is once in a while to remove the expired listeners.
+func (ci *confirmationIndex) check(fs FilesystemState) {
+	ci.mtx.Lock()
+	defer ci.mtx.Unlock()
+	follows := ci.last != nil && fs.parent != "" && fs.parent == ci.last.head
+	ci.last = &fs
+	if !follows || time.Since(ci.sweptAt) > confirmationSweepInterval {
+		ci.sweptAt = time.Now()
+		for key := range ci.listeners {
+			ci.checkKey(key, fs)
+		}
+		return
+	}
+	ci.checkKey(confirmationKey{kind: headConfirmation}, fs)
+	for _, op := range fs.newlyConfirmed {
+		for _, key := range confirmedKeys(op) {
+			ci.checkKey(key, fs)
+		}
+	}
+}
+
+// Notifies the listeners of key whose change fs has confirmed, removes them and the expired ones
+func (ci *confirmationIndex) checkKey(key confirmationKey, fs FilesystemState) {
+	listeners, ok := ci.listeners[key]
+	if !ok {
+		return
+	}
+	waiting := make([]ConfirmationListener, 0, len(listeners))
+	for _, listener := range listeners {
+		if listener.confirm(fs) || listener.IsExpired() {
+			continue
+		}
+		waiting = append(waiting, listener)
+	}
+	if len(waiting) == 0 {
+		delete(ci.listeners, key)
+	} else {
+		ci.listeners[key] = waiting
+	}
+}
+
+// Keys of the listeners that can be waiting for op to be confirmed
+func confirmedKeys(op *crypto.BlockOp) []confirmationKey {
+	keys := make([]confirmationKey, 0, 2)
+	if op.OpId != "" {
+		keys = append(keys, confirmationKey{kind: opConfirmation, opId: op.OpId})
+	}
+	switch op.Type {
+	case crypto.CreateFile:
+		keys = append(keys, confirmationKey{kind: createConfirmation, fname: Filename(op.Filename)})
+	case crypto.AppendFile:
+		keys = append(keys,
+			confirmationKey{kind: recordConfirmation, fname: Filename(op.Filename), recordNum: op.RecordNumber})
+	case crypto.DeleteFile:
+		keys = append(keys, confirmationKey{kind: deleteConfirmation, fname: Filename(op.Filename)})
+	}
+	return keys
+}
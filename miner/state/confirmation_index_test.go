@@ -0,0 +1,128 @@
+package state
+
+import (
+	"../../crypto"
+	. "../../shared"
+	"testing"
+	"time"
+)
+
+func TestConfirmationIndex(t *testing.T) {
+	later := time.Now().Add(time.Minute)
+	fsState := func(files map[Filename]*FileInfo, ops map[string]opInChain) FilesystemState {
+		return FilesystemState{fs: files, ops: ops}
+	}
+
+	t.Run("indexes listeners by what they wait for", func(t *testing.T) {
+		ci := newConfirmationIndex()
+		ci.add(OpConfirmationListener{OpId: "op", ExpirationTime: later})
+		ci.add(AppendConfirmationListener{OpId: "op", Filename: "a", ExpirationTime: later})
+		ci.add(AppendConfirmationListener{Filename: "a", RecordNumber: 1, ExpirationTime: later})
+		ci.add(CreateConfirmationListener{Filename: "a", ExpirationTime: later})
+		ci.add(DeleteConfirmationListener{Filename: "a", ExpirationTime: later})
+		equals(t, 2, len(ci.listeners[confirmationKey{kind: opConfirmation, opId: "op"}]))
+		equals(t, 1, len(ci.listeners[confirmationKey{kind: recordConfirmation, fname: "a", recordNum: 1}]))
+		equals(t, 1, len(ci.listeners[confirmationKey{kind: createConfirmation, fname: "a"}]))
+		equals(t, 1, len(ci.listeners[confirmationKey{kind: deleteConfirmation, fname: "a"}]))
+	})
+
+	t.Run("notifies the listeners of a confirmed op once", func(t *testing.T) {
+		ci := newConfirmationIndex()
+		ocl := OpConfirmationListener{OpId: "op", NotifyChannel: make(chan int, 1), ExpirationTime: later}
+		acl := AppendConfirmationListener{OpId: "op", NotifyChannel: make(chan int, 1), ExpirationTime: later}
+		ci.add(ocl)
+		ci.add(acl)
+
+		pending := fsState(map[Filename]*FileInfo{}, map[string]opInChain{
+			"op": {op: &crypto.BlockOp{RecordNumber: 3}}})
+		ci.check(pending)
+		equals(t, 0, len(ocl.NotifyChannel))
+		equals(t, 0, len(acl.NotifyChannel))
+
+		confirmed := fsState(map[Filename]*FileInfo{}, map[string]opInChain{
+			"op": {op: &crypto.BlockOp{RecordNumber: 3}, confirmed: true}})
+		ci.check(confirmed)
+		ci.check(confirmed)
+		equals(t, 1, len(ocl.NotifyChannel))
+		equals(t, 3, <-acl.NotifyChannel)
+		equals(t, 0, len(ci.listeners))
+	})
+
+	t.Run("notifies creates and deletes of files", func(t *testing.T) {
+		ci := newConfirmationIndex()
+		ccl := CreateConfirmationListener{Creator: "me", Filename: "a", NotifyChannel: make(chan int, 1), ExpirationTime: later}
+		dcl := DeleteConfirmationListener{Filename: "b", NotifyChannel: make(chan int, 1), ExpirationTime: later}
+		ci.add(ccl)
+		ci.add(dcl)
+
+		ci.check(fsState(map[Filename]*FileInfo{"a": {Creator: "other"}, "b": {}}, map[string]opInChain{}))
+		equals(t, 2, len(ci.listeners))
+
+		ci.check(fsState(map[Filename]*FileInfo{"a": {Creator: "me"}}, map[string]opInChain{}))
+		equals(t, 1, len(ccl.NotifyChannel))
+		equals(t, 1, len(dcl.NotifyChannel))
+		equals(t, 0, len(ci.listeners))
+	})
+
+	t.Run("only looks up the ops the new head confirmed", func(t *testing.T) {
+		ci := newConfirmationIndex()
+		appended := &crypto.BlockOp{Type: crypto.AppendFile, Filename: "a", RecordNumber: 0}
+		rcl := RecordConfirmationListener{Filename: "a", RecordNumber: 0, NotifyChannel: make(chan int, 1), ExpirationTime: later}
+		ccl := CreateConfirmationListener{Filename: "b", NotifyChannel: make(chan int, 1), ExpirationTime: later}
+		hl := HeadListener{Head: "h1", NotifyChannel: make(chan int, 1), ExpirationTime: later}
+		ci.check(FilesystemState{fs: map[Filename]*FileInfo{}, ops: map[string]opInChain{}, head: "h1", parent: "h0"})
+		ci.add(rcl)
+		ci.add(ccl)
+		ci.add(hl)
+
+		// b shows up without a create confirmed by the head, only a full check would notice
+		files := map[Filename]*FileInfo{"a": {NumberOfRecords: 1}, "b": {}}
+		ci.check(FilesystemState{fs: files, ops: map[string]opInChain{}, head: "h2", parent: "h1",
+			newlyConfirmed: []*crypto.BlockOp{appended}})
+		equals(t, 1, len(rcl.NotifyChannel))
+		equals(t, 1, len(hl.NotifyChannel))
+		equals(t, 0, len(ccl.NotifyChannel))
+
+		// on another branch every listener is looked at
+		ci.check(FilesystemState{fs: files, ops: map[string]opInChain{}, head: "x3", parent: "x2"})
+		equals(t, 1, len(ccl.NotifyChannel))
+		equals(t, 0, len(ci.listeners))
+	})
+
+	t.Run("notifies a listener added after its change was confirmed", func(t *testing.T) {
+		ci := newConfirmationIndex()
+		ci.check(fsState(map[Filename]*FileInfo{"a": {NumberOfRecords: 2}}, map[string]opInChain{}))
+		rcl := RecordConfirmationListener{Filename: "a", RecordNumber: 1, NotifyChannel: make(chan int, 1), ExpirationTime: later}
+		ci.add(rcl)
+		equals(t, 1, <-rcl.NotifyChannel)
+		equals(t, 0, len(ci.listeners))
+	})
+
+	t.Run("removes the listeners nobody waits on", func(t *testing.T) {
+		ci := newConfirmationIndex()
+		given := RecordConfirmationListener{Filename: "a", RecordNumber: 1, NotifyChannel: make(chan int, 1), ExpirationTime: later}
+		waiting := RecordConfirmationListener{Filename: "a", RecordNumber: 1, NotifyChannel: make(chan int, 1), ExpirationTime: later}
+		ci.add(given)
+		ci.add(waiting)
+
+		ci.remove(given)
+		ci.remove(given)
+		equals(t, []ConfirmationListener{waiting}, ci.listeners[confirmationKey{kind: recordConfirmation, fname: "a", recordNum: 1}])
+		ci.remove(waiting)
+		equals(t, 0, len(ci.listeners))
+	})
+
+	t.Run("removes the expired listeners", func(t *testing.T) {
+		ci := newConfirmationIndex()
+		expired := OpConfirmationListener{
+			OpId: "op",
+			NotifyChannel: make(chan int, 1),
+			ExpirationTime: time.Now().Add(-time.Second)}
+		waiting := OpConfirmationListener{OpId: "op", NotifyChannel: make(chan int, 1), ExpirationTime: later}
+		ci.add(expired)
+		ci.add(waiting)
+
+		ci.check(fsState(map[Filename]*FileInfo{}, map[string]opInChain{}))
+		equals(t, []ConfirmationListener{waiting}, ci.listeners[confirmationKey{kind: opConfirmation, opId: "op"}])
+	})
+}
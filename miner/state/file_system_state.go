@@ -5,25 +5,45 @@ import (
 	. "../../shared"
 	"../../shared/datastruct"
 	"errors"
-	"fmt"
 	"strconv"
 )
 
 type FilesystemState struct {
-	fs map[Filename]*FileInfo
+	fs  map[Filename]*FileInfo
+	ops map[string]opInChain
+	// newest block whose ops can be part of the state
+	confirmedBlock string
+	// head of the chain the state was built from and its parent
+	head   string
+	parent string
+	// ops the head confirmed, the ones that weren't confirmed yet at its parent
+	newlyConfirmed []*crypto.BlockOp
+}
+
+// Every op with an op id in the chain, confirmed or not, along with the id of its block and
+// the number of blocks on top of it
+type opInChain struct {
+	op        *crypto.BlockOp
+	confirmed bool
+	block     string
+	depth     int
 }
 
 func (b FilesystemState) GetAll() map[Filename]*FileInfo {
 	return b.fs
 }
 
-func (b *FilesystemState) update(newData map[Filename]*FileInfo, deletedFiles map[string]bool) {
+func (b *FilesystemState) update(newData map[Filename]*FileInfo, deletedFiles map[string]bool,
+	newOps map[string]*crypto.BlockOp) {
 	for k, v := range newData {
 		b.fs[k] = v
 	}
 	for k := range deletedFiles {
 		delete(b.fs, Filename(k))
 	}
+	for k, v := range newOps {
+		b.ops[k] = opInChain{op: v, confirmed: true}
+	}
 }
 
 func (b FilesystemState) GetFile(acc Filename) (*FileInfo, bool) {
@@ -31,42 +51,123 @@ func (b FilesystemState) GetFile(acc Filename) (*FileInfo, bool) {
 	return v, ok
 }
 
+// Looks up an op in the chain by its op id, confirmed is true if the op is deep enough
+// in the chain to be part of this state
+func (b FilesystemState) GetOp(opId string) (op *crypto.BlockOp, confirmed bool, ok bool) {
+	v, ok := b.ops[opId]
+	return v.op, v.confirmed, ok
+}
+
+// Looks up the block an op was mined in by its op id, depth is the number of blocks on top of it.
+// Ops that are not in the chain yet are not found
+func (b FilesystemState) GetOpBlock(opId string) (block string, depth int, ok bool) {
+	v, ok := b.ops[opId]
+	if !ok || v.block == "" {
+		return "", 0, false
+	}
+	return v.block, v.depth, true
+}
+
+// Id of the head of the chain the state was built from
+func (b FilesystemState) GetHead() string {
+	return b.head
+}
+
+// Id of the newest block of the chain whose ops can be part of this state, the one followed by as many
+// blocks as the smallest of the confirmation depths. Two chains sharing this block have the same state
+// at the same depths
+func (b FilesystemState) GetConfirmedBlock() string {
+	return b.confirmedBlock
+}
+
 func NewFilesystemState(
 	confirmsPerFileCreate int,
 	confirmsPerFileAppend int,
 	nd *datastruct.Node) (FilesystemState, error) {
 	if nd == nil {
 		return FilesystemState{
-			fs: make(map[Filename]*FileInfo),
+			fs:  make(map[Filename]*FileInfo),
+			ops: make(map[string]opInChain),
 		}, nil
 	}
 	lg.Printf("Creating new fs state with %v as top", nd.Id)
 	nds := transverseChain(nd)
-	fs, err := generateFilesystem(nds, confirmsPerFileCreate, confirmsPerFileAppend)
+	fs, ops, err := generateFilesystem(nds, confirmsPerFileCreate, confirmsPerFileAppend)
 
+	confirms := confirmsPerFileCreate
+	if confirmsPerFileAppend < confirms {
+		confirms = confirmsPerFileAppend
+	}
+	confirmedIdx := len(nds) - 1 - confirms
+	if confirmedIdx < 0 {
+		confirmedIdx = 0
+	}
+	parent := ""
+	if len(nds) > 1 {
+		parent = nds[len(nds) - 2].Id
+	}
 	return FilesystemState{
-		fs: fs,
+		fs:             fs,
+		ops:            ops,
+		confirmedBlock: nds[confirmedIdx].Id,
+		head:           nd.Id,
+		parent:         parent,
+		newlyConfirmed: newlyConfirmedOps(nds, confirmsPerFileCreate, confirmsPerFileAppend),
 	}, err
 }
 
+// Ops that became confirmed with the last block of the chain, only the blocks as deep as one of the
+// confirmation depths can have them
+func newlyConfirmedOps(nds []*datastruct.Node, confirmsPerFileCreate int, confirmsPerFileAppend int) []*crypto.BlockOp {
+	confirmsPerTransaction := confirmsPerFileCreate
+	if confirmsPerFileAppend > confirmsPerTransaction {
+		confirmsPerTransaction = confirmsPerFileAppend
+	}
+	ops := make([]*crypto.BlockOp, 0)
+	for idx := len(nds) - 1 - confirmsPerTransaction; idx < len(nds); idx++ {
+		if idx < 0 {
+			continue
+		}
+		block := nds[idx].Value.(crypto.BlockElement).Block
+		if block.Type != crypto.RegularBlock {
+			continue
+		}
+		numNodesInFrontOfMe := len(nds) - idx - 1
+		for _, tx := range block.Records {
+			confirms := confirmsPerFileCreate
+			switch tx.Type {
+			case crypto.AppendFile:
+				confirms = confirmsPerFileAppend
+			case crypto.Transaction:
+				confirms = confirmsPerTransaction
+			}
+			if numNodesInFrontOfMe == confirms {
+				ops = append(ops, crypto.FlattenOps([]*crypto.BlockOp{tx})...)
+			}
+		}
+	}
+	return ops
+}
+
 func generateFilesystem(
 	nodes []*datastruct.Node,
 	confirmsPerFileCreate int,
-	confirmsPerFileAppend int) (map[Filename]*FileInfo, error) {
+	confirmsPerFileAppend int) (map[Filename]*FileInfo, map[string]opInChain, error) {
 	res := make(map[Filename]*FileInfo)
+	ops := make(map[string]opInChain)
 
 	// sanity checks
 	if len(nodes) == 0 {
-		return res, nil
+		return res, ops, nil
 	}
 	switch nodes[0].Value.(type) {
 	case crypto.BlockElement:
 		if nodes[0].Value.(crypto.BlockElement).Block.Type != crypto.GenesisBlock {
-			return nil, errors.New("genesis block should be the first block")
+			return nil, nil, errors.New("genesis block should be the first block")
 		}
 	default:
 		// if we reach this case then the tree is not built out of a blockchain, fail
-		return nil, errors.New("cannot generate a state out of this blockchain")
+		return nil, nil, errors.New("cannot generate a state out of this blockchain")
 	}
 
 	// start iterating
@@ -75,7 +176,7 @@ func generateFilesystem(
 		switch bae.Block.Type {
 		case crypto.GenesisBlock:
 			if idx != 0 {
-				return nil, errors.New("genesis block should be the first block, not the " + strconv.Itoa(idx) + " block")
+				return nil, nil, errors.New("genesis block should be the first block, not the " + strconv.Itoa(idx) + " block")
 			}
 			// do not award any currency to anybody
 		case crypto.RegularBlock:
@@ -88,69 +189,36 @@ func generateFilesystem(
 			if numNodesInFrontOfMe >= confirmsPerFileAppend {
 				appendOpsConfirmed = true
 			}
-			err := evaluateFSBlockOps(res, bae.Block.Records, createOpsConfirmed, appendOpsConfirmed)
+			err := ApplyFileOps(res, bae.Block.Records, createOpsConfirmed, appendOpsConfirmed)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
+			addOpsInChain(ops, bae.Block.Records, nd.Id, numNodesInFrontOfMe, createOpsConfirmed, appendOpsConfirmed)
 		case crypto.NoOpBlock:
 			// do nothing here
 		}
 	}
-	return res, nil
+	return res, ops, nil
 }
 
-func evaluateFSBlockOps(
-	fs map[Filename]*FileInfo,
+func addOpsInChain(
+	ops map[string]opInChain,
 	bcs []*crypto.BlockOp,
+	block string,
+	depth int,
 	createOpsConfirmed bool,
-	appendOpsConfirmed bool) error {
+	appendOpsConfirmed bool) {
 	for _, tx := range bcs {
+		confirmed := createOpsConfirmed
 		switch tx.Type {
-		case crypto.CreateFile:
-			if createOpsConfirmed {
-				if len(tx.Filename) > MAX_FILENAME_LENGTH {
-					return errors.New("filename is to big for the given file")
-				}
-
-				if _, exists := fs[Filename(tx.Filename)]; exists {
-					return errors.New("file " + tx.Filename + " is duplicated, not a valid transaction")
-				}
-				lg.Printf("Creating file %v", tx.Filename)
-				fi := FileInfo{
-					Data:            make([]byte, 0, crypto.DataBlockSize),
-					NumberOfRecords: 0,
-					Creator:         tx.Creator,
-				}
-				fs[Filename(tx.Filename)] = &fi
-			}
 		case crypto.AppendFile:
-			if appendOpsConfirmed {
-				if f, exists := fs[Filename(tx.Filename)]; exists {
-					if f.NumberOfRecords >= MAX_RECORD_COUNT {
-						return errors.New(fmt.Sprintf("file %s has reached maximum capacity", tx.Filename))
-					}
-					if tx.RecordNumber != f.NumberOfRecords {
-						return errors.New("append no " + strconv.Itoa(int(tx.RecordNumber)) +
-							" to file " + tx.Filename + " duplicated in chain, failing")
-					}
-					lg.Printf("Appending to file %v record no %v", tx.Filename, tx.RecordNumber)
-					f.NumberOfRecords += 1
-					f.Data = append(f.Data, FileData(tx.Data[:])...)
-				} else {
-					return errors.New("file " + tx.Filename + " doesn't exist but tried to append")
-				}
-			}
-		case crypto.DeleteFile:
-			if createOpsConfirmed {
-				if _, exists := fs[Filename(tx.Filename)]; !exists {
-					return errors.New("file " + tx.Filename + " doesn't exist and cannot delete")
-				}
-				lg.Printf("Deleting file %v", tx.Filename)
-				delete(fs, Filename(tx.Filename))
-			}
-		default:
-			return errors.New("vous les hommes êtes tous les mêmes, Macho mais cheap, Bande de mauviettes infidèles")
+			confirmed = appendOpsConfirmed
+		case crypto.Transaction:
+			confirmed = createOpsConfirmed && appendOpsConfirmed
+			addOpsInChain(ops, tx.Ops, block, depth, confirmed, confirmed)
+		}
+		if tx.OpId != "" {
+			ops[tx.OpId] = opInChain{op: tx, confirmed: confirmed, block: block, depth: depth}
 		}
 	}
-	return nil
 }
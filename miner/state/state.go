@@ -27,12 +27,23 @@ type MinerState struct {
 	incomingAddr string
 	listeners *list.List
 	listenersMux *sync.Mutex
+	confirmations *confirmationIndex
+	// the heads of the longest chain are notified one at a time
+	headsMux *sync.Mutex
+	// depth of the confirmed state the listeners get
+	confirmsPerFileCreate int
+	confirmsPerFileAppend int
 	singleMinerDisconnected bool
+	appendFee Balance
+	createFee Balance
+	// op id -> channels of the handlers waiting for the op
+	jobWatchers map[string][]chan FailureType
+	jobWatchersMux *sync.Mutex
 }
 
 type Config struct {
 	AppendFee             Balance // Note that this is not user-configured. Always exactly 1 coin.
-	CreateFee             Balance
+	CreateFee             Balance // Base fees, ops can pay a tip to the miner on top of them
 	OpReward              Balance
 	NoOpReward            Balance
 	OpNumberOfZeros       int
@@ -46,6 +57,9 @@ type Config struct {
 	GenesisBlockHash      [md5.Size]byte
 	GenOpBlockTimeout     uint8
 	SingleMinerDisconnected bool // true if we consider a single miner to be 'disconnected' from the network
+	MaxPendingOps         int           // 0 means the mempool is unbounded
+	PendingOpExpiryBlocks int           // 0 means ops never expire by block count
+	PendingOpExpiry       time.Duration // 0 means ops never expire by age
 }
 
 var lg = log.New(os.Stdout, "state: ", log.Lmicroseconds|log.Lshortfile)
@@ -60,6 +74,20 @@ func (s MinerState) GetBlock(id string) (*crypto.Block, bool) {
 	return (*s.tm).GetBlock(id)
 }
 
+// Blocks of the longest chain, from the genesis block to the newest one
+func (s MinerState) GetLongestChain() []*crypto.Block {
+	head := (*s.tm).GetLongestChain()
+	if head == nil {
+		return nil
+	}
+	nds := transverseChain(head)
+	blocks := make([]*crypto.Block, len(nds))
+	for i, nd := range nds {
+		blocks[i] = nd.Value.(crypto.BlockElement).Block
+	}
+	return blocks
+}
+
 func (s MinerState) GetRoots() []*crypto.Block {
 	return (*s.tm).GetRoots()
 }
@@ -136,26 +164,44 @@ func (s MinerState) OnNewBlockInTree(b *crypto.Block) {
 }
 
 func (s MinerState) OnNewBlockInLongestChain(b *crypto.Block) {
+	(*s.bc).RestartBlockCalculation()
+	s.LogLocalEvent(fmt.Sprintf(" New head on longest chain: %s...", TruncateString(b.Id(), 6)), INFO)
+	s.notifyHead(b)
+}
+
+// The tree notifies every head on its own goroutine, so they can arrive in any order. A head that isn't the
+// head of the longest chain anymore is dropped, the tree notifies the newer one too
+func (s MinerState) notifyHead(b *crypto.Block) {
+	s.headsMux.Lock()
+	defer s.headsMux.Unlock()
+	head := (*s.tm).GetLongestChain()
+	if head == nil || head.Id != b.Id() {
+		return
+	}
+	// the confirmed state of the head is built once for all of the listeners
+	fs, err := NewFilesystemState(s.confirmsPerFileCreate, s.confirmsPerFileAppend, head)
+	if err != nil {
+		lg.Println("OnNewBlockInLongestChain, ", err)
+		return
+	}
+	s.confirmations.check(fs)
+	s.notifyTreeListeners(fs)
+}
+
+// Only called with the heads in order, and tree listeners don't block
+func (s MinerState) notifyTreeListeners(fs FilesystemState) {
 	s.listenersMux.Lock()
 	defer s.listenersMux.Unlock()
-	for e := s.listeners.Front(); e != nil; e = e.Next() {
-		go func(node *list.Element) {
-			if node != nil && node.Value != nil {
-				if succeed := node.Value.(TreeListener).TreeEventHandler(); succeed {
-					s.listenersMux.Lock()
-					s.listeners.Remove(node)
-					s.listenersMux.Unlock()
-				} else if expired := node.Value.(TreeListener).IsExpired(); expired {
-					s.listenersMux.Lock()
-					s.listeners.Remove(node)
-					s.listenersMux.Unlock()
-				}
-			}
-		}(e)
+	for e := s.listeners.Front(); e != nil; {
+		next := e.Next()
+		listener := e.Value.(TreeListener)
+		if succeed := listener.TreeEventHandler(fs); succeed {
+			s.listeners.Remove(e)
+		} else if expired := listener.IsExpired(); expired {
+			s.listeners.Remove(e)
+		}
+		e = next
 	}
-	
-	(*s.bc).RestartBlockCalculation()
-	s.LogLocalEvent(fmt.Sprintf(" New head on longest chain: %s...", TruncateString(b.Id(), 6)), INFO)
 }
 
 func (s MinerState) AddBlock(b *crypto.Block) {
@@ -208,16 +254,68 @@ func (s MinerState) broadcastBlock(b *crypto.Block, ignoreHosts map[string]bool)
 }
 
 func (s MinerState) AddJob(b crypto.BlockOp) {
-	if (*s.bc).JobExists(&b) < 0 {
+	if (*s.bc).AddJob(&b) {
 		lg.Printf("Added new job: %v", b.Filename)
 		s.LogLocalEvent(fmt.Sprintf(" Enqueuing job for file [%v] and record [%v] for miner to work on", b.Filename, b.RecordNumber), INFO)
-		(*s.bc).AddJob(&b)
 		s.broadcastJob(&b)
 	} else {
 		lg.Printf("WARN: Recieved job for file %v but rejected", b.Filename)
-		s.LogLocalEvent(fmt.Sprintf(" Recieved job for file [%v] but I have it", b.Filename), WARN)
+		s.LogLocalEvent(fmt.Sprintf(" Recieved job for file [%v] but rejected it", b.Filename), WARN)
+	}
+}
+
+// Returns a channel that gets the reason why the op left the mempool without being mined.
+// Call it before adding the job so that no notification is missed.
+func (s MinerState) WatchJob(b *crypto.BlockOp) <-chan FailureType {
+	return s.WatchJobs([]*crypto.BlockOp{b})
+}
+
+// Stops the watch that returned ch, other handlers watching the same op keep theirs
+func (s MinerState) UnwatchJob(b *crypto.BlockOp, ch <-chan FailureType) {
+	s.UnwatchJobs([]*crypto.BlockOp{b}, ch)
+}
+
+// Same as WatchJob, the reasons of all of the jobs are sent to the same channel
+func (s MinerState) WatchJobs(bs []*crypto.BlockOp) <-chan FailureType {
+	ch := make(chan FailureType, len(bs))
+	s.jobWatchersMux.Lock()
+	for _, b := range bs {
+		s.jobWatchers[b.Id()] = append(s.jobWatchers[b.Id()], ch)
+	}
+	s.jobWatchersMux.Unlock()
+	return ch
+}
+
+func (s MinerState) UnwatchJobs(bs []*crypto.BlockOp, ch <-chan FailureType) {
+	s.jobWatchersMux.Lock()
+	for _, b := range bs {
+		watchers := s.jobWatchers[b.Id()]
+		for i, watcher := range watchers {
+			if watcher == ch {
+				watchers = append(watchers[:i], watchers[i+1:]...)
+				break
+			}
+		}
+		if len(watchers) == 0 {
+			delete(s.jobWatchers, b.Id())
+		} else {
+			s.jobWatchers[b.Id()] = watchers
+		}
 	}
+	s.jobWatchersMux.Unlock()
+}
 
+// call from the calculators when an op gets rejected, evicted or expires
+func (s MinerState) OnJobDropped(b *crypto.BlockOp, reason FailureType) {
+	s.LogLocalEvent(fmt.Sprintf(" Dropped job for file [%v] and record [%v]", b.Filename, b.RecordNumber), WARN)
+	s.jobWatchersMux.Lock()
+	defer s.jobWatchersMux.Unlock()
+	for _, ch := range s.jobWatchers[b.Id()] {
+		select {
+		case ch <- reason:
+		default:
+		}
+	}
 }
 
 func (s MinerState) broadcastJob(b *crypto.BlockOp) {
@@ -253,6 +351,10 @@ func (s MinerState) InLongestChain(id string) int {
 	return (*s.tm).InLongestChain(id)
 }
 
+func (s MinerState) GetOpFee(b *crypto.BlockOp) int {
+	return int(opFee(b, s.appendFee, s.createFee))
+}
+
 func (s MinerState) SleepMiner() {
 	(*s.bc).ShutdownThreads()
 }
@@ -280,6 +382,17 @@ func (s MinerState) AddTreeListener(listener TreeListener) {
 	s.listenersMux.Unlock()
 }
 
+// The listener is notified on the first new head whose confirmed state has the change it waits for
+func (s MinerState) AddConfirmationListener(listener ConfirmationListener) {
+	s.confirmations.add(listener)
+}
+
+// Removes a listener that nobody waits on anymore before it expires, e.g. the one of a request that gave up.
+// Removing a listener that was already notified does nothing.
+func (s MinerState) RemoveConfirmationListener(listener ConfirmationListener) {
+	s.confirmations.remove(listener)
+}
+
 func (s MinerState) IsDisconnected() bool {
 	return s.singleMinerDisconnected && len(*s.clients) == 0
 }
@@ -312,7 +425,15 @@ func NewMinerState(config Config, connectedMiningNodes []string) MinerState {
 		incomingAddr: config.IncomingMinersAddr,
 		listeners: list.New(),
 		listenersMux: new(sync.Mutex),
+		confirmations: newConfirmationIndex(),
+		headsMux: new(sync.Mutex),
+		confirmsPerFileCreate: config.ConfirmsPerFileCreate,
+		confirmsPerFileAppend: config.ConfirmsPerFileAppend,
 		singleMinerDisconnected: config.SingleMinerDisconnected,
+		appendFee: config.AppendFee,
+		createFee: config.CreateFee,
+		jobWatchers: make(map[string][]chan FailureType),
+		jobWatchersMux: new(sync.Mutex),
 	}
 	treePtr = NewTreeManager(config, ms, ms)
 
@@ -326,7 +447,8 @@ func NewMinerState(config Config, connectedMiningNodes []string) MinerState {
 		config.NoOpNumberOfZeros,
 		config.OpPerBlock,
 		time.Duration(config.GenOpBlockTimeout),
-		calcThresh)
+		calcThresh,
+		NewMempool(config.MaxPendingOps, config.PendingOpExpiryBlocks, config.PendingOpExpiry))
 
 	// add genesis block
 	err := (*ms.tm).AddBlock(crypto.BlockElement{
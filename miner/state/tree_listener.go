@@ -3,32 +3,54 @@ package state
 import (
 	. "../../shared"
 	"bytes"
+	"strings"
+	"sync"
 	"time"
 )
 
+// Gets the confirmed state of every new head of the longest chain until it returns true or expires.
+// The heads wait for their listeners, which must not block.
 type TreeListener interface {
-	TreeEventHandler() bool
+	TreeEventHandler(fs FilesystemState) bool
 	IsExpired() bool
 }
 
+// Waits for a change to be confirmed in the longest chain. The listeners are indexed by what they wait
+// for, see confirmationIndex, instead of each of them looking for it in the chain.
+type ConfirmationListener interface {
+	// what the listener waits for
+	key() confirmationKey
+	// notifies the listener and returns true if fs has the change it waits for
+	confirm(fs FilesystemState) bool
+	IsExpired() bool
+}
+
+// Notifies the record number of the append once it is confirmed. Appends with an op id are
+// matched by it, otherwise by the creator and data at the expected record number.
 type AppendConfirmationListener struct {
+	OpId string
 	Creator string
 	Filename string
 	RecordNumber uint16
 	Data [512]byte
-	MinerState MinerState
-	ConfirmsPerFileAppend int
-	ConfirmsPerFileCreate int
 	NotifyChannel chan int
 	ExpirationTime time.Time
 }
 
-func (acl AppendConfirmationListener) TreeEventHandler() bool {
-	fs, err := acl.MinerState.GetFilesystemState(
-		acl.ConfirmsPerFileCreate,
-		acl.ConfirmsPerFileAppend)
-	if err != nil {
-		lg.Println("AppendConfirmationListener, ", err)
+func (acl AppendConfirmationListener) key() confirmationKey {
+	if acl.OpId != "" {
+		return confirmationKey{kind: opConfirmation, opId: acl.OpId}
+	}
+	return confirmationKey{kind: recordConfirmation, fname: Filename(acl.Filename), recordNum: acl.RecordNumber}
+}
+
+func (acl AppendConfirmationListener) confirm(fs FilesystemState) bool {
+	if acl.OpId != "" {
+		op, confirmed, ok := fs.GetOp(acl.OpId)
+		if ok && confirmed {
+			acl.NotifyChannel <- int(op.RecordNumber)
+			return true
+		}
 		return false
 	}
 
@@ -45,7 +67,7 @@ func (acl AppendConfirmationListener) TreeEventHandler() bool {
 
 	startIndex := uint32(acl.RecordNumber) * 512
 	if bytes.Equal(acl.Data[:], file.Data[startIndex : startIndex + 512]) {
-		acl.NotifyChannel <- 1
+		acl.NotifyChannel <- int(acl.RecordNumber)
 		return true
 	}
 	return false
@@ -58,22 +80,15 @@ func (acl AppendConfirmationListener) IsExpired() bool {
 type CreateConfirmationListener struct {
 	Creator string
 	Filename string
-	MinerState MinerState
-	ConfirmsPerFileAppend int
-	ConfirmsPerFileCreate int
 	NotifyChannel chan int
 	ExpirationTime time.Time
 }
 
-func (ccl CreateConfirmationListener) TreeEventHandler() bool {
-	fs, err := ccl.MinerState.GetFilesystemState(
-		ccl.ConfirmsPerFileCreate,
-		ccl.ConfirmsPerFileAppend)
-	if err != nil {
-		lg.Println("CreateConfirmationListener, ", err)
-		return false
-	}
+func (ccl CreateConfirmationListener) key() confirmationKey {
+	return confirmationKey{kind: createConfirmation, fname: Filename(ccl.Filename)}
+}
 
+func (ccl CreateConfirmationListener) confirm(fs FilesystemState) bool {
 	file, ok := fs.GetFile(Filename(ccl.Filename))
 	if !ok {
 		return false
@@ -91,22 +106,15 @@ func (ccl CreateConfirmationListener) IsExpired() bool {
 
 type DeleteConfirmationListener struct {
 	Filename string
-	MinerState MinerState
-	ConfirmsPerFileAppend int
-	ConfirmsPerFileCreate int
 	NotifyChannel chan int
 	ExpirationTime time.Time
 }
 
-func (dcl DeleteConfirmationListener) TreeEventHandler() bool {
-	fs, err := dcl.MinerState.GetFilesystemState(
-		dcl.ConfirmsPerFileCreate,
-		dcl.ConfirmsPerFileAppend)
-	if err != nil {
-		lg.Println("DeleteConfirmationListener, ", err)
-		return false
-	}
+func (dcl DeleteConfirmationListener) key() confirmationKey {
+	return confirmationKey{kind: deleteConfirmation, fname: Filename(dcl.Filename)}
+}
 
+func (dcl DeleteConfirmationListener) confirm(fs FilesystemState) bool {
 	_, exists := fs.GetFile(Filename(dcl.Filename))
 	if !exists {
 		dcl.NotifyChannel <- 1
@@ -118,6 +126,213 @@ func (dcl DeleteConfirmationListener) IsExpired() bool {
 	return isPastTime(dcl.ExpirationTime)
 }
 
+// Notifies once the record of the file is confirmed, whoever appended it
+type RecordConfirmationListener struct {
+	Filename string
+	RecordNumber uint16
+	NotifyChannel chan int
+	ExpirationTime time.Time
+}
+
+func (rcl RecordConfirmationListener) key() confirmationKey {
+	return confirmationKey{kind: recordConfirmation, fname: Filename(rcl.Filename), recordNum: rcl.RecordNumber}
+}
+
+func (rcl RecordConfirmationListener) confirm(fs FilesystemState) bool {
+	file, ok := fs.GetFile(Filename(rcl.Filename))
+	if !ok || rcl.RecordNumber >= file.NumberOfRecords {
+		return false
+	}
+	rcl.NotifyChannel <- int(rcl.RecordNumber)
+	return true
+}
+
+func (rcl RecordConfirmationListener) IsExpired() bool {
+	return isPastTime(rcl.ExpirationTime)
+}
+
+// Notifies once the longest chain has another head than Head, for waits that the confirmed state of the
+// miner can't tell apart, like reads at another depth
+type HeadListener struct {
+	Head string
+	NotifyChannel chan int
+	ExpirationTime time.Time
+}
+
+func (hl HeadListener) key() confirmationKey {
+	return confirmationKey{kind: headConfirmation}
+}
+
+func (hl HeadListener) confirm(fs FilesystemState) bool {
+	if fs.GetHead() == hl.Head {
+		return false
+	}
+	hl.NotifyChannel <- 1
+	return true
+}
+
+func (hl HeadListener) IsExpired() bool {
+	return isPastTime(hl.ExpirationTime)
+}
+
+// Notifies once the op with the given op id is confirmed
+type OpConfirmationListener struct {
+	OpId string
+	NotifyChannel chan int
+	ExpirationTime time.Time
+}
+
+func (ocl OpConfirmationListener) key() confirmationKey {
+	return confirmationKey{kind: opConfirmation, opId: ocl.OpId}
+}
+
+func (ocl OpConfirmationListener) confirm(fs FilesystemState) bool {
+	if _, confirmed, ok := fs.GetOp(ocl.OpId); ok && confirmed {
+		ocl.NotifyChannel <- 1
+		return true
+	}
+	return false
+}
+
+func (ocl OpConfirmationListener) IsExpired() bool {
+	return isPastTime(ocl.ExpirationTime)
+}
+
+// Heads a watch can fall behind on before it is closed
+const watchQueueLength = 16
+
+// Streams the confirmed changes of a file, or of all files with a given prefix, until it is closed.
+// Each watch has its own goroutine that turns the states of the new heads into events, in the order of
+// the heads. A watch whose client doesn't keep up is closed, NotifyChannel is closed once the watch
+// stops. Copies of the listener share the files seen so far.
+type FileWatchListener struct {
+	Filename string
+	Prefix bool
+	NotifyChannel chan WatchEvent
+	watch *fileWatch
+}
+
+type fileWatch struct {
+	// number of records of every watched file the last time we looked, only the goroutine of the
+	// watch looks once it started
+	seen map[Filename]uint16
+	// states of the new heads the goroutine of the watch hasn't looked at yet
+	states chan FilesystemState
+	done chan bool
+	closeOnce *sync.Once
+}
+
+// Only changes after fs are streamed
+func NewFileWatchListener(
+	fname string,
+	prefix bool,
+	fs FilesystemState) FileWatchListener {
+	fwl := FileWatchListener{
+		Filename: fname,
+		Prefix: prefix,
+		NotifyChannel: make(chan WatchEvent, 100),
+		watch: &fileWatch{
+			seen: make(map[Filename]uint16),
+			states: make(chan FilesystemState, watchQueueLength),
+			done: make(chan bool),
+			closeOnce: new(sync.Once),
+		},
+	}
+	for fname, file := range fs.GetAll() {
+		if fwl.matches(fname) {
+			fwl.watch.seen[fname] = file.NumberOfRecords
+		}
+	}
+	go fwl.run()
+	return fwl
+}
+
+// Queues fs for the goroutine of the watch, the watch is closed instead if its client fell behind
+func (fwl FileWatchListener) TreeEventHandler(fs FilesystemState) bool {
+	if fwl.IsExpired() {
+		return true
+	}
+	select {
+	case fwl.watch.states <- fs:
+		return false
+	default:
+		lg.Printf("Closing the watch of [%s], its client fell behind", fwl.Filename)
+		fwl.Close()
+		return true
+	}
+}
+
+// Streams the events of the queued states until the watch is closed
+func (fwl FileWatchListener) run() {
+	defer close(fwl.NotifyChannel)
+	for {
+		select {
+		case fs := <-fwl.watch.states:
+			for _, event := range fwl.diff(fs.GetAll()) {
+				select {
+				case fwl.NotifyChannel <- event:
+				case <-fwl.watch.done:
+					return
+				}
+			}
+		case <-fwl.watch.done:
+			return
+		}
+	}
+}
+
+// Events that turn the files seen so far into the given ones, updates the files seen
+func (fwl FileWatchListener) diff(files map[Filename]*FileInfo) []WatchEvent {
+	events := make([]WatchEvent, 0)
+	for fname, numRecords := range fwl.watch.seen {
+		file, exists := files[fname]
+		// a file with less records than before was deleted and created again on another chain
+		if !exists || file.NumberOfRecords < numRecords {
+			events = append(events, WatchEvent{Type: FILE_DELETED, FileName: string(fname)})
+			delete(fwl.watch.seen, fname)
+		}
+	}
+	for fname, file := range files {
+		if !fwl.matches(fname) {
+			continue
+		}
+		numRecords, seen := fwl.watch.seen[fname]
+		if !seen {
+			events = append(events, WatchEvent{Type: FILE_CREATED, FileName: string(fname)})
+		}
+		for i := numRecords; i < file.NumberOfRecords; i++ {
+			event := WatchEvent{Type: RECORD_APPENDED, FileName: string(fname), RecordNum: i}
+			copy(event.Record[:], file.Data[uint32(i) * 512 : uint32(i + 1) * 512])
+			events = append(events, event)
+		}
+		fwl.watch.seen[fname] = file.NumberOfRecords
+	}
+	return events
+}
+
+func (fwl FileWatchListener) matches(fname Filename) bool {
+	if fwl.Prefix {
+		return strings.HasPrefix(string(fname), fwl.Filename)
+	}
+	return string(fname) == fwl.Filename
+}
+
+// Stops the watch and its goroutine, the listener gets removed on the next tree event
+func (fwl FileWatchListener) Close() {
+	fwl.watch.closeOnce.Do(func() {
+		close(fwl.watch.done)
+	})
+}
+
+func (fwl FileWatchListener) IsExpired() bool {
+	select {
+	case <-fwl.watch.done:
+		return true
+	default:
+		return false
+	}
+}
+
 // Helpers
 func isPastTime(expirationTime time.Time) bool {
 	return time.Now().After(expirationTime)
@@ -0,0 +1,146 @@
+package state
+
+import (
+	"../../crypto"
+	. "../../shared"
+	"container/list"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileWatchListener(t *testing.T) {
+	file := func(numRecords uint16) *FileInfo {
+		data := make([]byte, 0, int(numRecords) * 512)
+		for i := uint16(0); i < numRecords; i++ {
+			record := make([]byte, 512)
+			record[0] = byte(i)
+			data = append(data, record...)
+		}
+		return &FileInfo{Data: data, NumberOfRecords: numRecords}
+	}
+	fsState := func(files map[Filename]*FileInfo) FilesystemState {
+		return FilesystemState{fs: files}
+	}
+
+	t.Run("only streams changes after the watch starts", func(t *testing.T) {
+		fwl := NewFileWatchListener("a", false, fsState(map[Filename]*FileInfo{"a": file(1)}))
+		events := fwl.diff(map[Filename]*FileInfo{"a": file(2), "b": file(1)})
+		equals(t, 1, len(events))
+		equals(t, RECORD_APPENDED, events[0].Type)
+		equals(t, uint16(1), events[0].RecordNum)
+		equals(t, byte(1), events[0].Record[0])
+	})
+
+	t.Run("streams creates, appends and deletes of files with a prefix", func(t *testing.T) {
+		fwl := NewFileWatchListener("log-", true, fsState(map[Filename]*FileInfo{}))
+		events := fwl.diff(map[Filename]*FileInfo{"log-1": file(1), "other": file(1)})
+		equals(t, []WatchEvent{
+			{Type: FILE_CREATED, FileName: "log-1"},
+			{Type: RECORD_APPENDED, FileName: "log-1", RecordNum: 0, Record: [512]byte{}},
+		}, events)
+
+		events = fwl.diff(map[Filename]*FileInfo{"other": file(1)})
+		equals(t, []WatchEvent{{Type: FILE_DELETED, FileName: "log-1"}}, events)
+	})
+
+	t.Run("a file that lost records was deleted and created again", func(t *testing.T) {
+		fwl := NewFileWatchListener("a", false, fsState(map[Filename]*FileInfo{"a": file(2)}))
+		events := fwl.diff(map[Filename]*FileInfo{"a": file(1)})
+		equals(t, 3, len(events))
+		equals(t, FILE_DELETED, events[0].Type)
+		equals(t, FILE_CREATED, events[1].Type)
+		equals(t, RECORD_APPENDED, events[2].Type)
+	})
+
+	t.Run("streams the changes of the heads in order", func(t *testing.T) {
+		fwl := NewFileWatchListener("a", false, fsState(map[Filename]*FileInfo{}))
+		for i := uint16(1); i <= 3; i++ {
+			equals(t, false, fwl.TreeEventHandler(fsState(map[Filename]*FileInfo{"a": file(i)})))
+		}
+		equals(t, FILE_CREATED, (<-fwl.NotifyChannel).Type)
+		for i := uint16(0); i < 3; i++ {
+			event := <-fwl.NotifyChannel
+			equals(t, RECORD_APPENDED, event.Type)
+			equals(t, i, event.RecordNum)
+		}
+		fwl.Close()
+	})
+
+	t.Run("closes the watch once its client falls behind", func(t *testing.T) {
+		fwl := NewFileWatchListener("a", false, fsState(map[Filename]*FileInfo{}))
+		fell := false
+		for i := uint16(1); i < 1000 && !fell; i++ {
+			fell = fwl.TreeEventHandler(fsState(map[Filename]*FileInfo{"a": file(i % 100)}))
+		}
+		equals(t, true, fell)
+		equals(t, true, fwl.IsExpired())
+		for range fwl.NotifyChannel {
+		}
+	})
+
+	t.Run("stops once closed", func(t *testing.T) {
+		fwl := NewFileWatchListener("a", false, fsState(map[Filename]*FileInfo{}))
+		equals(t, false, fwl.IsExpired())
+		fwl.Close()
+		fwl.Close()
+		equals(t, true, fwl.IsExpired())
+	})
+}
+
+func TestHeadNotifications(t *testing.T) {
+	t.Run("drops the heads that arrive after a newer one", func(t *testing.T) {
+		treeDef := treeBuilderTest{
+			height: 1,
+			roots:  1,
+			addOrder: []int{
+				0, 100, 1, int(crypto.NoOpBlock), 0, 1, 0, 0, 0, 0,
+				100, 1, 1, int(crypto.RegularBlock), 1, 1, 0, 0, int(crypto.CreateFile), 0,
+				101, 1, 1, int(crypto.RegularBlock), 1, 1, 0, 0, int(crypto.AppendFile), 0,
+				102, 1, 1, int(crypto.RegularBlock), 1, 1, 0, 0, int(crypto.AppendFile), 1},
+		}
+		tm := NewTreeManager(Config{
+			AppendFee:     NUM_COINS_PER_FILE_APPEND,
+			CreateFee:     1,
+			OpReward:      1,
+			NoOpReward:    1,
+			OpNumberOfZeros: numberOfZeros,
+			NoOpNumberOfZeros: numberOfZeros,
+		}, fkNodeRetriv, fkNodeRetriv)
+		ok(t, buildTreeWithManager(treeDef, tm))
+		s := MinerState{
+			tm: &tm,
+			listeners: list.New(),
+			listenersMux: new(sync.Mutex),
+			confirmations: newConfirmationIndex(),
+			headsMux: new(sync.Mutex),
+		}
+		fwl := NewFileWatchListener("a", false, FilesystemState{fs: map[Filename]*FileInfo{}})
+		s.AddTreeListener(fwl)
+
+		head := tm.GetLongestChain()
+		s.notifyHead(head.Value.(crypto.BlockElement).Block)
+		s.notifyHead(head.Next().Value.(crypto.BlockElement).Block)
+		equals(t, FILE_CREATED, (<-fwl.NotifyChannel).Type)
+		for i := uint16(0); i < 2; i++ {
+			event := <-fwl.NotifyChannel
+			equals(t, RECORD_APPENDED, event.Type)
+			equals(t, i, event.RecordNum)
+		}
+		select {
+		case event := <- fwl.NotifyChannel:
+			t.Fatalf("got an event of a stale head: %v", event)
+		case <- time.After(100 * time.Millisecond):
+		}
+		fwl.Close()
+	})
+}
+
+func TestConfirmationListenerExpiry(t *testing.T) {
+	t.Run("expires once past its expiration time", func(t *testing.T) {
+		ccl := CreateConfirmationListener{ExpirationTime: time.Now().Add(-time.Second)}
+		equals(t, true, ccl.IsExpired())
+		ocl := OpConfirmationListener{ExpirationTime: time.Now().Add(time.Minute)}
+		equals(t, false, ocl.IsExpired())
+	})
+}
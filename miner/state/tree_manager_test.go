@@ -893,6 +893,388 @@ func TestValidAccountState(t *testing.T) {
 	})
 }
 
+// Tree of a single chain of 100 no-op blocks mined by account 1, the coins it earned pay for the ops
+// the validation tests make up
+func newValidationTree(t *testing.T) *TreeManager {
+	treeDef := treeBuilderTest{
+		height: 1,
+		roots:  1,
+		addOrder: []int{
+			0, 100, 1, int(crypto.NoOpBlock), 0, 1, 0, 0, 0, 0},
+	}
+	tree := NewTreeManager(Config{
+		AppendFee:     shared.NUM_COINS_PER_FILE_APPEND,
+		CreateFee:     1,
+		OpReward:      1,
+		NoOpReward:    1,
+		OpNumberOfZeros: numberOfZeros,
+		NoOpNumberOfZeros: numberOfZeros,
+		// miner 1 mines the blocks of the tree and validates the ops of the tests
+		MinerId:       strconv.Itoa(1),
+	}, fkNodeRetriv, fkNodeRetriv)
+	ok(t, buildTreeWithManager(treeDef, tree))
+	return tree
+}
+
+func TestTipValidation(t *testing.T) {
+	tree := newValidationTree(t)
+
+	t.Run("accepts op if creator can pay the base fee plus the tip", func(t *testing.T) {
+		ops, accErr, fsErr := tree.ValidateJobSet([]*crypto.BlockOp{{
+			Type:     crypto.CreateFile,
+			Filename: filenames[0],
+			Creator:  strconv.Itoa(1),
+			Tip:      99,
+		}})
+		ok(t, accErr)
+		ok(t, fsErr)
+		equals(t, 1, len(ops))
+	})
+
+	t.Run("rejects op if creator cannot pay the base fee plus the tip", func(t *testing.T) {
+		ops, accErr, _ := tree.ValidateJobSet([]*crypto.BlockOp{{
+			Type:     crypto.CreateFile,
+			Filename: filenames[0],
+			Creator:  strconv.Itoa(1),
+			Tip:      100,
+		}})
+		equals(t, 0, len(ops))
+		equals(t, shared.FailureType(shared.NOT_ENOUGH_MONEY), accErr.(BlockChainValidatorError).GetErrorCode())
+	})
+}
+
+func TestOpIdValidation(t *testing.T) {
+	tree := newValidationTree(t)
+
+	prev := [md5.Size]byte{}
+	copy(prev[:], tree.GetHighestRoot().Hash())
+	ee := crypto.BlockElement{
+		Block: &crypto.Block{
+			MinerId:   strconv.Itoa(1),
+			Type:      crypto.RegularBlock,
+			PrevBlock: prev,
+			Records: []*crypto.BlockOp{{
+				Type:     crypto.CreateFile,
+				Filename: filenames[0],
+				Creator:  strconv.Itoa(1),
+				OpId:     "op-1",
+			}},
+			Nonce: 12324,
+		},
+	}
+	ee.Block.FindNonce(numberOfZeros, numberOfZeros)
+	ok(t, tree.AddBlock(ee))
+
+	t.Run("ops in the chain can be found by id", func(t *testing.T) {
+		fsState, err := NewFilesystemState(0, 0, tree.GetLongestChain())
+		ok(t, err)
+		op, confirmed, found := fsState.GetOp("op-1")
+		equals(t, true, found)
+		equals(t, true, confirmed)
+		equals(t, filenames[0], op.Filename)
+		_, _, found = fsState.GetOp("op-2")
+		equals(t, false, found)
+	})
+
+	t.Run("ops in the chain can be found with their block", func(t *testing.T) {
+		fsState, err := NewFilesystemState(1, 1, tree.GetLongestChain())
+		ok(t, err)
+		block, depth, found := fsState.GetOpBlock("op-1")
+		equals(t, true, found)
+		equals(t, ee.Block.Id(), block)
+		equals(t, 0, depth)
+		_, confirmed, _ := fsState.GetOp("op-1")
+		equals(t, false, confirmed)
+		_, _, found = fsState.GetOpBlock("op-2")
+		equals(t, false, found)
+	})
+
+	t.Run("rejects op if its id is already in the chain", func(t *testing.T) {
+		ops, _, fsErr := tree.ValidateJobSet([]*crypto.BlockOp{{
+			Type:     crypto.CreateFile,
+			Filename: filenames[1],
+			Creator:  strconv.Itoa(1),
+			OpId:     "op-1",
+		}})
+		equals(t, 0, len(ops))
+		equals(t, shared.FailureType(shared.OP_DUPLICATE), fsErr.(BlockChainValidatorError).GetErrorCode())
+	})
+
+	t.Run("rejects ops with the same id in the same job set", func(t *testing.T) {
+		ops, _, fsErr := tree.ValidateJobSet([]*crypto.BlockOp{{
+			Type:     crypto.CreateFile,
+			Filename: filenames[1],
+			Creator:  strconv.Itoa(1),
+			OpId:     "op-2",
+		}, {
+			Type:     crypto.CreateFile,
+			Filename: filenames[2],
+			Creator:  strconv.Itoa(1),
+			OpId:     "op-2",
+		}})
+		equals(t, 1, len(ops))
+		equals(t, filenames[1], ops[0].Filename)
+		equals(t, shared.FailureType(shared.OP_DUPLICATE), fsErr.(BlockChainValidatorError).GetErrorCode())
+	})
+}
+
+func TestConfirmedBlock(t *testing.T) {
+	tree := newValidationTree(t)
+
+	parentId := tree.GetHighestRoot().Id()
+	prev := [md5.Size]byte{}
+	copy(prev[:], tree.GetHighestRoot().Hash())
+	ee := crypto.BlockElement{
+		Block: &crypto.Block{
+			MinerId:   strconv.Itoa(1),
+			Type:      crypto.NoOpBlock,
+			PrevBlock: prev,
+			Records:   []*crypto.BlockOp{},
+			Nonce:     12324,
+		},
+	}
+	ee.Block.FindNonce(numberOfZeros, numberOfZeros)
+	ok(t, tree.AddBlock(ee))
+
+	t.Run("is the newest block deep enough for both depths", func(t *testing.T) {
+		fsState, err := NewFilesystemState(0, 0, tree.GetLongestChain())
+		ok(t, err)
+		equals(t, ee.Block.Id(), fsState.GetConfirmedBlock())
+		fsState, err = NewFilesystemState(3, 1, tree.GetLongestChain())
+		ok(t, err)
+		equals(t, parentId, fsState.GetConfirmedBlock())
+	})
+}
+
+func TestTransactionValidation(t *testing.T) {
+	tree := newValidationTree(t)
+
+	transaction := func(ops ...*crypto.BlockOp) *crypto.BlockOp {
+		return &crypto.BlockOp{
+			Type:    crypto.Transaction,
+			Creator: strconv.Itoa(1),
+			OpId:    "tx",
+			Ops:     ops,
+		}
+	}
+	create := func(fname string) *crypto.BlockOp {
+		return &crypto.BlockOp{Type: crypto.CreateFile, Filename: fname, Creator: strconv.Itoa(1)}
+	}
+	appendRec := func(fname string, recordNumber uint16) *crypto.BlockOp {
+		return &crypto.BlockOp{Type: crypto.AppendFile, Filename: fname, Creator: strconv.Itoa(1),
+			RecordNumber: recordNumber, Data: datum[0]}
+	}
+
+	t.Run("accepts a transaction that creates and writes a file", func(t *testing.T) {
+		ops, accErr, fsErr := tree.ValidateJobSet([]*crypto.BlockOp{
+			transaction(create(filenames[0]), appendRec(filenames[0], 0), appendRec(filenames[0], 1))})
+		ok(t, accErr)
+		ok(t, fsErr)
+		equals(t, 1, len(ops))
+	})
+
+	t.Run("rejects the whole transaction if one of its ops is invalid", func(t *testing.T) {
+		ops, _, fsErr := tree.ValidateJobSet([]*crypto.BlockOp{
+			transaction(create(filenames[0]), appendRec(filenames[1], 0))})
+		equals(t, 0, len(ops))
+		equals(t, shared.FailureType(shared.FILE_DOES_NOT_EXIST), fsErr.(BlockChainValidatorError).GetErrorCode())
+	})
+
+	t.Run("ops after a rejected transaction don't see its changes", func(t *testing.T) {
+		ops, _, _ := tree.ValidateJobSet([]*crypto.BlockOp{
+			transaction(create(filenames[0]), appendRec(filenames[1], 0)),
+			appendRec(filenames[0], 0)})
+		equals(t, 0, len(ops))
+	})
+
+	t.Run("rejects malformed transactions", func(t *testing.T) {
+		other := create(filenames[1])
+		other.Creator = strconv.Itoa(2)
+		for _, tx := range []*crypto.BlockOp{
+			transaction(),
+			transaction(create(filenames[0]), transaction(create(filenames[1]))),
+			transaction(create(filenames[0]), other),
+		} {
+			ops, _, fsErr := tree.ValidateJobSet([]*crypto.BlockOp{tx})
+			equals(t, 0, len(ops))
+			equals(t, shared.FailureType(shared.TRANSACTION_INVALID), fsErr.(BlockChainValidatorError).GetErrorCode())
+		}
+	})
+
+	t.Run("rejects the transaction if the creator cannot pay for all of its ops", func(t *testing.T) {
+		tx := transaction(create(filenames[0]), appendRec(filenames[0], 0))
+		tx.Tip = 99
+		ops, accErr, _ := tree.ValidateJobSet([]*crypto.BlockOp{tx})
+		equals(t, 0, len(ops))
+		equals(t, shared.FailureType(shared.NOT_ENOUGH_MONEY), accErr.(BlockChainValidatorError).GetErrorCode())
+	})
+
+	t.Run("applies all of the ops of a mined transaction", func(t *testing.T) {
+		prev := [md5.Size]byte{}
+		copy(prev[:], tree.GetHighestRoot().Hash())
+		tx := transaction(create(filenames[0]), appendRec(filenames[0], 0), appendRec(filenames[0], 1))
+		tx.Tip = 2
+		// miners can only tip themselves
+		ee := crypto.BlockElement{
+			Block: &crypto.Block{
+				MinerId:   strconv.Itoa(1),
+				Type:      crypto.RegularBlock,
+				PrevBlock: prev,
+				Records:   []*crypto.BlockOp{tx},
+				Nonce:     12324,
+			},
+		}
+		ee.Block.FindNonce(numberOfZeros, numberOfZeros)
+		ok(t, tree.AddBlock(ee))
+
+		fsState, err := NewFilesystemState(0, 0, tree.GetLongestChain())
+		ok(t, err)
+		equals(t, uint16(2), fsState.GetAll()[shared.Filename(filenames[0])].NumberOfRecords)
+		_, confirmed, found := fsState.GetOp("tx")
+		equals(t, true, found)
+		equals(t, true, confirmed)
+
+		bkState, err := NewAccountsState(shared.NUM_COINS_PER_FILE_APPEND, 1, 1, 1, tree.GetLongestChain())
+		ok(t, err)
+		// the create, both appends and the tip, then the block reward and the tip back
+		equals(t, Balance(100 - 5 + 1 + 2), bkState.GetAccountBalance(Account(strconv.Itoa(1))))
+	})
+}
+
+func TestClientAccountValidation(t *testing.T) {
+	tree := newValidationTree(t)
+
+	key, err := crypto.GenerateKey()
+	ok(t, err)
+	client := crypto.AccountId(&key.PublicKey)
+	signed := func(op *crypto.BlockOp) *crypto.BlockOp {
+		ok(t, op.Sign(key))
+		return op
+	}
+	transfer := &crypto.BlockOp{
+		Type:      crypto.Transfer,
+		Creator:   strconv.Itoa(1),
+		Recipient: client,
+		Amount:    10,
+		OpId:      "transfer",
+	}
+	addBlock := func(minerId string, ops ...*crypto.BlockOp) {
+		prev := [md5.Size]byte{}
+		copy(prev[:], tree.GetHighestRoot().Hash())
+		ee := crypto.BlockElement{
+			Block: &crypto.Block{
+				MinerId:   minerId,
+				Type:      crypto.RegularBlock,
+				PrevBlock: prev,
+				Records:   ops,
+				Nonce:     12324,
+			},
+		}
+		ee.Block.FindNonce(numberOfZeros, numberOfZeros)
+		ok(t, tree.AddBlock(ee))
+	}
+
+	t.Run("rejects ops of a client that it didn't sign", func(t *testing.T) {
+		op := signed(&crypto.BlockOp{Type: crypto.CreateFile, Filename: filenames[0], OpId: "create"})
+		op.Filename = filenames[1]
+		ops, accErr, _ := tree.ValidateJobSet([]*crypto.BlockOp{op})
+		equals(t, 0, len(ops))
+		equals(t, shared.FailureType(shared.BAD_SIGNATURE), accErr.(BlockChainValidatorError).GetErrorCode())
+	})
+
+	t.Run("rejects ops of a client without coins", func(t *testing.T) {
+		ops, accErr, _ := tree.ValidateJobSet([]*crypto.BlockOp{
+			signed(&crypto.BlockOp{Type: crypto.CreateFile, Filename: filenames[0], OpId: "create"})})
+		equals(t, 0, len(ops))
+		equals(t, shared.FailureType(shared.NOT_ENOUGH_MONEY), accErr.(BlockChainValidatorError).GetErrorCode())
+	})
+
+	t.Run("rejects transfers without a recipient or an amount", func(t *testing.T) {
+		ops, _, fsErr := tree.ValidateJobSet([]*crypto.BlockOp{
+			{Type: crypto.Transfer, Creator: strconv.Itoa(1), Amount: 10},
+			{Type: crypto.Transfer, Creator: strconv.Itoa(1), Recipient: client}})
+		equals(t, 0, len(ops))
+		equals(t, true, fsErr != nil)
+	})
+
+	t.Run("rejects transfers in transactions", func(t *testing.T) {
+		ops, _, fsErr := tree.ValidateJobSet([]*crypto.BlockOp{{
+			Type:    crypto.Transaction,
+			Creator: strconv.Itoa(1),
+			OpId:    "tx",
+			Ops:     []*crypto.BlockOp{{Type: crypto.Transfer, Creator: strconv.Itoa(1), Recipient: client, Amount: 1}},
+		}})
+		equals(t, 0, len(ops))
+		equals(t, shared.FailureType(shared.TRANSACTION_INVALID), fsErr.(BlockChainValidatorError).GetErrorCode())
+	})
+
+	t.Run("lets a client pay for its ops with the coins transferred to it", func(t *testing.T) {
+		create := signed(&crypto.BlockOp{Type: crypto.CreateFile, Filename: filenames[0], OpId: "create", Tip: 2})
+		ops, accErr, fsErr := tree.ValidateJobSet([]*crypto.BlockOp{transfer, create})
+		ok(t, accErr)
+		ok(t, fsErr)
+		equals(t, 2, len(ops))
+
+		addBlock(strconv.Itoa(1), transfer)
+		addBlock(strconv.Itoa(2), create)
+		bkState, err := NewAccountsState(shared.NUM_COINS_PER_FILE_APPEND, 1, 1, 1, tree.GetLongestChain())
+		ok(t, err)
+		// the transfer, then the block reward
+		equals(t, Balance(100 - 10 + 1), bkState.GetAccountBalance(Account(strconv.Itoa(1))))
+		// the create and its tip
+		equals(t, Balance(10 - 3), bkState.GetAccountBalance(Account(client)))
+		// block reward plus the tip
+		equals(t, Balance(3), bkState.GetAccountBalance(Account(strconv.Itoa(2))))
+
+		fsState, err := NewFilesystemState(0, 0, tree.GetLongestChain())
+		ok(t, err)
+		equals(t, client, fsState.GetAll()[shared.Filename(filenames[0])].Creator)
+	})
+}
+
+func TestMinerAccountValidation(t *testing.T) {
+	tree := newValidationTree(t)
+	mined := func(minerId string, op *crypto.BlockOp) error {
+		prev := [md5.Size]byte{}
+		copy(prev[:], tree.GetHighestRoot().Hash())
+		ee := crypto.BlockElement{
+			Block: &crypto.Block{
+				MinerId:   minerId,
+				Type:      crypto.RegularBlock,
+				PrevBlock: prev,
+				Records:   []*crypto.BlockOp{op},
+				Nonce:     12324,
+			},
+		}
+		ee.Block.FindNonce(numberOfZeros, numberOfZeros)
+		return tree.AddBlock(ee)
+	}
+
+	t.Run("rejects the transfers and tips of a miner in the blocks of another miner", func(t *testing.T) {
+		transfer := &crypto.BlockOp{Type: crypto.Transfer, Creator: strconv.Itoa(1), Recipient: strconv.Itoa(2),
+			Amount: 10, OpId: "transfer"}
+		equals(t, true, mined(strconv.Itoa(2), transfer) != nil)
+		tipped := &crypto.BlockOp{Type: crypto.CreateFile, Creator: strconv.Itoa(1), Filename: filenames[0],
+			Tip: 10, OpId: "tipped"}
+		equals(t, true, mined(strconv.Itoa(2), tipped) != nil)
+
+		ops, accErr, _ := tree.ValidateJobSet([]*crypto.BlockOp{{Type: crypto.Transfer, Creator: strconv.Itoa(2),
+			Recipient: strconv.Itoa(1), Amount: 1, OpId: "other"}})
+		equals(t, 0, len(ops))
+		equals(t, shared.FailureType(shared.BAD_SIGNATURE), accErr.(BlockChainValidatorError).GetErrorCode())
+	})
+
+	t.Run("lets a miner mine its own transfers and the file ops of other miners", func(t *testing.T) {
+		ok(t, mined(strconv.Itoa(1), &crypto.BlockOp{Type: crypto.Transfer, Creator: strconv.Itoa(1),
+			Recipient: strconv.Itoa(2), Amount: 10, OpId: "transfer"}))
+		ok(t, mined(strconv.Itoa(1), &crypto.BlockOp{Type: crypto.CreateFile, Creator: strconv.Itoa(2),
+			Filename: filenames[0], OpId: "create"}))
+		bkState, err := NewAccountsState(shared.NUM_COINS_PER_FILE_APPEND, 1, 1, 1, tree.GetLongestChain())
+		ok(t, err)
+		equals(t, Balance(10 - 1), bkState.GetAccountBalance(Account(strconv.Itoa(2))))
+	})
+}
+
 type tNodeRetriever struct {
 	counterRB *int
 	counterRR *int
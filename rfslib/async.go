@@ -0,0 +1,115 @@
+package rfslib
+
+import (
+	"../shared"
+	"time"
+)
+
+// Identifies an operation submitted with CreateFileAsync,
+// AppendRecAsync or DeleteFileAsync
+type Ticket string
+
+type OpState int
+
+const (
+	// Waiting in the mempool of the miner
+	OpPending OpState = iota
+	// In a block of the longest chain, but not confirmed yet
+	OpMined
+	OpConfirmed
+	OpFailed
+)
+
+// Status of an operation submitted asynchronously. Block and Depth tell
+// where the operation was mined, Depth being the number of blocks on
+// top of Block. RecordNum is the position of a confirmed append and Err
+// tells why a failed operation failed, it is one of the errors the
+// blocking call would have returned.
+type OpStatus struct {
+	State     OpState
+	Block     string
+	Depth     int
+	RecordNum uint16
+	Err       error
+}
+
+func (rfs RFSInstance) CreateFileAsync(fname string) (ticket Ticket, err error) {
+	return rfs.submit(shared.RFSClientRequest{RequestType: shared.CREATE_FILE, FileName: fname, OpId: newOpKey()})
+}
+
+func (rfs RFSInstance) AppendRecAsync(fname string, record *Record) (ticket Ticket, err error) {
+	return rfs.submit(shared.RFSClientRequest{
+		RequestType:  shared.APPEND_REC,
+		FileName:     fname,
+		AppendRecord: *record,
+		OpId:         newOpKey()})
+}
+
+func (rfs RFSInstance) DeleteFileAsync(fname string) (ticket Ticket, err error) {
+	return rfs.submit(shared.RFSClientRequest{RequestType: shared.DELETE_FILE, FileName: fname, OpId: newOpKey()})
+}
+
+func (rfs RFSInstance) submit(clientRequest shared.RFSClientRequest) (ticket Ticket, err error) {
+	// Encode and send the client request, the miner answers once it has the op
+	clientRequest.Async = true
+
+	// Wait for response from miner, other requests can be sent meanwhile
+	minerResponse, err := rfs.write(clientRequest)
+	if err != nil {
+		return "", err
+	}
+
+	// Generate the proper error to return to the client
+	responseErr := rfs.generateResponseError(clientRequest, minerResponse)
+
+	lg.Printf("Miner responded to async request")
+	return Ticket(minerResponse.OpId), responseErr
+}
+
+func (rfs RFSInstance) TicketStatus(ticket Ticket) (status OpStatus, err error) {
+	return rfs.opStatus(ticket, shared.NO_WAIT, 0)
+}
+
+func (rfs RFSInstance) WaitTicket(ticket Ticket, timeout time.Duration) (status OpStatus, err error) {
+	if timeout == 0 {
+		return rfs.opStatus(ticket, shared.WAIT_FOREVER, 0)
+	}
+	return rfs.opStatus(ticket, shared.WAIT_TIMEOUT, timeout)
+}
+
+func (rfs RFSInstance) opStatus(ticket Ticket, mode shared.WaitMode, timeout time.Duration) (status OpStatus, err error) {
+	// Encode and send the client request
+	clientRequest := shared.RFSClientRequest{
+		RequestType: shared.OP_STATUS,
+		OpId:        string(ticket),
+		WaitMode:    mode,
+		WaitTimeout: timeout}
+
+	// Wait for response from miner, other requests can be sent meanwhile
+	minerResponse, err := rfs.conn.request(rfs.context(), clientRequest)
+	if err != nil {
+		return OpStatus{}, err
+	}
+
+	// Generate the proper error to return to the client
+	responseErr := rfs.generateResponseError(clientRequest, minerResponse)
+	if responseErr != nil {
+		return OpStatus{}, responseErr
+	}
+
+	opStatus := minerResponse.OpStatus
+	status = OpStatus{
+		State:     OpState(opStatus.State),
+		Block:     opStatus.Block,
+		Depth:     opStatus.Depth,
+		RecordNum: opStatus.RecordNum}
+	if opStatus.State == shared.OP_FAILED {
+		// the reason is turned into the error the op would have failed with
+		status.Err = rfs.generateResponseError(
+			shared.RFSClientRequest{FileName: opStatus.FileName},
+			shared.RFSMinerResponse{ErrorType: opStatus.Reason, Balance: opStatus.Balance, Needed: opStatus.Needed})
+	}
+
+	lg.Printf("Miner responded to op status request")
+	return status, nil
+}
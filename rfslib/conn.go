@@ -0,0 +1,333 @@
+package rfslib
+
+import (
+	"../fdlib"
+	"../shared"
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// How long telling the miner to cancel a request can take
+const cancelTimeout = 10 * time.Second
+
+// Connection to the miner shared by every goroutine using the RFS. Each
+// request gets an id and responses, which can come back in any order, are
+// handed to the request with the same id.
+type minerConn struct {
+	tcpConn   *net.TCPConn
+	minerAddr string
+	// serializes the frames written to tcpConn
+	writeMux *sync.Mutex
+	// guards nextId and pending
+	mux     *sync.Mutex
+	nextId  uint64
+	pending map[uint64]chan shared.RFSMinerResponse
+	// closed once the connection to the miner is lost, every request fails after that
+	lost      chan bool
+	closeOnce *sync.Once
+}
+
+func newMinerConn(tcpConn *net.TCPConn, minerAddr string, failures <-chan fdlib.FailureDetected) *minerConn {
+	mc := &minerConn{
+		tcpConn:   tcpConn,
+		minerAddr: minerAddr,
+		writeMux:  new(sync.Mutex),
+		mux:       new(sync.Mutex),
+		pending:   make(map[uint64]chan shared.RFSMinerResponse),
+		lost:      make(chan bool),
+		closeOnce: new(sync.Once),
+	}
+	go mc.readResponses()
+	go func() {
+		for {
+			select {
+			case failure := <-failures:
+				if failure.UDPIpPort != minerAddr {
+					// a miner the session failed over from
+					continue
+				}
+				// Miner node failed
+				lg.Println("Miner failed, closing the connection")
+				mc.close()
+				return
+			case <-mc.lost:
+				return
+			}
+		}
+	}()
+	return mc
+}
+
+// Sends the request and waits for its response, other goroutines can send
+// requests in the meantime. If ctx is done first the miner is told to
+// cancel the request and ctx's error is returned.
+func (mc *minerConn) request(ctx context.Context, clientRequest shared.RFSClientRequest) (shared.RFSMinerResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return shared.RFSMinerResponse{}, err
+	}
+
+	responses := make(chan shared.RFSMinerResponse, 1)
+	mc.mux.Lock()
+	mc.nextId++
+	clientRequest.RequestId = mc.nextId
+	mc.pending[clientRequest.RequestId] = responses
+	mc.mux.Unlock()
+	defer func() {
+		mc.mux.Lock()
+		delete(mc.pending, clientRequest.RequestId)
+		mc.mux.Unlock()
+	}()
+
+	frame, err := shared.EncodeFrame(clientRequest)
+	if err != nil {
+		return shared.RFSMinerResponse{}, err
+	}
+
+	// Send to miner
+	lg.Println("Sending client request to miner")
+	err = mc.write(ctx, frame)
+	if err != nil {
+		return shared.RFSMinerResponse{}, err
+	}
+
+	select {
+	case minerResponse := <-responses:
+		return minerResponse, nil
+	case <-mc.lost:
+		return shared.RFSMinerResponse{}, DisconnectedError(mc.minerAddr)
+	case <-ctx.Done():
+		// the miner stops waiting for the request, its response is dropped once it arrives
+		if err := mc.cancel(clientRequest.RequestId); err != nil {
+			lg.Println(err)
+		}
+		return shared.RFSMinerResponse{}, ctx.Err()
+	}
+}
+
+// Tells the miner to give up on a request, no response is expected
+func (mc *minerConn) cancel(requestId uint64) error {
+	frame, err := shared.EncodeFrame(shared.RFSClientRequest{RequestType: shared.CANCEL, CancelId: requestId})
+	if err != nil {
+		return err
+	}
+	lg.Printf("Cancelling client request [%d]", requestId)
+	ctx, cancel := context.WithTimeout(context.Background(), cancelTimeout)
+	defer cancel()
+	return mc.write(ctx, frame)
+}
+
+// Writes a whole frame to the miner by ctx's deadline, the write is interrupted as soon as ctx is done.
+// The connection is closed if the write fails, the miner could be left with part of a frame.
+func (mc *minerConn) write(ctx context.Context, frame []byte) error {
+	mc.writeMux.Lock()
+	// no deadline if ctx has none
+	deadline, _ := ctx.Deadline()
+	mc.tcpConn.SetWriteDeadline(deadline)
+	written := make(chan bool)
+	interrupted := make(chan bool)
+	go func() {
+		defer close(interrupted)
+		select {
+		case <-ctx.Done():
+			// makes the pending write fail right away
+			mc.tcpConn.SetWriteDeadline(time.Now())
+		case <-written:
+		}
+	}()
+	_, err := mc.tcpConn.Write(frame)
+	close(written)
+	<-interrupted
+	mc.writeMux.Unlock()
+	if err != nil {
+		lg.Println(err)
+		mc.close()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return DisconnectedError(mc.minerAddr)
+	}
+	return nil
+}
+
+// Hands every response read from the miner to the request waiting for it
+func (mc *minerConn) readResponses() {
+	frames := shared.NewFrameReader(mc.tcpConn)
+	for {
+		minerResponse := shared.RFSMinerResponse{}
+		err := frames.Read(&minerResponse)
+		if err != nil {
+			// A malformed or undecodable response leaves the connection out of sync with the miner
+			lg.Println(err)
+			mc.close()
+			return
+		}
+
+		mc.mux.Lock()
+		responses, ok := mc.pending[minerResponse.RequestId]
+		mc.mux.Unlock()
+		if !ok {
+			lg.Printf("Dropping response to unknown request [%d]", minerResponse.RequestId)
+			continue
+		}
+		// every request gets a single response, which fits in the channel
+		responses <- minerResponse
+	}
+}
+
+// Closes the connection and fails the requests waiting for a response
+func (mc *minerConn) close() (err error) {
+	mc.closeOnce.Do(func() {
+		close(mc.lost)
+		err = mc.tcpConn.Close()
+	})
+	return
+}
+
+// Connection to one of the miners of a session. When the miner fails the
+// session connects to the next miner that accepts the connection and the
+// requests that were waiting for a response are sent again. Writes carry
+// op ids, so the miners don't apply twice the ones the failed miner got
+// through.
+type failoverConn struct {
+	localAddr  string
+	minerAddrs []string
+	fd         fdlib.FD
+	failures   <-chan fdlib.FailureDetected
+	// guards current and closed
+	mux     *sync.Mutex
+	current *minerConn
+	// index of the miner of current in minerAddrs
+	currentIdx int
+	closed     bool
+}
+
+// Connects to the first miner of minerAddrs that accepts the connection
+func newFailoverConn(localAddr string, minerAddrs []string, fd fdlib.FD,
+	failures <-chan fdlib.FailureDetected) (*failoverConn, error) {
+	fc := &failoverConn{
+		localAddr:  localAddr,
+		minerAddrs: minerAddrs,
+		fd:         fd,
+		failures:   failures,
+		mux:        new(sync.Mutex),
+		currentIdx: len(minerAddrs) - 1,
+	}
+	err := fc.connectNext()
+	if err != nil {
+		return nil, err
+	}
+	return fc, nil
+}
+
+// Sends the request to the current miner, and again to the next miners if
+// they fail before responding. Fails with DisconnectedError once no miner
+// accepts the connection.
+func (fc *failoverConn) request(ctx context.Context, clientRequest shared.RFSClientRequest) (shared.RFSMinerResponse, error) {
+	for attempt := 0; ; attempt++ {
+		mc, err := fc.connection()
+		if err != nil {
+			return shared.RFSMinerResponse{}, err
+		}
+		minerResponse, err := mc.request(ctx, clientRequest)
+		if _, lost := err.(DisconnectedError); !lost || attempt == len(fc.minerAddrs) {
+			return minerResponse, err
+		}
+
+		lg.Printf("Lost miner [%s] before it responded, failing over", mc.minerAddr)
+		err = fc.failover(mc)
+		if err != nil {
+			return shared.RFSMinerResponse{}, err
+		}
+	}
+}
+
+// Address of the miner the session is connected to
+func (fc *failoverConn) minerAddr() string {
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+	return fc.current.minerAddr
+}
+
+func (fc *failoverConn) connection() (*minerConn, error) {
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+	if fc.closed {
+		return nil, DisconnectedError(fc.current.minerAddr)
+	}
+	return fc.current, nil
+}
+
+// Replaces the connection to a failed miner, does nothing if another request
+// already did
+func (fc *failoverConn) failover(failed *minerConn) error {
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+	if fc.closed {
+		return DisconnectedError(failed.minerAddr)
+	}
+	if fc.current != failed {
+		return nil
+	}
+	failed.close()
+	fc.fd.RemoveMonitor(failed.minerAddr)
+	err := fc.connectNext()
+	if err != nil {
+		lg.Println(err)
+		return DisconnectedError(failed.minerAddr)
+	}
+	return nil
+}
+
+// Connects to the miners that follow the current one, the current one last
+func (fc *failoverConn) connectNext() (err error) {
+	for i := 1; i <= len(fc.minerAddrs); i++ {
+		idx := (fc.currentIdx + i) % len(fc.minerAddrs)
+		minerAddr := fc.minerAddrs[idx]
+		tcpConn, dialErr := dialMiner(fc.localAddr, minerAddr)
+		if dialErr != nil {
+			lg.Println(dialErr)
+			err = dialErr
+			continue
+		}
+		fc.fd.AddMonitor(fc.localAddr, minerAddr, 10)
+		fc.current = newMinerConn(tcpConn, minerAddr, fc.failures)
+		fc.currentIdx = idx
+		return nil
+	}
+	return err
+}
+
+func (fc *failoverConn) close() error {
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+	fc.closed = true
+	fc.fd.RemoveMonitor(fc.current.minerAddr)
+	return fc.current.close()
+}
+
+// Opens a connection to the miner from localAddr, or from any local port if
+// localAddr is taken
+func dialMiner(localAddr string, minerAddr string) (*net.TCPConn, error) {
+	// Resolve TCP addresses
+	laddr, err := net.ResolveTCPAddr("tcp", localAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	maddr, err := net.ResolveTCPAddr("tcp", minerAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTCP("tcp", laddr, maddr)
+	if err != nil {
+		laddr, err := net.ResolveTCPAddr("tcp", ":0")
+		if err != nil {
+			return nil, err
+		}
+		return net.DialTCP("tcp", laddr, maddr)
+	}
+	return conn, nil
+}
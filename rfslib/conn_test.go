@@ -0,0 +1,164 @@
+package rfslib
+
+import (
+	"../fdlib"
+	"../shared"
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// Serves client connections on a random port, a failing miner drops the connection as soon as it
+// gets a request while a healthy one answers every request with its own address
+func fakeMiner(t *testing.T, healthy bool) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	ok(t, err)
+	addr := listener.Addr().String()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				frames := shared.NewFrameReader(conn)
+				for {
+					request := shared.RFSClientRequest{}
+					if frames.Read(&request) != nil || !healthy {
+						return
+					}
+					shared.WriteFrame(conn, shared.RFSMinerResponse{
+						RequestId: request.RequestId,
+						ErrorType: shared.NO_ERROR,
+						FileNames: []string{addr}})
+				}
+			}()
+		}
+	}()
+	return addr
+}
+
+// Connects to a miner that never reads what it is sent
+func silentMiner(t *testing.T) *minerConn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	ok(t, err)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+	tcpConn, err := net.DialTCP("tcp", nil, listener.Addr().(*net.TCPAddr))
+	ok(t, err)
+	return newMinerConn(tcpConn, listener.Addr().String(), nil)
+}
+
+func TestFailoverConn(t *testing.T) {
+	t.Run("should send a request again to the next miner if the first one fails", func(t *testing.T) {
+		failing := fakeMiner(t, false)
+		healthy := fakeMiner(t, true)
+		fd, failures, err := fdlib.NewFDLib(1, 5)
+		ok(t, err)
+		fc, err := newFailoverConn("127.0.0.1:0", []string{failing, healthy}, fd, failures)
+		ok(t, err)
+		equals(t, failing, fc.minerAddr())
+
+		response, err := fc.request(context.Background(), shared.RFSClientRequest{RequestType: shared.LIST_FILES})
+		ok(t, err)
+		equals(t, []string{healthy}, response.FileNames)
+		equals(t, healthy, fc.minerAddr())
+		ok(t, fc.close())
+	})
+
+	t.Run("should skip miners that refuse the connection", func(t *testing.T) {
+		healthy := fakeMiner(t, true)
+		fd, failures, err := fdlib.NewFDLib(2, 5)
+		ok(t, err)
+		fc, err := newFailoverConn("127.0.0.1:0", []string{"127.0.0.1:1", healthy}, fd, failures)
+		ok(t, err)
+		equals(t, healthy, fc.minerAddr())
+		ok(t, fc.close())
+	})
+
+	t.Run("should fail with DisconnectedError once every miner failed", func(t *testing.T) {
+		fd, failures, err := fdlib.NewFDLib(3, 5)
+		ok(t, err)
+		fc, err := newFailoverConn("127.0.0.1:0", []string{fakeMiner(t, false), fakeMiner(t, false)}, fd, failures)
+		ok(t, err)
+
+		_, err = fc.request(context.Background(), shared.RFSClientRequest{RequestType: shared.LIST_FILES})
+		_, isDisconnected := err.(DisconnectedError)
+		assert(t, isDisconnected, "should fail because no miner responds")
+		fc.close()
+	})
+}
+
+func TestMinerConn(t *testing.T) {
+	t.Run("should interrupt a write once its context is cancelled", func(t *testing.T) {
+		mc := silentMiner(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(100*time.Millisecond, cancel)
+		start := time.Now()
+		err := mc.write(ctx, make([]byte, 64<<20))
+		equals(t, context.Canceled, err)
+		assert(t, time.Since(start) < time.Second, "should not wait for the write to finish")
+	})
+
+	t.Run("should give up on a write at the deadline of its context", func(t *testing.T) {
+		mc := silentMiner(t)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		err := mc.write(ctx, make([]byte, 64<<20))
+		equals(t, context.DeadlineExceeded, err)
+	})
+
+	t.Run("should fail requests too big for a frame without sending them", func(t *testing.T) {
+		fd, failures, err := fdlib.NewFDLib(4, 5)
+		ok(t, err)
+		fc, err := newFailoverConn("127.0.0.1:0", []string{fakeMiner(t, true)}, fd, failures)
+		ok(t, err)
+
+		records := make([][512]byte, shared.MAX_MESSAGE_SIZE/512+1)
+		_, err = fc.request(context.Background(),
+			shared.RFSClientRequest{RequestType: shared.APPEND_RECS, AppendRecords: records})
+		_, isMalformed := err.(shared.MalformedFrameError)
+		assert(t, isMalformed, "should fail to encode the request")
+		ok(t, fc.close())
+	})
+}
+
+func assert(tb testing.TB, condition bool, msg string, v ...interface{}) {
+	if !condition {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: "+msg+"\033[39m\n\n", append([]interface{}{filepath.Base(file), line}, v...)...)
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: unexpected error: %s\033[39m\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
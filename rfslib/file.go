@@ -0,0 +1,252 @@
+package rfslib
+
+import (
+	"../shared"
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+)
+
+// Handle on an RFS file that reads and writes bytes instead of records.
+// The file is its records one after the other, so reads map byte offsets
+// onto records. Writes always append to the end of the file: they are
+// buffered and appended as whole records once enough of them are written,
+// or on Flush and Close, which pad the last record with zero bytes.
+// Buffered bytes can't be read until they are appended.
+//
+// The file ends at the last byte of its last record that isn't zero, so
+// reads don't return the padding of the last record, nor any zero bytes
+// written at the very end of the file. A Flush in the middle of the data
+// leaves its padding inside the file, where it is read back.
+type File struct {
+	rfs   RFS
+	fname string
+	// guards the fields below, a file can be used by several goroutines
+	mux    *sync.Mutex
+	offset int64
+	// bytes written but not appended yet, less than a batch of records
+	pending []byte
+	closed  bool
+}
+
+var ErrFileClosed = errors.New("rfslib: file already closed")
+
+// Opens the existing file fname of the instance returned by Initialize.
+//
+// Can return the following errors:
+// - DisconnectedError
+// - FileDoesNotExistError
+func Open(fname string) (file *File, err error) {
+	if rfsInstance == nil {
+		return nil, DisconnectedError("")
+	}
+	return rfsInstance.Open(fname)
+}
+
+func (rfs RFSInstance) Open(fname string) (file *File, err error) {
+	return openFile(rfs, fname)
+}
+
+func openFile(rfs RFS, fname string) (*File, error) {
+	// only existing files can be opened
+	_, err := rfs.TotalRecs(fname)
+	if err != nil {
+		return nil, err
+	}
+	return &File{rfs: rfs, fname: fname, mux: new(sync.Mutex)}, nil
+}
+
+func (f *File) Name() string {
+	return f.fname
+}
+
+// Reads from the current offset and moves it past the bytes read
+func (f *File) Read(p []byte) (n int, err error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.closed {
+		return 0, ErrFileClosed
+	}
+	n, err = f.readAt(p, f.offset)
+	f.offset += int64(n)
+	if n > 0 && err == io.EOF {
+		// the next read reports the end of the file
+		err = nil
+	}
+	return n, err
+}
+
+// Reads len(p) bytes at offset off, fewer only at the end of the file
+func (f *File) ReadAt(p []byte, off int64) (n int, err error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.closed {
+		return 0, ErrFileClosed
+	}
+	return f.readAt(p, off)
+}
+
+func (f *File) readAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("rfslib: negative offset")
+	}
+	size, err := f.size()
+	if err != nil {
+		return 0, err
+	}
+	if off >= size {
+		return 0, io.EOF
+	}
+	if off+int64(len(p)) > size {
+		p = p[:size-off]
+		// the file ends before p is full
+		defer func() {
+			if err == nil {
+				err = io.EOF
+			}
+		}()
+	}
+
+	const recordSize = int64(len(Record{}))
+	for n < len(p) {
+		first := (off + int64(n)) / recordSize
+		if first >= int64(shared.MAX_RECORD_COUNT) {
+			return n, io.EOF
+		}
+		count := (off+int64(len(p))-1)/recordSize - first + 1
+		if first+count > int64(shared.MAX_RECORD_COUNT) {
+			count = int64(shared.MAX_RECORD_COUNT) - first
+		}
+
+		records, err := f.rfs.ReadRecs(f.fname, uint16(first), uint16(count))
+		if err != nil {
+			return n, err
+		}
+		for i, record := range records {
+			start := int64(0)
+			if i == 0 {
+				start = (off + int64(n)) % recordSize
+			}
+			n += copy(p[n:], record[start:])
+		}
+		// the file ends before p is full
+		if int64(len(records)) < count {
+			return n, io.EOF
+		}
+	}
+	return n, nil
+}
+
+// Number of bytes of the file, the zero bytes at the end of its last record are padding
+func (f *File) size() (int64, error) {
+	numRecs, err := f.rfs.TotalRecs(f.fname)
+	if err != nil || numRecs == 0 {
+		return 0, err
+	}
+	last, err := f.rfs.ReadRecs(f.fname, numRecs-1, 1)
+	if err != nil {
+		return 0, err
+	}
+	size := int64(numRecs-1) * int64(len(Record{}))
+	if len(last) > 0 {
+		size += int64(len(bytes.TrimRight(last[0][:], "\x00")))
+	}
+	return size, nil
+}
+
+// Moves the offset of the next Read, whence is one of io.SeekStart,
+// io.SeekCurrent and io.SeekEnd
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.closed {
+		return 0, ErrFileClosed
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		size, err := f.size()
+		if err != nil {
+			return 0, err
+		}
+		offset += size
+	default:
+		return 0, errors.New("rfslib: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("rfslib: negative offset")
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Appends p to the end of the file, whatever the offset is. Whole records
+// are appended once there are enough of them for a batch. On error the
+// buffered bytes are dropped, some of them might have been appended.
+func (f *File) Write(p []byte) (n int, err error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.closed {
+		return 0, ErrFileClosed
+	}
+
+	f.pending = append(f.pending, p...)
+	batch := shared.MAX_RECS_PER_APPEND_REQUEST * len(Record{})
+	for len(f.pending) >= batch {
+		err = f.appendRecords(batch)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Appends the buffered bytes, the last record is padded with zero bytes
+// that reads leave out as long as nothing is written after them.
+// Can return the same errors as AppendRecs
+func (f *File) Flush() error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.closed {
+		return ErrFileClosed
+	}
+	return f.flush()
+}
+
+func (f *File) flush() error {
+	if len(f.pending) == 0 {
+		return nil
+	}
+	recordSize := len(Record{})
+	return f.appendRecords((len(f.pending) + recordSize - 1) / recordSize * recordSize)
+}
+
+// Appends the first size bytes of pending as records
+func (f *File) appendRecords(size int) error {
+	records := make([]Record, (size+len(Record{})-1)/len(Record{}))
+	for i := range records {
+		copy(records[i][:], f.pending[i*len(Record{}):])
+	}
+	if size > len(f.pending) {
+		size = len(f.pending)
+	}
+	f.pending = f.pending[size:]
+
+	_, err := f.rfs.AppendRecs(f.fname, records)
+	return err
+}
+
+// Flushes the file, the handle can't be used after that
+func (f *File) Close() error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.closed {
+		return ErrFileClosed
+	}
+	f.closed = true
+	return f.flush()
+}
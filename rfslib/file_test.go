@@ -0,0 +1,145 @@
+package rfslib
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Keeps the records of a single file in memory, only the calls used by File are implemented
+type memRFS struct {
+	RFS
+	mux     *sync.Mutex
+	records *[]Record
+	appends *int
+}
+
+func newMemRFS() memRFS {
+	return memRFS{mux: new(sync.Mutex), records: new([]Record), appends: new(int)}
+}
+
+func (m memRFS) TotalRecs(fname string) (uint16, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return uint16(len(*m.records)), nil
+}
+
+func (m memRFS) ReadRecs(fname string, start uint16, count uint16) ([]Record, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	records := make([]Record, 0)
+	for i := int(start); i < int(start)+int(count) && i < len(*m.records); i++ {
+		records = append(records, (*m.records)[i])
+	}
+	return records, nil
+}
+
+func (m memRFS) AppendRecs(fname string, records []Record) ([]uint16, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	*m.appends++
+	recordNums := make([]uint16, len(records))
+	for i, record := range records {
+		recordNums[i] = uint16(len(*m.records))
+		*m.records = append(*m.records, record)
+	}
+	return recordNums, nil
+}
+
+func TestFile(t *testing.T) {
+	t.Run("should read back what was copied into the file", func(t *testing.T) {
+		m := newMemRFS()
+		f, err := openFile(m, "f")
+		ok(t, err)
+		contents := strings.Repeat("records of 512 bytes! ", 100)
+		_, err = io.Copy(f, strings.NewReader(contents))
+		ok(t, err)
+		equals(t, 0, len(*m.records))
+		ok(t, f.Flush())
+		equals(t, (len(contents)+511)/512, len(*m.records))
+
+		read, err := ioutil.ReadAll(bufio.NewReader(f))
+		ok(t, err)
+		// the padding of the last record isn't read back
+		equals(t, contents, string(read))
+		size, err := f.Seek(0, io.SeekEnd)
+		ok(t, err)
+		equals(t, int64(len(contents)), size)
+	})
+
+	t.Run("should append whole batches of records while writing", func(t *testing.T) {
+		m := newMemRFS()
+		f, err := openFile(m, "f")
+		ok(t, err)
+		n, err := f.Write(make([]byte, 64*512+10))
+		ok(t, err)
+		equals(t, 64*512+10, n)
+		equals(t, 64, len(*m.records))
+		ok(t, f.Close())
+		equals(t, 65, len(*m.records))
+		equals(t, 2, *m.appends)
+
+		_, err = f.Write([]byte{1})
+		equals(t, ErrFileClosed, err)
+	})
+
+	t.Run("should read at any offset across records", func(t *testing.T) {
+		m := newMemRFS()
+		f, err := openFile(m, "f")
+		ok(t, err)
+		contents := make([]byte, 3*512)
+		for i := range contents {
+			contents[i] = byte(i % 251)
+		}
+		_, err = f.Write(contents)
+		ok(t, err)
+		ok(t, f.Flush())
+
+		p := make([]byte, 600)
+		n, err := f.ReadAt(p, 500)
+		ok(t, err)
+		equals(t, 600, n)
+		equals(t, contents[500:1100], p)
+
+		n, err = f.ReadAt(p, 1000)
+		equals(t, io.EOF, err)
+		equals(t, 3*512-1000, n)
+		equals(t, contents[1000:], p[:n])
+
+		offset, err := f.Seek(-10, io.SeekEnd)
+		ok(t, err)
+		equals(t, int64(3*512-10), offset)
+		n, err = f.Read(p)
+		ok(t, err)
+		equals(t, 10, n)
+		_, err = f.Read(p)
+		equals(t, io.EOF, err)
+
+		_, err = f.Seek(-1, io.SeekStart)
+		assert(t, err != nil, "should fail because the offset is negative")
+	})
+
+	t.Run("should encode and decode json", func(t *testing.T) {
+		m := newMemRFS()
+		f, err := openFile(m, "f")
+		ok(t, err)
+		ok(t, json.NewEncoder(f).Encode(map[string]int{"records": 512}))
+		ok(t, f.Flush())
+
+		var decoded map[string]int
+		ok(t, json.NewDecoder(f).Decode(&decoded))
+		equals(t, map[string]int{"records": 512}, decoded)
+
+		_, err = f.Seek(0, io.SeekStart)
+		ok(t, err)
+		encoded, err := ioutil.ReadAll(f)
+		ok(t, err)
+		decoded = nil
+		ok(t, json.Unmarshal(encoded, &decoded))
+		equals(t, map[string]int{"records": 512}, decoded)
+	})
+}
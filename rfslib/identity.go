@@ -0,0 +1,167 @@
+package rfslib
+
+import (
+	"../crypto"
+	"../shared"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// Generates the key of a new client account, keep it with SaveKey
+func GenerateKey() (*ecdsa.PrivateKey, error) {
+	return crypto.GenerateKey()
+}
+
+// Account of the client holding key, what Transfer sends coins to
+func AccountId(key *ecdsa.PrivateKey) string {
+	return crypto.AccountId(&key.PublicKey)
+}
+
+// Writes key to the file fname as PEM, only readable by its owner
+func SaveKey(fname string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fname, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+}
+
+// Reads a key written by SaveKey
+func LoadKey(fname string) (*ecdsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "EC PRIVATE KEY" {
+		return nil, errors.New("no EC private key in " + fname)
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+func (rfs RFSInstance) Transfer(recipient string, amount uint32) (err error) {
+	if recipient == "" || amount == 0 {
+		return InvalidTransferError(recipient)
+	}
+
+	// Encode and send the client request, the key makes resending it safe
+	clientRequest := shared.RFSClientRequest{
+		RequestType: shared.TRANSFER,
+		Recipient:   recipient,
+		Amount:      amount,
+		OpId:        newOpKey()}
+
+	// Wait for response from miner, other requests can be sent meanwhile
+	minerResponse, err := rfs.write(clientRequest)
+	if err != nil {
+		return err
+	}
+
+	// Generate the proper error to return to the client
+	responseErr := rfs.generateResponseError(clientRequest, minerResponse)
+
+	lg.Printf("Miner responded to transfer request")
+	return responseErr
+}
+
+func (rfs RFSInstance) Balance() (balance int, err error) {
+	// Encode and send the client request, the miner answers for its own account if there is no identity
+	clientRequest := shared.RFSClientRequest{RequestType: shared.GET_BALANCE}
+	if rfs.identity != nil {
+		clientRequest.Creator = AccountId(rfs.identity)
+	}
+
+	// Wait for response from miner, other requests can be sent meanwhile
+	minerResponse, err := rfs.conn.request(rfs.context(), clientRequest)
+	if err != nil {
+		return 0, err
+	}
+
+	// Generate the proper error to return to the client
+	responseErr := rfs.generateResponseError(clientRequest, minerResponse)
+
+	lg.Printf("Miner responded to balance request")
+	return minerResponse.Balance, responseErr
+}
+
+// Signs the ops the miner creates for the request, see RFSClientRequest.Signatures
+func signRequest(key *ecdsa.PrivateKey, clientRequest *shared.RFSClientRequest) error {
+	account := AccountId(key)
+	ops, err := requestOps(account, *clientRequest)
+	if err != nil {
+		return err
+	}
+	clientRequest.Creator = account
+	clientRequest.Signatures = make([][]byte, len(ops))
+	for i, op := range ops {
+		err := op.Sign(key)
+		if err != nil {
+			return err
+		}
+		clientRequest.Signatures[i] = op.Signature
+	}
+	return nil
+}
+
+// Ops the miner creates for a write request, without the record numbers the miner gives to appends
+func requestOps(account string, clientRequest shared.RFSClientRequest) ([]*crypto.BlockOp, error) {
+	op := &crypto.BlockOp{
+		Filename: clientRequest.FileName,
+		Tip:      clientRequest.Tip,
+		OpId:     clientRequest.OpId}
+	switch clientRequest.RequestType {
+	case shared.CREATE_FILE:
+		op.Type = crypto.CreateFile
+	case shared.DELETE_FILE:
+		op.Type = crypto.DeleteFile
+	case shared.APPEND_REC, shared.APPEND_REC_AT:
+		op.Type = crypto.AppendFile
+		op.Data = clientRequest.AppendRecord
+	case shared.APPEND_RECS:
+		ops := make([]*crypto.BlockOp, len(clientRequest.AppendRecords))
+		for i, record := range clientRequest.AppendRecords {
+			ops[i] = &crypto.BlockOp{
+				Type:     crypto.AppendFile,
+				Filename: clientRequest.FileName,
+				Data:     record,
+				Tip:      clientRequest.Tip,
+				OpId:     fmt.Sprintf("%s-%d", clientRequest.OpId, i)}
+		}
+		return ops, nil
+	case shared.TRANSACTION:
+		op = &crypto.BlockOp{
+			Type: crypto.Transaction,
+			Tip:  clientRequest.Tip,
+			OpId: clientRequest.OpId,
+			Ops:  make([]*crypto.BlockOp, len(clientRequest.TransactionOps))}
+		for i, txOp := range clientRequest.TransactionOps {
+			sub := &crypto.BlockOp{Creator: account, Filename: txOp.FileName}
+			switch txOp.RequestType {
+			case shared.CREATE_FILE:
+				sub.Type = crypto.CreateFile
+			case shared.APPEND_REC:
+				sub.Type = crypto.AppendFile
+				sub.Data = txOp.AppendRecord
+			case shared.DELETE_FILE:
+				sub.Type = crypto.DeleteFile
+			default:
+				return nil, InvalidTransactionError("it has an unknown operation")
+			}
+			op.Ops[i] = sub
+		}
+	case shared.TRANSFER:
+		op = &crypto.BlockOp{
+			Type:      crypto.Transfer,
+			Recipient: clientRequest.Recipient,
+			Amount:    clientRequest.Amount,
+			Tip:       clientRequest.Tip,
+			OpId:      clientRequest.OpId}
+	default:
+		return nil, errors.New("not a write request")
+	}
+	return []*crypto.BlockOp{op}, nil
+}
@@ -0,0 +1,96 @@
+package rfslib
+
+import (
+	"../crypto"
+	"../shared"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSignRequest(t *testing.T) {
+	key, err := GenerateKey()
+	ok(t, err)
+	account := AccountId(key)
+
+	// Signs the request and hands its signatures to the ops the miner would create for it
+	signed := func(t *testing.T, clientRequest shared.RFSClientRequest, ops ...*crypto.BlockOp) {
+		ok(t, signRequest(key, &clientRequest))
+		equals(t, account, clientRequest.Creator)
+		equals(t, len(ops), len(clientRequest.Signatures))
+		for i, op := range ops {
+			op.Creator = clientRequest.Creator
+			op.Signature = clientRequest.Signatures[i]
+		}
+	}
+
+	t.Run("should sign the op of a single append whatever its record number", func(t *testing.T) {
+		op := &crypto.BlockOp{Type: crypto.AppendFile, Filename: "f", Data: [512]byte{1}, Tip: 3, OpId: "op", RecordNumber: 5}
+		signed(t, shared.RFSClientRequest{
+			RequestType:  shared.APPEND_REC,
+			FileName:     "f",
+			AppendRecord: [512]byte{1},
+			Tip:          3,
+			OpId:         "op"}, op)
+		assert(t, op.SignatureValid(), "signature of the append should be valid")
+		op.Filename = "g"
+		assert(t, !op.SignatureValid(), "signature of another file should be invalid")
+	})
+
+	t.Run("should sign every record of an append of several records", func(t *testing.T) {
+		ops := []*crypto.BlockOp{
+			{Type: crypto.AppendFile, Filename: "f", Data: [512]byte{1}, OpId: "op-0"},
+			{Type: crypto.AppendFile, Filename: "f", Data: [512]byte{2}, OpId: "op-1"}}
+		signed(t, shared.RFSClientRequest{
+			RequestType:   shared.APPEND_RECS,
+			FileName:      "f",
+			AppendRecords: [][512]byte{{1}, {2}},
+			OpId:          "op"}, ops...)
+		for _, op := range ops {
+			assert(t, op.SignatureValid(), "signature of append [%s] should be valid", op.OpId)
+		}
+	})
+
+	t.Run("should sign a transaction with its ops", func(t *testing.T) {
+		op := &crypto.BlockOp{Type: crypto.Transaction, OpId: "tx", Ops: []*crypto.BlockOp{
+			{Type: crypto.CreateFile, Creator: account, Filename: "f"},
+			{Type: crypto.AppendFile, Creator: account, Filename: "f", Data: [512]byte{1}, RecordNumber: 0}}}
+		signed(t, shared.RFSClientRequest{
+			RequestType: shared.TRANSACTION,
+			FileName:    "f",
+			OpId:        "tx",
+			TransactionOps: []shared.TransactionOp{
+				{RequestType: shared.CREATE_FILE, FileName: "f"},
+				{RequestType: shared.APPEND_REC, FileName: "f", AppendRecord: [512]byte{1}}}}, op)
+		assert(t, op.SignatureValid(), "signature of the transaction should be valid")
+	})
+
+	t.Run("should sign transfers", func(t *testing.T) {
+		op := &crypto.BlockOp{Type: crypto.Transfer, Recipient: "miner", Amount: 4, OpId: "op"}
+		signed(t, shared.RFSClientRequest{
+			RequestType: shared.TRANSFER,
+			Recipient:   "miner",
+			Amount:      4,
+			OpId:        "op"}, op)
+		assert(t, op.SignatureValid(), "signature of the transfer should be valid")
+		op.Amount = 40
+		assert(t, !op.SignatureValid(), "signature of another amount should be invalid")
+	})
+
+	t.Run("should not sign reads", func(t *testing.T) {
+		clientRequest := shared.RFSClientRequest{RequestType: shared.READ_REC, FileName: "f", OpId: "op"}
+		assert(t, signRequest(key, &clientRequest) != nil, "should fail because reads aren't signed")
+	})
+
+	t.Run("should load the key it saved", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "rfslib")
+		ok(t, err)
+		defer os.RemoveAll(dir)
+		fname := filepath.Join(dir, "key.pem")
+		ok(t, SaveKey(fname, key))
+		loaded, err := LoadKey(fname)
+		ok(t, err)
+		equals(t, account, AccountId(loaded))
+	})
+}
@@ -0,0 +1,201 @@
+package rfslib
+
+import (
+	"../shared"
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Number of times a quorum read is sent again when the miners don't agree,
+// they usually only disagree for as long as a new block takes to reach all
+// of them
+const QUORUM_READ_ATTEMPTS = 3
+
+// How long a quorum read waits before it is sent again, twice as long after
+// each attempt
+const quorumRetryBackoff = 250 * time.Millisecond
+
+// Connections of a quorum session to each of its miners, reads are sent to
+// all of them
+type quorumReads struct {
+	minerAddrs []string
+	quorum     int
+	// guards conns and closed
+	mux    *sync.Mutex
+	conns  map[string]*minerConn
+	closed bool
+}
+
+// Answer of a single miner to a quorum read
+type quorumAnswer struct {
+	minerAddr string
+	response  shared.RFSMinerResponse
+	err       error
+}
+
+func newQuorumReads(minerAddrs []string, quorum int) *quorumReads {
+	return &quorumReads{
+		minerAddrs: minerAddrs,
+		quorum:     quorum,
+		mux:        new(sync.Mutex),
+		conns:      make(map[string]*minerConn),
+	}
+}
+
+// Sends the read to every miner and returns the response at least quorum of
+// them gave, fails with QuorumError if not enough of them agree
+func (qr *quorumReads) request(ctx context.Context, clientRequest shared.RFSClientRequest) (
+	minerResponse shared.RFSMinerResponse, err error) {
+	backoff := quorumRetryBackoff
+	for attempt := 0; attempt < QUORUM_READ_ATTEMPTS; attempt++ {
+		if attempt > 0 {
+			// give the block the miners disagree about time to reach all of them
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return shared.RFSMinerResponse{}, ctx.Err()
+			}
+			backoff *= 2
+		}
+		minerResponse, err = qr.requestOnce(ctx, clientRequest)
+		if _, noQuorum := err.(QuorumError); !noQuorum {
+			return minerResponse, err
+		}
+		lg.Println(err)
+	}
+	return shared.RFSMinerResponse{}, err
+}
+
+func (qr *quorumReads) requestOnce(ctx context.Context, clientRequest shared.RFSClientRequest) (
+	shared.RFSMinerResponse, error) {
+	answers := make(chan quorumAnswer, len(qr.minerAddrs))
+	for _, minerAddr := range qr.minerAddrs {
+		go func(minerAddr string) {
+			response, err := qr.requestMiner(ctx, minerAddr, clientRequest)
+			answers <- quorumAnswer{minerAddr: minerAddr, response: response, err: err}
+		}(minerAddr)
+	}
+
+	// Group the miners by their response, every miner picks its own request id
+	groups := make([][]quorumAnswer, 0)
+	unreachable := make([]string, 0)
+	for range qr.minerAddrs {
+		answer := <-answers
+		if answer.err != nil {
+			if ctx.Err() != nil {
+				return shared.RFSMinerResponse{}, ctx.Err()
+			}
+			unreachable = append(unreachable, answer.minerAddr)
+			continue
+		}
+		answer.response.RequestId = 0
+
+		idx := len(groups)
+		for i, group := range groups {
+			if reflect.DeepEqual(group[0].response, answer.response) {
+				idx = i
+				break
+			}
+		}
+		if idx == len(groups) {
+			groups = append(groups, nil)
+		}
+		groups[idx] = append(groups[idx], answer)
+		if len(groups[idx]) >= qr.quorum {
+			return answer.response, nil
+		}
+	}
+
+	// Not enough miners agreed, the ones outside the largest group disagreed. If several groups are
+	// the largest none of them is the answer of the miners, all of them disagreed.
+	agreed, largest := 0, 0
+	for _, group := range groups {
+		if len(group) > agreed {
+			agreed, largest = len(group), 0
+		}
+		if len(group) == agreed {
+			largest++
+		}
+	}
+	disagreed := make([]string, 0)
+	for _, group := range groups {
+		if len(group) == agreed && largest == 1 {
+			continue
+		}
+		for _, answer := range group {
+			disagreed = append(disagreed, answer.minerAddr)
+		}
+	}
+	sort.Strings(disagreed)
+	sort.Strings(unreachable)
+	return shared.RFSMinerResponse{}, QuorumError{
+		Agreed:      agreed,
+		Quorum:      qr.quorum,
+		Disagreed:   disagreed,
+		Unreachable: unreachable}
+}
+
+func (qr *quorumReads) requestMiner(ctx context.Context, minerAddr string, clientRequest shared.RFSClientRequest) (
+	shared.RFSMinerResponse, error) {
+	mc, err := qr.connection(minerAddr)
+	if err != nil {
+		return shared.RFSMinerResponse{}, err
+	}
+	minerResponse, err := mc.request(ctx, clientRequest)
+	if _, lost := err.(DisconnectedError); lost {
+		// connect again on the next read
+		qr.mux.Lock()
+		if qr.conns[minerAddr] == mc {
+			delete(qr.conns, minerAddr)
+		}
+		qr.mux.Unlock()
+	}
+	return minerResponse, err
+}
+
+// Connection to the miner, it is opened on the first read that needs it
+func (qr *quorumReads) connection(minerAddr string) (*minerConn, error) {
+	qr.mux.Lock()
+	mc, ok := qr.conns[minerAddr]
+	closed := qr.closed
+	qr.mux.Unlock()
+	if closed {
+		return nil, DisconnectedError(minerAddr)
+	}
+	if ok {
+		return mc, nil
+	}
+
+	// don't hold back the reads to the other miners while dialing
+	tcpConn, err := dialMiner(":0", minerAddr)
+	if err != nil {
+		lg.Println(err)
+		return nil, DisconnectedError(minerAddr)
+	}
+	mc = newMinerConn(tcpConn, minerAddr, nil)
+
+	qr.mux.Lock()
+	defer qr.mux.Unlock()
+	if other, ok := qr.conns[minerAddr]; ok || qr.closed {
+		mc.close()
+		if qr.closed {
+			return nil, DisconnectedError(minerAddr)
+		}
+		return other, nil
+	}
+	qr.conns[minerAddr] = mc
+	return mc, nil
+}
+
+func (qr *quorumReads) close() {
+	qr.mux.Lock()
+	defer qr.mux.Unlock()
+	qr.closed = true
+	for minerAddr, mc := range qr.conns {
+		mc.close()
+		delete(qr.conns, minerAddr)
+	}
+}
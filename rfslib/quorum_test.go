@@ -0,0 +1,109 @@
+package rfslib
+
+import (
+	"../shared"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// Serves client connections on a random port, answering every request with fileNames at block
+func answeringMiner(t *testing.T, fileNames []string, block string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	ok(t, err)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				frames := shared.NewFrameReader(conn)
+				for {
+					request := shared.RFSClientRequest{}
+					if frames.Read(&request) != nil {
+						return
+					}
+					shared.WriteFrame(conn, shared.RFSMinerResponse{
+						RequestId: request.RequestId,
+						ErrorType: shared.NO_ERROR,
+						FileNames: fileNames,
+						ReadBlock: block})
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestQuorumReads(t *testing.T) {
+	listFiles := shared.RFSClientRequest{RequestType: shared.LIST_FILES}
+
+	t.Run("should return the response a quorum of miners agree on", func(t *testing.T) {
+		honest := []string{"a", "b"}
+		qr := newQuorumReads([]string{
+			answeringMiner(t, honest, "block"),
+			answeringMiner(t, []string{"fake"}, "block"),
+			answeringMiner(t, honest, "block")}, 2)
+		defer qr.close()
+
+		response, err := qr.request(context.Background(), listFiles)
+		ok(t, err)
+		equals(t, honest, response.FileNames)
+		equals(t, "block", response.ReadBlock)
+	})
+
+	t.Run("should name the miners that disagreed when there is no quorum", func(t *testing.T) {
+		honest := []string{"a", "b"}
+		first := answeringMiner(t, honest, "block")
+		fake := answeringMiner(t, []string{"fake"}, "block")
+		otherBlock := answeringMiner(t, honest, "other block")
+		qr := newQuorumReads([]string{first, fake, otherBlock, "127.0.0.1:1"}, 2)
+		defer qr.close()
+
+		_, err := qr.request(context.Background(), listFiles)
+		quorumErr, isQuorumErr := err.(QuorumError)
+		assert(t, isQuorumErr, "should fail with QuorumError, got %v", err)
+		equals(t, 1, quorumErr.Agreed)
+		equals(t, 2, quorumErr.Quorum)
+		// the three groups are tied, none of them is the answer of the miners
+		equals(t, 3, len(quorumErr.Disagreed))
+		equals(t, []string{"127.0.0.1:1"}, quorumErr.Unreachable)
+	})
+
+	t.Run("should only name the miners outside the largest group", func(t *testing.T) {
+		honest := []string{"a", "b"}
+		qr := newQuorumReads([]string{
+			answeringMiner(t, honest, "block"),
+			answeringMiner(t, honest, "block"),
+			answeringMiner(t, []string{"fake"}, "block"),
+			answeringMiner(t, []string{"other"}, "block")}, 3)
+		defer qr.close()
+
+		_, err := qr.requestOnce(context.Background(), listFiles)
+		quorumErr, isQuorumErr := err.(QuorumError)
+		assert(t, isQuorumErr, "should fail with QuorumError, got %v", err)
+		equals(t, 2, quorumErr.Agreed)
+		equals(t, 2, len(quorumErr.Disagreed))
+	})
+
+	t.Run("should wait before sending the read again", func(t *testing.T) {
+		qr := newQuorumReads([]string{
+			answeringMiner(t, []string{"a"}, "block"),
+			answeringMiner(t, []string{"b"}, "block")}, 2)
+		defer qr.close()
+
+		start := time.Now()
+		_, err := qr.request(context.Background(), listFiles)
+		_, isQuorumErr := err.(QuorumError)
+		assert(t, isQuorumErr, "should fail with QuorumError, got %v", err)
+		assert(t, time.Since(start) >= 3*quorumRetryBackoff, "should back off between attempts")
+
+		ctx, cancel := context.WithTimeout(context.Background(), quorumRetryBackoff/2)
+		defer cancel()
+		_, err = qr.request(ctx, listFiles)
+		equals(t, context.DeadlineExceeded, err)
+	})
+}
@@ -13,19 +13,58 @@ package rfslib
 import (
 	"../fdlib"
 	"../shared"
-	"bytes"
-	"encoding/gob"
+	"context"
+	"crypto/ecdsa"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"math/rand"
 	"net"
+	"sync"
 	"time"
 )
 
 // A Record is the unit of file access (reading/appending) in RFS.
 type Record [512]byte
 
+// Confirmation depth a read was answered at, the number of blocks on top
+// of the creates and appends it sees
+type ReadDepth struct {
+	Create int
+	Append int
+}
+
+// How long a read waits for a record that hasn't been appended yet, or
+// a write for the coins it needs, see WithFundsWait
+type WaitMode int
+
+const (
+	// Fail right away
+	NoWait WaitMode = iota
+	// Wait up to a timeout
+	WaitTimeout
+	// Wait for as long as it takes
+	WaitForever
+)
+
+type WatchEventType int
+
+const (
+	FileCreated WatchEventType = iota
+	FileDeleted
+	RecordAppended
+)
+
+// A confirmed change of a watched file. RecordNum and Record are only
+// set for RecordAppended events.
+type WatchEvent struct {
+	Type      WatchEventType
+	Filename  string
+	RecordNum uint16
+	Record    Record
+}
+
 ////////////////////////////////////////////////////////////////////////////////////////////
 // <ERROR DEFINITIONS>
 
@@ -73,6 +112,143 @@ func (e FileMaxLenReachedError) Error() string {
 	return fmt.Sprintf("RFS: File [%s] has reached its maximum length", string(e))
 }
 
+// Contains filename. The miner dropped the operation because its
+// mempool was full of operations paying higher fees.
+type OperationEvictedError string
+
+func (e OperationEvictedError) Error() string {
+	return fmt.Sprintf("RFS: Operation on file [%s] was evicted from the miner's full mempool", string(e))
+}
+
+// Contains filename. The operation waited too long in the miner's
+// mempool without being mined.
+type OperationExpiredError string
+
+func (e OperationExpiredError) Error() string {
+	return fmt.Sprintf("RFS: Operation on file [%s] expired before being mined", string(e))
+}
+
+// Contains filename. The miner stopped waiting for the operation to be
+// confirmed, it may still be. Retrying it with the same key is safe.
+type ConfirmationTimeoutError string
+
+func (e ConfirmationTimeoutError) Error() string {
+	return fmt.Sprintf("RFS: Operation on file [%s] wasn't confirmed in time, it may still be", string(e))
+}
+
+// Contains filename. The operation failed validation for a reason that
+// none of the other errors describe, retrying it won't help.
+type OperationRejectedError string
+
+func (e OperationRejectedError) Error() string {
+	return fmt.Sprintf("RFS: Operation on file [%s] was rejected by the miner", string(e))
+}
+
+// Contains the reason. The transaction is empty, too big or was
+// rejected as a whole by the miner.
+type InvalidTransactionError string
+
+func (e InvalidTransactionError) Error() string {
+	return fmt.Sprintf("RFS: Invalid transaction: %s", string(e))
+}
+
+// Contains filename. The file didn't have the number of records a
+// conditional append expected, usually because another append took
+// the record number first.
+type RecordConflictError string
+
+func (e RecordConflictError) Error() string {
+	return fmt.Sprintf("RFS: Another append got the expected record number of file [%s] first", string(e))
+}
+
+// Contains filename. The record to read hasn't been appended to the
+// file, or wasn't before the read stopped waiting for it.
+type RecordDoesNotExistError string
+
+func (e RecordDoesNotExistError) Error() string {
+	return fmt.Sprintf("RFS: Record of file [%s] does not exist", string(e))
+}
+
+// Contains the depth. A read asked for a confirmation depth out of
+// the bounds the miner accepts.
+type InvalidReadDepthError int
+
+func (e InvalidReadDepthError) Error() string {
+	return fmt.Sprintf("RFS: Confirmation depth [%d] is out of bounds", int(e))
+}
+
+// Contains the ticket. The miner doesn't know about the ticket, either
+// it was submitted to another miner or it finished a long time ago.
+type UnknownTicketError string
+
+func (e UnknownTicketError) Error() string {
+	return fmt.Sprintf("RFS: Unknown ticket [%s]", string(e))
+}
+
+// Contains the op id. The miner didn't accept the signature of the
+// operation, or only relays the operations of clients with an identity.
+type BadSignatureError string
+
+func (e BadSignatureError) Error() string {
+	return fmt.Sprintf("RFS: Operation [%s] isn't signed by a client account the miner accepts", string(e))
+}
+
+// Contains the recipient. A transfer needs a recipient and at least one
+// coin to transfer.
+type InvalidTransferError string
+
+func (e InvalidTransferError) Error() string {
+	return fmt.Sprintf("RFS: Invalid transfer to [%s]", string(e))
+}
+
+// The account paying for an operation doesn't have the coins it needs,
+// and the call didn't wait for it to get them, see WithFundsWait.
+type InsufficientFundsError struct {
+	Balance int
+	Needed  int
+}
+
+func (e InsufficientFundsError) Error() string {
+	return fmt.Sprintf("RFS: The account has %d coins but the operation needs %d", e.Balance, e.Needed)
+}
+
+// Contains the miner address. The miner failed to work out its own
+// state to answer the request.
+type MinerInternalError string
+
+func (e MinerInternalError) Error() string {
+	return fmt.Sprintf("RFS: Miner [%s] failed to answer the request, see its log", string(e))
+}
+
+// A read of a quorum session didn't get the same answer from enough
+// miners. Disagreed names the miners that answered differently than the
+// largest group of miners that agreed, or every miner that answered if
+// several groups are the largest, Unreachable the ones that didn't
+// answer.
+type QuorumError struct {
+	Agreed      int
+	Quorum      int
+	Disagreed   []string
+	Unreachable []string
+}
+
+func (e QuorumError) Error() string {
+	return fmt.Sprintf("RFS: Only %d miners agreed on the read, %d needed. Disagreed: %v, unreachable: %v",
+		e.Agreed, e.Quorum, e.Disagreed, e.Unreachable)
+}
+
+// A response of the miner doesn't hold up against the chain of blocks a
+// verifying view synced, e.g. a record that no block of the chain appends.
+// Reason tells what didn't match.
+type VerificationError struct {
+	Miner  string
+	Reason string
+}
+
+func (e VerificationError) Error() string {
+	return fmt.Sprintf("RFS: Response of the miner [%s] failed verification: %s", e.Miner, e.Reason)
+}
+
 // </ERROR DEFINITIONS>
 ////////////////////////////////////////////////////////////////////////////////////////////
 
@@ -82,8 +258,14 @@ type RFS interface {
 	//
 	// Can return the following errors:
 	// - DisconnectedError
+	// - MinerInternalError
 	// - FileExistsError
 	// - BadFilenameError
+	// - OperationEvictedError
+	// - OperationExpiredError
+	// - ConfirmationTimeoutError
+	// - OperationRejectedError
+	// - InsufficientFundsError
 	CreateFile(fname string) (err error)
 
 	// Returns a slice of strings containing filenames of all the
@@ -91,6 +273,7 @@ type RFS interface {
 	//
 	// Can return the following errors:
 	// - DisconnectedError
+	// - MinerInternalError
 	ListFiles() (fnames []string, err error)
 
 	// Returns the total number of records in a file with filename
@@ -98,19 +281,43 @@ type RFS interface {
 	//
 	// Can return the following errors:
 	// - DisconnectedError
+	// - MinerInternalError
 	// - FileDoesNotExistError
 	TotalRecs(fname string) (numRecs uint16, err error)
 
 	// Reads a record from file fname at position recordNum into
 	// memory pointed to by record. Returns a non-nil error if the
-	// read was unsuccessful.
+	// read was unsuccessful. Waits for records that haven't been
+	// appended yet, see ReadRecWithWait to fail right away instead.
 	//
 	// Can return the following errors:
 	// - DisconnectedError
+	// - MinerInternalError
 	// - FileDoesNotExistError
-	// - RecordDoesNotExistError (indicates record at this position has not been appended yet)
 	ReadRec(fname string, recordNum uint16, record *Record) (err error)
 
+	// Same as ReadRec, but if the record hasn't been appended yet
+	// waits for it according to mode: NoWait fails right away,
+	// WaitTimeout waits up to timeout and WaitForever waits until the
+	// record is appended, like ReadRec does.
+	//
+	// Can return the same errors as ReadRec and:
+	// - RecordDoesNotExistError (indicates record at this position has not been appended yet)
+	ReadRecWithWait(fname string, recordNum uint16, record *Record, mode WaitMode, timeout time.Duration) (err error)
+
+	// Reads up to count confirmed records of file fname, starting at
+	// position start, fetching many records per request. Unlike ReadRec
+	// it doesn't wait for records that haven't been appended yet:
+	// reading past the end of the file returns only the records up to
+	// the end, and none if start is past the end, without an error. A
+	// result shorter than count means the end of the file was reached.
+	//
+	// Can return the following errors:
+	// - DisconnectedError
+	// - MinerInternalError
+	// - FileDoesNotExistError
+	ReadRecs(fname string, start uint16, count uint16) (records []Record, err error)
+
 	// Appends a new record to a file with name fname with the
 	// contents pointed to by record. Returns the position of the
 	// record that was just appended as recordNum. Returns a non-nil
@@ -118,16 +325,244 @@ type RFS interface {
 	//
 	// Can return the following errors:
 	// - DisconnectedError
+	// - MinerInternalError
 	// - FileDoesNotExistError
 	// - FileMaxLenReachedError
+	// - OperationEvictedError
+	// - OperationExpiredError
+	// - ConfirmationTimeoutError
+	// - OperationRejectedError
+	// - InsufficientFundsError
 	AppendRec(fname string, record *Record) (recordNum uint16, err error)
 
 	// Deletes the file and records associated with the filename fname
 	//
 	// Can return the following errors:
 	// - DisconnectedError
+	// - MinerInternalError
 	// - FileDoesNotExistError
+	// - OperationEvictedError
+	// - OperationExpiredError
+	// - ConfirmationTimeoutError
+	// - OperationRejectedError
+	// - InsufficientFundsError
 	DeleteFile(fname string) (err error)
+
+	// Same as CreateFile, but pays tip coins to the miner on top of the
+	// base fee so that the operation is mined ahead of cheaper ones.
+	//
+	// Can return the same errors as CreateFile
+	CreateFileWithTip(fname string, tip uint32) (err error)
+
+	// Same as AppendRec, but pays tip coins to the miner on top of the
+	// base fee so that the operation is mined ahead of cheaper ones.
+	//
+	// Can return the same errors as AppendRec
+	AppendRecWithTip(fname string, record *Record, tip uint32) (recordNum uint16, err error)
+
+	// Same as DeleteFile, but pays tip coins to the miner so that the
+	// operation is mined ahead of cheaper ones.
+	//
+	// Can return the same errors as DeleteFile
+	DeleteFileWithTip(fname string, tip uint32) (err error)
+
+	// Same as AppendRec, but the append is identified by the given key.
+	// Calling it again with the same key, e.g. after a DisconnectedError,
+	// never appends the record twice and returns the record number of the
+	// first append once it is confirmed.
+	//
+	// Can return the same errors as AppendRec
+	AppendRecWithKey(fname string, record *Record, key string) (recordNum uint16, err error)
+
+	// Appends all of the records to the file with name fname, in
+	// order, packing as many of them per block as fit. Returns the
+	// position of each record once all of them are confirmed. Records
+	// appended by other clients at the same time can end up in between.
+	// On error some of the records might have been appended already.
+	//
+	// Can return the same errors as AppendRec
+	AppendRecs(fname string, records []Record) (recordNums []uint16, err error)
+
+	// Same as AppendRec, but the record is only appended if it gets
+	// the position recordNum, i.e. if the file has exactly recordNum
+	// records when the append is mined.
+	//
+	// Can return the same errors as AppendRec and:
+	// - RecordConflictError
+	AppendRecAt(fname string, recordNum uint16, record *Record) (err error)
+
+	// Applies all of the operations of the transaction together or
+	// none of them. Returns the position of each record appended by
+	// the transaction, in the order they were added to it.
+	//
+	// Can return the following errors:
+	// - DisconnectedError
+	// - MinerInternalError
+	// - BadFilenameError
+	// - FileExistsError
+	// - FileDoesNotExistError
+	// - FileMaxLenReachedError
+	// - InvalidTransactionError
+	// - OperationEvictedError
+	// - OperationExpiredError
+	// - ConfirmationTimeoutError
+	// - OperationRejectedError
+	// - InsufficientFundsError
+	CommitTransaction(tx *Transaction) (recordNums []uint16, err error)
+
+	// Streams the confirmed changes of the file with name fname, or
+	// of every file whose name starts with fname if prefix is set:
+	// creates, deletes and new records. Only changes after the call
+	// are streamed. Each watch uses its own connection to the miner,
+	// the channel is closed once the watch stops. The miner stops
+	// watches whose events aren't read as fast as the chain grows.
+	//
+	// Can return the following errors:
+	// - DisconnectedError
+	// - MinerInternalError
+	Watch(fname string, prefix bool) (events <-chan WatchEvent, err error)
+
+	// Stops a watch started with Watch, its channel gets closed.
+	// Stopping a watch that already stopped does nothing.
+	Unwatch(events <-chan WatchEvent) (err error)
+
+	// Opens the existing file fname as a File, which reads and writes
+	// bytes instead of records, e.g. for io.Copy or bufio.
+	//
+	// Can return the following errors:
+	// - DisconnectedError
+	// - MinerInternalError
+	// - FileDoesNotExistError
+	Open(fname string) (file *File, err error)
+
+	// Closes the connection to the miner and stops the watches and the
+	// failure detector of the session. Calls made after Close fail with
+	// DisconnectedError.
+	Close() (err error)
+
+	// Returns a view of the connection whose ListFiles, TotalRecs and
+	// reads only see operations followed by at least depth blocks,
+	// instead of the depth configured in the network. A depth of 0
+	// sees tentative data of the longest chain right away. Reads of
+	// the view can return InvalidReadDepthError if the miner doesn't
+	// accept depth.
+	WithReadDepth(depth int) RFS
+
+	// Returns a view of the connection whose ListFiles, TotalRecs and
+	// reads store in used the depth the miner answered them at: the
+	// depth of WithReadDepth, or the depth configured in the network.
+	// used is left as it is by calls that fail before the miner
+	// answers about the files. Calls of the view that run at the same
+	// time share used.
+	WithDepthReport(used *ReadDepth) RFS
+
+	// Returns a view of the connection whose calls give up once ctx is
+	// done and return ctx.Err(). The miner is told to stop waiting for
+	// the cancelled call, but an operation it already accepted may
+	// still be mined. Watches started from the view stop once ctx is
+	// done.
+	WithContext(ctx context.Context) RFS
+
+	// Returns a view of the connection that checks the reads of the
+	// miner instead of trusting them. The view syncs the whole blocks of
+	// the chain of the miner, checking their proof of work against conf,
+	// and replays their ops up to the block each read was answered at.
+	// Every answer is checked against the replayed files: the listed
+	// names, the number of records, the records, and files or records
+	// that don't exist. Reads have to be answered at a depth of at least
+	// conf.Confirms, from the longest chain and from a block no older
+	// than the chain the view had synced before the read. A miner that
+	// never shows the view its newer blocks can still answer from an old
+	// chain, quorum reads guard against that. Each call syncs its own
+	// chain, keep the view to sync it only once.
+	//
+	// Reads of the view can also return:
+	// - VerificationError
+	WithVerification(conf VerifyConfig) RFS
+
+	// Returns a view of the connection whose writes are signed with
+	// key and paid by its client account, see GenerateKey, instead of
+	// the account of the miner. Miners only pay for the writes of
+	// clients without an identity if they were configured to. The
+	// account needs coins before it can write, which another client
+	// can send it with Transfer, or the operator of the miner.
+	//
+	// Writes of the view can also return:
+	// - BadSignatureError
+	WithIdentity(key *ecdsa.PrivateKey) RFS
+
+	// Moves amount coins from the client account of the view, see
+	// WithIdentity, to the account recipient, e.g. the AccountId of
+	// another client. Views without an identity can't transfer, the
+	// coins of the miner are only for its operator to give away.
+	//
+	// Can return the following errors:
+	// - DisconnectedError
+	// - MinerInternalError
+	// - InvalidTransferError
+	// - BadSignatureError
+	// - ConfirmationTimeoutError
+	// - InsufficientFundsError
+	Transfer(recipient string, amount uint32) (err error)
+
+	// Returns the coins of the account paying for the writes of the
+	// view in the longest chain of the miner.
+	//
+	// Can return the following errors:
+	// - DisconnectedError
+	// - MinerInternalError
+	Balance() (balance int, err error)
+
+	// Returns a view of the connection whose writes wait for the
+	// account paying for them to have the coins they need according to
+	// mode: NoWait fails right away, like the connection does,
+	// WaitTimeout waits up to timeout and WaitForever waits until the
+	// miner or client gets them.
+	//
+	// Writes of a connection fail with InsufficientFundsError as soon as
+	// their account can't pay for them, unless a view waits for coins.
+	WithFundsWait(mode WaitMode, timeout time.Duration) RFS
+
+	// Same as CreateFile, but returns as soon as the miner accepts the
+	// operation. The ticket is used to follow the operation with
+	// TicketStatus and WaitTicket, which report the errors of
+	// CreateFile.
+	//
+	// Can return the following errors:
+	// - DisconnectedError
+	CreateFileAsync(fname string) (ticket Ticket, err error)
+
+	// Same as AppendRec, but returns as soon as the miner accepts the
+	// operation. The record number is reported by TicketStatus and
+	// WaitTicket once the append is confirmed.
+	//
+	// Can return the following errors:
+	// - DisconnectedError
+	AppendRecAsync(fname string, record *Record) (ticket Ticket, err error)
+
+	// Same as DeleteFile, but returns as soon as the miner accepts the
+	// operation.
+	//
+	// Can return the following errors:
+	// - DisconnectedError
+	DeleteFileAsync(fname string) (ticket Ticket, err error)
+
+	// Returns right away whether the operation of the ticket is
+	// pending, mined in a block, confirmed or failed. Only the miner
+	// the operation was submitted to knows about the ticket.
+	//
+	// Can return the following errors:
+	// - DisconnectedError
+	// - MinerInternalError
+	// - UnknownTicketError
+	TicketStatus(ticket Ticket) (status OpStatus, err error)
+
+	// Same as TicketStatus, but waits for the operation to be
+	// confirmed or to fail, for at most timeout, or for as long as it
+	// takes if timeout is 0.
+	//
+	// Can return the same errors as TicketStatus
+	WaitTicket(ticket Ticket, timeout time.Duration) (status OpStatus, err error)
 }
 
 // Logger
@@ -138,7 +573,8 @@ var lg = log.New(ioutil.Discard, "rfslib: ", log.Ltime)
 // local IP:port to use to establish the connection to the miner.
 //
 // The returned rfs instance is singleton: an application is expected
-// to interact with just one rfs at a time.
+// to interact with just one rfs at a time. Use NewSession to talk to
+// several miners at once.
 //
 // This call should only succeed if the connection to the miner
 // succeeds. This call can return the following errors:
@@ -150,50 +586,97 @@ func Initialize(localAddr string, minerAddr string) (rfs RFS, err error) {
 		return *rfsInstance, nil
 	}
 
-	// Resolve TCP addresses
-	laddr, err := net.ResolveTCPAddr("tcp", localAddr)
+	session, err := newSession(localAddr, []string{minerAddr})
 	if err != nil {
 		return nil, err
 	}
+	rfsInstance = session
+	return *rfsInstance, nil
+}
 
-	maddr, err := net.ResolveTCPAddr("tcp", minerAddr)
+// The constructor for an independent RFS session. Takes the same
+// parameters as Initialize, but every call returns a new session with
+// its own connection to the miner and its own failure detector, so a
+// process can talk to several miners or networks at once. Sessions
+// need distinct localAddr and are closed with Close.
+//
+// This call can return the following errors:
+// - Networking errors related to localAddr or minerAddr
+func NewSession(localAddr string, minerAddr string) (rfs RFS, err error) {
+	return NewFailoverSession(localAddr, []string{minerAddr})
+}
+
+// Same as NewSession, but the session connects to the first of minerAddrs
+// that accepts the connection. When its miner fails, it moves on to the
+// next one: calls waiting for a response are sent again to the new miner,
+// without applying twice the writes the failed miner got through. Calls
+// fail with DisconnectedError once none of the miners accept the
+// connection. Watches and tickets don't move to the new miner, the
+// channel of a watch is closed and tickets are unknown to the new miner.
+//
+// This call can return the following errors:
+// - Networking errors related to localAddr or the last of minerAddrs
+func NewFailoverSession(localAddr string, minerAddrs []string) (rfs RFS, err error) {
+	session, err := newSession(localAddr, minerAddrs)
 	if err != nil {
 		return nil, err
 	}
+	return *session, nil
+}
 
-
-	conn, err := net.DialTCP("tcp", laddr, maddr)
+// Same as NewFailoverSession, but every read is sent to all of minerAddrs
+// and only succeeds if at least quorum of them return the same data at the
+// same block, so that a single faulty miner can't serve fake data. Writes,
+// watches and tickets go through a single miner.
+//
+// Besides their usual errors, ListFiles, TotalRecs and the record reads of
+// the session can return:
+// - QuorumError
+//
+// This call can return the following errors:
+// - Networking errors related to localAddr or the last of minerAddrs
+// - An error if quorum is not between 1 and the number of miners
+func NewQuorumSession(localAddr string, minerAddrs []string, quorum int) (rfs RFS, err error) {
+	if quorum < 1 || quorum > len(minerAddrs) {
+		return nil, fmt.Errorf("rfslib: quorum of %d out of %d miners", quorum, len(minerAddrs))
+	}
+	session, err := newSession(localAddr, minerAddrs)
 	if err != nil {
-		laddr, err := net.ResolveTCPAddr("tcp", ":0")
-		if err != nil {
-			return nil, err
-		}
-		conn, err = net.DialTCP("tcp", laddr, maddr)
-		if err != nil {
-			return nil, err
-		}
+		return nil, err
 	}
-	// Initialize failure detector
+	session.quorum = newQuorumReads(minerAddrs, quorum)
+	return *session, nil
+}
+
+func newSession(localAddr string, minerAddrs []string) (*RFSInstance, error) {
+	if len(minerAddrs) == 0 {
+		return nil, errors.New("rfslib: no miner to connect to")
+	}
+
+	// Initialize failure detector, each session monitors its own miner
 	s1 := rand.NewSource(time.Now().UnixNano())
 	r1 := rand.New(s1)
 	epochNonce := r1.Uint64()
-	fd, notifyCh, err := fdlib.InitializeFDLib(uint64(epochNonce), 5)
+	fd, notifyCh, err := fdlib.NewFDLib(uint64(epochNonce), 5)
 	if err != nil {
 		return nil, err
 	}
-	fd.AddMonitor(localAddr, minerAddr, 10)
 
-	rfsInstance = new(RFSInstance)
-	rfsInstance.tcpConn = conn
-	rfsInstance.minerAddr = minerAddr
-	rfsInstance.fdlib = fd
-	rfsInstance.failureNotifyChannel = notifyCh
-	return *rfsInstance, nil
+	conn, err := newFailoverConn(localAddr, minerAddrs, fd, notifyCh)
+	if err != nil {
+		return nil, err
+	}
+
+	session := new(RFSInstance)
+	session.conn = conn
+	session.watches = make(map[<-chan WatchEvent]*watch)
+	session.watchesMux = new(sync.Mutex)
+	return session, nil
 }
 
 // For testing purposes
 func TearDown() (err error) {
-	err = rfsInstance.tcpConn.Close()
+	err = rfsInstance.Close()
 	rfsInstance = nil
 	return
 }
@@ -202,24 +685,50 @@ func TearDown() (err error) {
 var rfsInstance *RFSInstance = nil
 
 type RFSInstance struct {
-	tcpConn   *net.TCPConn
-	minerAddr string
-	fdlib fdlib.FD
-	failureNotifyChannel <-chan fdlib.FailureDetected
+	// Shared by the goroutines using the instance
+	conn      *failoverConn
+	// Connections reads are sent to in a quorum session, nil otherwise
+	quorum    *quorumReads
+	watches map[<-chan WatchEvent]*watch
+	watchesMux *sync.Mutex
+	// Confirmation depth of reads, the miner's one unless useReadDepth is set
+	useReadDepth bool
+	readDepth int
+	// Where reads store the depth they were answered at, nil if they don't
+	usedDepth *ReadDepth
+	// Bounds every call of the instance, nil if calls are never cancelled
+	ctx context.Context
+	// Chain the reads are checked against, nil if reads are trusted
+	chain *syncedChain
+	// Key the writes are signed with, nil if the miner is asked to pay for them
+	identity *ecdsa.PrivateKey
+	// How long writes wait for coins, NoWait reports InsufficientFundsError right away
+	fundsWaitMode WaitMode
+	fundsWaitTimeout time.Duration
+}
+
+// Connection of a watch, closing done stops it
+type watch struct {
+	conn net.Conn
+	done chan bool
 }
 
 ////////////////////////////////////////////////////////////////////////////////////////////
 // RFS API Implementation
 
 func (rfs RFSInstance) DeleteFile(fname string) (err error) {
-	clientRequest := shared.RFSClientRequest{RequestType: shared.DELETE_FILE, FileName: fname}
-	err = rfs.sendClientRequest(clientRequest)
-	if err != nil {
-		return err
-	}
+	return rfs.DeleteFileWithTip(fname, 0)
+}
 
-	// Wait for response from miner
-	minerResponse, err := rfs.getMinerResponse()
+func (rfs RFSInstance) DeleteFileWithTip(fname string, tip uint32) (err error) {
+	clientRequest := shared.RFSClientRequest{
+		RequestType: shared.DELETE_FILE,
+		FileName:    fname,
+		Tip:         tip,
+		OpId:        newOpKey()}
+
+	// Wait for response from miner, other requests can be sent meanwhile
+	minerResponse, err := rfs.write(clientRequest)
 	if err != nil {
 		return err
 	}
@@ -232,15 +741,19 @@ func (rfs RFSInstance) DeleteFile(fname string) (err error) {
 }
 
 func (rfs RFSInstance) CreateFile(fname string) (err error) {
-	// Encode and send the client request
-	clientRequest := shared.RFSClientRequest{RequestType: shared.CREATE_FILE, FileName: fname}
-	err = rfs.sendClientRequest(clientRequest)
-	if err != nil {
-		return err
-	}
+	return rfs.CreateFileWithTip(fname, 0)
+}
 
-	// Wait for response from miner
-	minerResponse, err := rfs.getMinerResponse()
+func (rfs RFSInstance) CreateFileWithTip(fname string, tip uint32) (err error) {
+	// Encode and send the client request
+	clientRequest := shared.RFSClientRequest{
+		RequestType: shared.CREATE_FILE,
+		FileName:    fname,
+		Tip:         tip,
+		OpId:        newOpKey()}
+
+	// Wait for response from miner, other requests can be sent meanwhile
+	minerResponse, err := rfs.write(clientRequest)
 	if err != nil {
 		return err
 	}
@@ -254,14 +767,16 @@ func (rfs RFSInstance) CreateFile(fname string) (err error) {
 
 func (rfs RFSInstance) ListFiles() (fnames []string, err error) {
 	// Encode and send the client request
-	clientRequest := shared.RFSClientRequest{RequestType: shared.LIST_FILES}
-	err = rfs.sendClientRequest(clientRequest)
-	if err != nil {
-		return nil, err
-	}
-
-	// Wait for response from miner
-	minerResponse, err := rfs.getMinerResponse()
+	clientRequest := shared.RFSClientRequest{
+		RequestType:  shared.LIST_FILES,
+		UseReadDepth: rfs.useReadDepth,
+		ReadDepth:    rfs.readDepth}
+
+	// Wait for response from miner, other requests can be sent meanwhile
+	minerResponse, err := rfs.verifiedRead(clientRequest,
+		func(files map[shared.Filename]*shared.FileInfo, minerResponse shared.RFSMinerResponse) error {
+			return checkFileNames(files, minerResponse.FileNames)
+		})
 	if err != nil {
 		return nil, err
 	}
@@ -275,14 +790,17 @@ func (rfs RFSInstance) ListFiles() (fnames []string, err error) {
 
 func (rfs RFSInstance) TotalRecs(fname string) (numRecs uint16, err error) {
 	// Encode and send the client request
-	clientRequest := shared.RFSClientRequest{RequestType: shared.TOTAL_RECS, FileName: fname}
-	err = rfs.sendClientRequest(clientRequest)
-	if err != nil {
-		return 0, err
-	}
-
-	// Wait for response from miner
-	minerResponse, err := rfs.getMinerResponse()
+	clientRequest := shared.RFSClientRequest{
+		RequestType:  shared.TOTAL_RECS,
+		FileName:     fname,
+		UseReadDepth: rfs.useReadDepth,
+		ReadDepth:    rfs.readDepth}
+
+	// Wait for response from miner, other requests can be sent meanwhile
+	minerResponse, err := rfs.verifiedRead(clientRequest,
+		func(files map[shared.Filename]*shared.FileInfo, minerResponse shared.RFSMinerResponse) error {
+			return checkNumRecords(files, fname, minerResponse.NumRecords, minerResponse.ErrorType)
+		})
 	if err != nil {
 		return 0, err
 	}
@@ -294,16 +812,64 @@ func (rfs RFSInstance) TotalRecs(fname string) (numRecs uint16, err error) {
 	return minerResponse.NumRecords, responseErr
 }
 
+func (rfs RFSInstance) WithReadDepth(depth int) RFS {
+	// the view shares the connection, only its requests change
+	rfs.useReadDepth = true
+	rfs.readDepth = depth
+	return rfs
+}
+
+func (rfs RFSInstance) WithDepthReport(used *ReadDepth) RFS {
+	// the view shares the connection, only where the depth of its reads goes changes
+	rfs.usedDepth = used
+	return rfs
+}
+
+func (rfs RFSInstance) WithContext(ctx context.Context) RFS {
+	// the view shares the connection, only its requests change
+	rfs.ctx = ctx
+	return rfs
+}
+
+func (rfs RFSInstance) WithVerification(conf VerifyConfig) RFS {
+	// the view shares the connection, its reads are checked against its own chain
+	rfs.chain = newSyncedChain(conf)
+	return rfs
+}
+
+func (rfs RFSInstance) WithIdentity(key *ecdsa.PrivateKey) RFS {
+	// the view shares the connection, its writes are signed with key
+	rfs.identity = key
+	return rfs
+}
+
+func (rfs RFSInstance) WithFundsWait(mode WaitMode, timeout time.Duration) RFS {
+	// the view shares the connection, only its requests change
+	rfs.fundsWaitMode = mode
+	rfs.fundsWaitTimeout = timeout
+	return rfs
+}
+
 func (rfs RFSInstance) ReadRec(fname string, recordNum uint16, record *Record) (err error) {
-	// Encode and send the client request
-	clientRequest := shared.RFSClientRequest{RequestType: shared.READ_REC, FileName: fname, RecordNum: recordNum}
-	err = rfs.sendClientRequest(clientRequest)
-	if err != nil {
-		return err
-	}
+	return rfs.ReadRecWithWait(fname, recordNum, record, WaitForever, 0)
+}
 
-	// Wait for response from miner
-	minerResponse, err := rfs.getMinerResponse()
+func (rfs RFSInstance) ReadRecWithWait(fname string, recordNum uint16, record *Record, mode WaitMode, timeout time.Duration) (err error) {
+	// Encode and send the client request
+	clientRequest := shared.RFSClientRequest{
+		RequestType:  shared.READ_REC,
+		FileName:     fname,
+		RecordNum:    recordNum,
+		WaitMode:     shared.WaitMode(mode),
+		WaitTimeout:  timeout,
+		UseReadDepth: rfs.useReadDepth,
+		ReadDepth:    rfs.readDepth}
+
+	// Wait for response from miner, other requests can be sent meanwhile
+	minerResponse, err := rfs.verifiedRead(clientRequest,
+		func(files map[shared.Filename]*shared.FileInfo, minerResponse shared.RFSMinerResponse) error {
+			return checkRecord(files, fname, recordNum, minerResponse.ReadRecord, minerResponse.ErrorType)
+		})
 	if err != nil {
 		return err
 	}
@@ -311,23 +877,91 @@ func (rfs RFSInstance) ReadRec(fname string, recordNum uint16, record *Record) (
 	// Generate the proper error to return to the client
 	responseErr := rfs.generateResponseError(clientRequest, minerResponse)
 
-	// Copy the returned bytes into record
-	copy(record[:], minerResponse.ReadRecord[:])
+	// Copy the returned bytes into record, a failed read leaves it untouched
+	if responseErr == nil {
+		copy(record[:], minerResponse.ReadRecord[:])
+	}
 
 	lg.Printf("Miner responded to read rec request")
 	return responseErr
 }
 
-func (rfs RFSInstance) AppendRec(fname string, record *Record) (recordNum uint16, err error) {
-	// Encode and send the client request
-	clientRequest := shared.RFSClientRequest{RequestType: shared.APPEND_REC, FileName: fname, AppendRecord: *record}
-	err = rfs.sendClientRequest(clientRequest)
-	if err != nil {
-		return 0, err
+func (rfs RFSInstance) ReadRecs(fname string, start uint16, count uint16) (records []Record, err error) {
+	records = make([]Record, 0, count)
+	for len(records) < int(count) {
+		next := int(start) + len(records)
+		if next > int(shared.MAX_RECORD_COUNT) {
+			break
+		}
+		readCount := int(count) - len(records)
+		if readCount > shared.MAX_RECS_PER_READ_REQUEST {
+			readCount = shared.MAX_RECS_PER_READ_REQUEST
+		}
+
+		// Encode and send the client request
+		clientRequest := shared.RFSClientRequest{
+			RequestType:  shared.READ_RECS,
+			FileName:     fname,
+			RecordNum:    uint16(next),
+			ReadCount:    uint16(readCount),
+			UseReadDepth: rfs.useReadDepth,
+			ReadDepth:    rfs.readDepth}
+
+		// Wait for response from miner, other requests can be sent meanwhile
+		minerResponse, err := rfs.verifiedRead(clientRequest,
+			func(files map[shared.Filename]*shared.FileInfo, minerResponse shared.RFSMinerResponse) error {
+				err := checkNumRecords(files, fname, minerResponse.NumRecords, minerResponse.ErrorType)
+				if err != nil || minerResponse.ErrorType != shared.NO_ERROR {
+					return err
+				}
+				return checkRecords(files, fname, uint16(next), uint16(readCount), minerResponse.ReadRecords)
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		// Generate the proper error to return to the client
+		responseErr := rfs.generateResponseError(clientRequest, minerResponse)
+		if responseErr != nil {
+			return nil, responseErr
+		}
+		for _, record := range minerResponse.ReadRecords {
+			records = append(records, record)
+		}
+
+		// a short batch means the end of the file was reached
+		if len(minerResponse.ReadRecords) < readCount {
+			break
+		}
 	}
 
-	// Wait for response from miner
-	minerResponse, err := rfs.getMinerResponse()
+	lg.Printf("Miner responded to read records request")
+	return records, nil
+}
+
+func (rfs RFSInstance) AppendRec(fname string, record *Record) (recordNum uint16, err error) {
+	return rfs.AppendRecWithTip(fname, record, 0)
+}
+
+func (rfs RFSInstance) AppendRecWithTip(fname string, record *Record, tip uint32) (recordNum uint16, err error) {
+	return rfs.appendRec(fname, record, tip, newOpKey())
+}
+
+func (rfs RFSInstance) AppendRecWithKey(fname string, record *Record, key string) (recordNum uint16, err error) {
+	return rfs.appendRec(fname, record, 0, key)
+}
+
+func (rfs RFSInstance) appendRec(fname string, record *Record, tip uint32, key string) (recordNum uint16, err error) {
+	// Encode and send the client request, the key makes resending it safe
+	clientRequest := shared.RFSClientRequest{
+		RequestType:  shared.APPEND_REC,
+		FileName:     fname,
+		AppendRecord: *record,
+		Tip:          tip,
+		OpId:         key}
+
+	// Wait for response from miner, other requests can be sent meanwhile
+	minerResponse, err := rfs.write(clientRequest)
 	if err != nil {
 		return 0, err
 	}
@@ -339,100 +973,227 @@ func (rfs RFSInstance) AppendRec(fname string, record *Record) (recordNum uint16
 	return minerResponse.RecordNum, responseErr
 }
 
-////////////////////////////////////////////////////////////////////////////////////////////
-// RFSInstance helper functions
+func (rfs RFSInstance) AppendRecAt(fname string, recordNum uint16, record *Record) (err error) {
+	// Encode and send the client request, the key makes resending it safe
+	clientRequest := shared.RFSClientRequest{
+		RequestType:  shared.APPEND_REC_AT,
+		FileName:     fname,
+		RecordNum:    recordNum,
+		AppendRecord: *record,
+		OpId:         newOpKey()}
+
+	// Wait for response from miner, other requests can be sent meanwhile
+	minerResponse, err := rfs.write(clientRequest)
+	if err != nil {
+		return err
+	}
 
-func (rfs RFSInstance) sendClientRequest(clientRequest shared.RFSClientRequest) (error) {
-	retryCount := 0
-	for {
-		if retryCount >= shared.CLIENT_RETRY_COUNT {
-			lg.Println("retry count exceeded for sendClientRequest()")
-			return DisconnectedError(rfs.minerAddr)
-		}
+	// Generate the proper error to return to the client
+	responseErr := rfs.generateResponseError(clientRequest, minerResponse)
 
-		done := make(chan bool, 1)
-		go func() {
-			var buf bytes.Buffer
-			enc := gob.NewEncoder(&buf)
-			err := enc.Encode(clientRequest)
-			if err != nil {
-				// This may be a little harsh, but we should never hit encoding errors
-				panic(err)
-			}
+	lg.Printf("Miner responded to conditional append record request")
+	return responseErr
+}
 
-			// Send to miner
-			lg.Println("Sending client request to miner")
-			rfs.tcpConn.SetWriteDeadline(time.Now().Add(time.Minute * 30))
-			_, err = rfs.tcpConn.Write(buf.Bytes())
-			if err != nil {
-				lg.Println(err)
-				done <- false
-			}
+func (rfs RFSInstance) AppendRecs(fname string, records []Record) (recordNums []uint16, err error) {
+	key := newOpKey()
+	recordNums = make([]uint16, 0, len(records))
+	for start := 0; start < len(records); start += shared.MAX_RECS_PER_APPEND_REQUEST {
+		end := start + shared.MAX_RECS_PER_APPEND_REQUEST
+		if end > len(records) {
+			end = len(records)
+		}
+		appendRecords := make([][512]byte, 0, end - start)
+		for _, record := range records[start:end] {
+			appendRecords = append(appendRecords, record)
+		}
 
-			done <- true
-		}()
+		// Encode and send the client request, every request of the batch gets its own key
+		clientRequest := shared.RFSClientRequest{
+			RequestType:   shared.APPEND_RECS,
+			FileName:      fname,
+			AppendRecords: appendRecords,
+			OpId:          fmt.Sprintf("%s-%d", key, start)}
 
-		// todo ksenia timeout?
-		select {
-		case <-rfs.failureNotifyChannel:
-			// Miner node failed
-			return DisconnectedError(rfs.minerAddr)
-		case success := <-done:
-			if !success {
-				retryCount++
-			} else {
-				return nil
-			}
+		// Wait for response from miner, other requests can be sent meanwhile
+		minerResponse, err := rfs.write(clientRequest)
+		if err != nil {
+			return nil, err
+		}
+
+		// Generate the proper error to return to the client
+		responseErr := rfs.generateResponseError(clientRequest, minerResponse)
+		if responseErr != nil {
+			return nil, responseErr
 		}
+		recordNums = append(recordNums, minerResponse.RecordNums...)
 	}
+
+	lg.Printf("Miner responded to append records request")
+	return recordNums, nil
 }
 
-func (rfs RFSInstance) getMinerResponse() (shared.RFSMinerResponse, error) {
-	retryCount := 0
-	for {
-		minerResponse := shared.RFSMinerResponse{}
-		if retryCount >= shared.CLIENT_RETRY_COUNT {
-			lg.Println("retry count exceeded for getMinerResponse()")
-			return minerResponse, DisconnectedError(rfs.minerAddr)
+func (rfs RFSInstance) Watch(fname string, prefix bool) (events <-chan WatchEvent, err error) {
+	// every watch gets its own connection so that its events don't get mixed with responses
+	ctx := rfs.context()
+	minerAddr := rfs.conn.minerAddr()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", minerAddr)
+	if err != nil {
+		lg.Println(err)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
-		done := make(chan bool, 1)
-		go func() {
-			// Make a buffer to hold incoming response
-			responseBuf := make([]byte, 1024)
-
-			// Read the incoming connection into the buffer
-			rfs.tcpConn.SetReadDeadline(time.Now().Add(time.Minute * 30))
-			readLen, err := rfs.tcpConn.Read(responseBuf)
+		return nil, DisconnectedError(minerAddr)
+	}
+
+	// The watch stops once ctx is done, closing the connection also interrupts the wait for the response
+	w := &watch{conn: conn, done: make(chan bool)}
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-w.done:
+		}
+	}()
+	fail := func(err error) (<-chan WatchEvent, error) {
+		close(w.done)
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	// Encode and send the client request
+	clientRequest := shared.RFSClientRequest{RequestType: shared.WATCH, FileName: fname, WatchPrefix: prefix}
+	err = shared.WriteFrame(conn, clientRequest)
+	if err != nil {
+		lg.Println(err)
+		return fail(DisconnectedError(minerAddr))
+	}
+
+	// The response and the events that follow it are read as a stream of frames
+	frames := shared.NewFrameReader(conn)
+	minerResponse := shared.RFSMinerResponse{}
+	err = frames.Read(&minerResponse)
+	if err != nil {
+		lg.Println(err)
+		return fail(DisconnectedError(minerAddr))
+	}
+	responseErr := rfs.generateResponseError(clientRequest, minerResponse)
+	if responseErr != nil {
+		return fail(responseErr)
+	}
+
+	ch := make(chan WatchEvent, 100)
+	rfs.watchesMux.Lock()
+	rfs.watches[ch] = w
+	rfs.watchesMux.Unlock()
+	go func() {
+		defer close(ch)
+		defer rfs.Unwatch(ch)
+		for {
+			event := shared.WatchEvent{}
+			err := frames.Read(&event)
 			if err != nil {
 				lg.Println(err)
-				done <- false
 				return
 			}
-
-			// Decode the miner response
-			var reader = bytes.NewReader(responseBuf[:readLen])
-			dec := gob.NewDecoder(reader)
-			err = dec.Decode(&minerResponse)
-			if err != nil {
-				// Again, we should never hit decoding errors
-				panic(err)
+			select {
+			case ch <- WatchEvent{
+				Type:      WatchEventType(event.Type),
+				Filename:  event.FileName,
+				RecordNum: event.RecordNum,
+				Record:    event.Record}:
+			case <-w.done:
+				return
 			}
-			done <- true
-		}()
+		}
+	}()
 
-		// todo ksenia timeout?
-		select {
-		case <-rfs.failureNotifyChannel:
-			// Miner node failed
-			return minerResponse, DisconnectedError(rfs.minerAddr)
-		case success := <- done:
-			if !success {
-				retryCount++
-			} else {
-				return minerResponse, nil
-			}
+	lg.Printf("Miner responded to watch request")
+	return ch, nil
+}
+
+func (rfs RFSInstance) Unwatch(events <-chan WatchEvent) (err error) {
+	rfs.watchesMux.Lock()
+	w, ok := rfs.watches[events]
+	delete(rfs.watches, events)
+	rfs.watchesMux.Unlock()
+	if !ok {
+		return nil
+	}
+
+	close(w.done)
+	w.conn.Close()
+	return nil
+}
+
+func (rfs RFSInstance) Close() (err error) {
+	rfs.watchesMux.Lock()
+	watches := make([]<-chan WatchEvent, 0, len(rfs.watches))
+	for events := range rfs.watches {
+		watches = append(watches, events)
+	}
+	rfs.watchesMux.Unlock()
+	for _, events := range watches {
+		rfs.Unwatch(events)
+	}
+
+	if rfs.quorum != nil {
+		rfs.quorum.close()
+	}
+	return rfs.conn.close()
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////
+// RFSInstance helper functions
+
+// Sends a read request, to every miner of a quorum session
+func (rfs RFSInstance) read(clientRequest shared.RFSClientRequest) (shared.RFSMinerResponse, error) {
+	if rfs.quorum != nil {
+		return rfs.quorum.request(rfs.context(), clientRequest)
+	}
+	return rfs.conn.request(rfs.context(), clientRequest)
+}
+
+// Stores the depth of a read answered by the miner for a view that reports it
+func (rfs RFSInstance) reportDepth(minerResponse shared.RFSMinerResponse) {
+	if rfs.usedDepth == nil {
+		return
+	}
+	switch minerResponse.ErrorType {
+	case shared.NO_ERROR, shared.FILE_DOES_NOT_EXIST, shared.RECORD_DOES_NOT_EXIST:
+		*rfs.usedDepth = ReadDepth{Create: minerResponse.ReadDepth.Create, Append: minerResponse.ReadDepth.Append}
+	}
+}
+
+// Sends a write request, signed by the identity of the view if it has one so that its client pays for it,
+// and waiting for coins as long as the view does
+func (rfs RFSInstance) write(clientRequest shared.RFSClientRequest) (shared.RFSMinerResponse, error) {
+	clientRequest.FundsWaitMode = shared.WaitMode(rfs.fundsWaitMode)
+	clientRequest.FundsWaitTimeout = rfs.fundsWaitTimeout
+	if rfs.identity != nil {
+		err := signRequest(rfs.identity, &clientRequest)
+		if err != nil {
+			return shared.RFSMinerResponse{}, err
 		}
 	}
+	return rfs.conn.request(rfs.context(), clientRequest)
+}
+
+// Context of the calls of the instance
+func (rfs RFSInstance) context() context.Context {
+	if rfs.ctx == nil {
+		return context.Background()
+	}
+	return rfs.ctx
+}
+
+// Random 128 bit key that identifies an op, miners apply the requests
+// carrying the same key only once
+func newOpKey() string {
+	return fmt.Sprintf("%016x%016x", rand.Uint64(), rand.Uint64())
 }
 
 func (rfs RFSInstance) generateResponseError(
@@ -444,13 +1205,41 @@ func (rfs RFSInstance) generateResponseError(
 		case shared.BAD_FILENAME:
 			err = BadFilenameError(clientRequest.FileName)
 		case shared.DISCONNECTED:
-			err = DisconnectedError(rfs.minerAddr)
+			err = DisconnectedError(rfs.conn.minerAddr())
 		case shared.FILE_DOES_NOT_EXIST:
 			err = FileDoesNotExistError(clientRequest.FileName)
 		case shared.FILE_EXISTS:
 			err = FileExistsError(clientRequest.FileName)
 		case shared.MAX_LEN_REACHED:
 			err = FileMaxLenReachedError(clientRequest.FileName)
+		case shared.OP_EVICTED:
+			err = OperationEvictedError(clientRequest.FileName)
+		case shared.OP_EXPIRED:
+			err = OperationExpiredError(clientRequest.FileName)
+		case shared.OP_TIMED_OUT:
+			err = ConfirmationTimeoutError(clientRequest.FileName)
+		case shared.OP_REJECTED, shared.APPEND_DUPLICATE, shared.OP_DUPLICATE:
+			err = OperationRejectedError(clientRequest.FileName)
+		case shared.RECORD_CONFLICT:
+			err = RecordConflictError(clientRequest.FileName)
+		case shared.RECORD_DOES_NOT_EXIST:
+			err = RecordDoesNotExistError(clientRequest.FileName)
+		case shared.INVALID_READ_DEPTH:
+			err = InvalidReadDepthError(clientRequest.ReadDepth)
+		case shared.UNKNOWN_OP:
+			err = UnknownTicketError(clientRequest.OpId)
+		case shared.REQUEST_CANCELLED:
+			err = context.Canceled
+		case shared.TRANSACTION_INVALID:
+			err = InvalidTransactionError("rejected by the miner")
+		case shared.BAD_SIGNATURE:
+			err = BadSignatureError(clientRequest.OpId)
+		case shared.NOT_ENOUGH_MONEY:
+			err = InsufficientFundsError{Balance: minerResponse.Balance, Needed: minerResponse.Needed}
+		case shared.INVALID_TRANSFER:
+			err = InvalidTransferError(clientRequest.Recipient)
+		case shared.INTERNAL_ERROR:
+			err = MinerInternalError(rfs.conn.minerAddr())
 		}
 	}
 	return
@@ -0,0 +1,72 @@
+package rfslib
+
+import (
+	"../shared"
+	"strings"
+)
+
+// Groups file operations that are mined together, either all of them
+// are applied or none of them. Ops are applied in the order they are
+// added, e.g. a file can be created and written in the same transaction.
+type Transaction struct {
+	ops []shared.TransactionOp
+}
+
+func NewTransaction() *Transaction {
+	return &Transaction{ops: make([]shared.TransactionOp, 0)}
+}
+
+func (tx *Transaction) CreateFile(fname string) *Transaction {
+	tx.ops = append(tx.ops, shared.TransactionOp{RequestType: shared.CREATE_FILE, FileName: fname})
+	return tx
+}
+
+func (tx *Transaction) AppendRec(fname string, record *Record) *Transaction {
+	tx.ops = append(tx.ops, shared.TransactionOp{
+		RequestType:  shared.APPEND_REC,
+		FileName:     fname,
+		AppendRecord: *record})
+	return tx
+}
+
+func (tx *Transaction) DeleteFile(fname string) *Transaction {
+	tx.ops = append(tx.ops, shared.TransactionOp{RequestType: shared.DELETE_FILE, FileName: fname})
+	return tx
+}
+
+func (rfs RFSInstance) CommitTransaction(tx *Transaction) (recordNums []uint16, err error) {
+	if len(tx.ops) == 0 {
+		return nil, InvalidTransactionError("it has no operations")
+	}
+	// the whole transaction goes in a single request
+	if len(tx.ops) > shared.MAX_RECS_PER_APPEND_REQUEST {
+		return nil, InvalidTransactionError("it has too many operations")
+	}
+
+	// Encode and send the client request, errors name all of the files of the transaction
+	fnames := make([]string, 0, len(tx.ops))
+	seen := make(map[string]bool)
+	for _, op := range tx.ops {
+		if !seen[op.FileName] {
+			seen[op.FileName] = true
+			fnames = append(fnames, op.FileName)
+		}
+	}
+	clientRequest := shared.RFSClientRequest{
+		RequestType:    shared.TRANSACTION,
+		FileName:       strings.Join(fnames, ", "),
+		TransactionOps: tx.ops,
+		OpId:           newOpKey()}
+
+	// Wait for response from miner, other requests can be sent meanwhile
+	minerResponse, err := rfs.write(clientRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate the proper error to return to the client
+	responseErr := rfs.generateResponseError(clientRequest, minerResponse)
+
+	lg.Printf("Miner responded to transaction request")
+	return minerResponse.RecordNums, responseErr
+}
@@ -0,0 +1,394 @@
+package rfslib
+
+import (
+	"../crypto"
+	"../shared"
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Settings of the network a verifying view checks the responses of the
+// miner against, they have to match the configuration of the miners.
+// Confirms is the number of blocks that have to follow the block of an op
+// for a read to see it, reads answered at a smaller depth for creates or
+// for appends fail.
+type VerifyConfig struct {
+	GenesisBlockHash string
+	PowPerOpBlock    int
+	PowPerNoOpBlock  int
+	Confirms         int
+}
+
+// Chain of blocks synced from the miner by a verifying view, every block
+// has enough proof of work and follows a block of the chain. The blocks
+// are kept whole, ops included, so the files a read was answered at can be
+// replayed from the genesis block on. It grows with the chain of the
+// network and is never pruned.
+type syncedChain struct {
+	conf VerifyConfig
+	// guards blocks, tip and replayed
+	mux    *sync.Mutex
+	blocks map[string]syncedBlock
+	// newest block of the longest chain
+	tip string
+	// files of the last read block replayed, reads of the same block at
+	// the same depth reuse them
+	replayed *replayedFiles
+}
+
+type syncedBlock struct {
+	block  *crypto.Block
+	parent string
+	height int
+}
+
+type replayedFiles struct {
+	block string
+	depth shared.ConfirmDepth
+	files map[shared.Filename]*shared.FileInfo
+}
+
+func newSyncedChain(conf VerifyConfig) *syncedChain {
+	return &syncedChain{
+		conf:   conf,
+		mux:    new(sync.Mutex),
+		blocks: make(map[string]syncedBlock),
+	}
+}
+
+// Adds a block to the chain, added is false if it already is in it. Fails
+// if the block can't be part of the chain
+func (sc *syncedChain) add(b *crypto.Block) (added bool, err error) {
+	if !knownBlockType(b) {
+		return false, fmt.Errorf("block of unknown type [%d]", b.Type)
+	}
+	if hasNilOps(b.Records) {
+		return false, fmt.Errorf("block has empty ops")
+	}
+	id := b.Id()
+
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	if _, ok := sc.blocks[id]; ok {
+		return false, nil
+	}
+
+	sb := syncedBlock{block: b}
+	if b.Type == crypto.GenesisBlock {
+		if id != sc.conf.GenesisBlockHash {
+			return false, fmt.Errorf("genesis block [%s] is not the one of the network", id)
+		}
+	} else {
+		if !b.Valid(sc.conf.PowPerOpBlock, sc.conf.PowPerNoOpBlock) {
+			return false, fmt.Errorf("block [%s] doesn't have enough proof of work", id)
+		}
+		parent, ok := sc.blocks[fmt.Sprintf("%x", b.PrevBlock[:])]
+		if !ok {
+			return false, fmt.Errorf("block [%s] doesn't follow a block of the chain", id)
+		}
+		sb.parent = fmt.Sprintf("%x", b.PrevBlock[:])
+		sb.height = parent.height + 1
+	}
+	sc.blocks[id] = sb
+	if sc.tip == "" || sb.height > sc.blocks[sc.tip].height {
+		sc.tip = id
+	}
+	return true, nil
+}
+
+// Ids of blocks of the longest chain for the miner to find where the chain
+// stops matching its own, newest first and further apart the older they are
+func (sc *syncedChain) locator() []string {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	locator := make([]string, 0)
+	id := sc.tip
+	for step := 1; id != ""; {
+		locator = append(locator, id)
+		if sc.blocks[id].height == 0 {
+			// the genesis block is always last
+			break
+		}
+		if len(locator) > 8 {
+			step *= 2
+		}
+		for i := 0; i < step && sc.blocks[id].height > 0; i++ {
+			id = sc.blocks[id].parent
+		}
+	}
+	return locator
+}
+
+// Height of the longest chain, -1 while the chain is empty
+func (sc *syncedChain) height() int {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	if sc.tip == "" {
+		return -1
+	}
+	return sc.blocks[sc.tip].height
+}
+
+// Number of blocks on top of the block in the longest chain, ok is false
+// if the block isn't in it. The caller holds mux
+func (sc *syncedChain) depth(id string) (depth int, ok bool) {
+	for curr := sc.tip; curr != ""; depth++ {
+		if curr == id {
+			return depth, true
+		}
+		if sc.blocks[curr].height == 0 {
+			break
+		}
+		curr = sc.blocks[curr].parent
+	}
+	return 0, false
+}
+
+// Files of the longest chain as seen by a read answered at readBlock and
+// depth, by replaying the ops of the chain up to readBlock the way the
+// miners do. readBlock needs as many blocks on top as the smaller depth,
+// unless it is the genesis block, and has to be at most that many blocks
+// below minHeight, the height the chain had before the read. Otherwise the
+// miner answered from a chain that isn't the longest one or an older one.
+func (sc *syncedChain) files(readBlock string, depth shared.ConfirmDepth, minHeight int) (
+	map[shared.Filename]*shared.FileInfo, error) {
+	confirms := depth.Create
+	if depth.Append < confirms {
+		confirms = depth.Append
+	}
+	if confirms < sc.conf.Confirms {
+		return nil, fmt.Errorf("read at depth [%d/%d], fewer than the [%d] confirmations needed",
+			depth.Create, depth.Append, sc.conf.Confirms)
+	}
+
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	readDepth, ok := sc.depth(readBlock)
+	if !ok {
+		return nil, fmt.Errorf("read block [%s] is not in the chain", readBlock)
+	}
+	read := sc.blocks[readBlock]
+	if readDepth < confirms && read.height > 0 {
+		return nil, fmt.Errorf("read block [%s] has [%d] blocks on top, fewer than [%d]", readBlock, readDepth, confirms)
+	}
+	if read.height < minHeight-confirms {
+		return nil, fmt.Errorf("read block [%s] is older than the chain the view had already synced", readBlock)
+	}
+
+	if sc.replayed != nil && sc.replayed.block == readBlock && sc.replayed.depth == depth {
+		return sc.replayed.files, nil
+	}
+	chain := make([]*crypto.Block, read.height+1)
+	for id := readBlock; ; id = sc.blocks[id].parent {
+		chain[sc.blocks[id].height] = sc.blocks[id].block
+		if sc.blocks[id].height == 0 {
+			break
+		}
+	}
+	files := make(map[shared.Filename]*shared.FileInfo)
+	for idx, b := range chain {
+		if b.Type != crypto.RegularBlock {
+			continue
+		}
+		// the miner counted the blocks on top of its head, confirms of them are on top of readBlock
+		numNodesInFrontOfMe := len(chain) - 1 - idx + confirms
+		err := shared.ApplyFileOps(files, b.Records,
+			numNodesInFrontOfMe >= depth.Create, numNodesInFrontOfMe >= depth.Append)
+		if err != nil {
+			return nil, fmt.Errorf("block [%s] doesn't apply: %v", b.Id(), err)
+		}
+	}
+	sc.replayed = &replayedFiles{block: readBlock, depth: depth, files: files}
+	return files, nil
+}
+
+// Checks the names of a LIST_FILES response against the files of the chain
+func checkFileNames(files map[shared.Filename]*shared.FileInfo, fnames []string) error {
+	expected := make([]string, 0, len(files))
+	for fname := range files {
+		expected = append(expected, string(fname))
+	}
+	sort.Strings(expected)
+	got := append([]string(nil), fnames...)
+	sort.Strings(got)
+	if len(got) != len(expected) {
+		return fmt.Errorf("listed [%d] files, the chain has [%d]", len(got), len(expected))
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			return fmt.Errorf("listed file [%s] that is not in the chain", got[i])
+		}
+	}
+	return nil
+}
+
+// Checks whether fname exists and has numRecs records in the files of the
+// chain, for a read that answered with errorType
+func checkNumRecords(files map[shared.Filename]*shared.FileInfo, fname string, numRecs uint16,
+	errorType shared.FailureType) error {
+	file, ok := files[shared.Filename(fname)]
+	if errorType == shared.FILE_DOES_NOT_EXIST {
+		if ok {
+			return fmt.Errorf("file [%s] is in the chain", fname)
+		}
+		return nil
+	}
+	if !ok {
+		return fmt.Errorf("file [%s] is not in the chain", fname)
+	}
+	if numRecs != file.NumberOfRecords {
+		return fmt.Errorf("file [%s] has [%d] records in the chain, not [%d]", fname, file.NumberOfRecords, numRecs)
+	}
+	return nil
+}
+
+// Checks the records read from fname from start on against the files of
+// the chain, a read of count records returns all the records of the file
+// from start on up to count of them
+func checkRecords(files map[shared.Filename]*shared.FileInfo, fname string, start uint16, count uint16,
+	records [][512]byte) error {
+	file, ok := files[shared.Filename(fname)]
+	if !ok {
+		return fmt.Errorf("file [%s] is not in the chain", fname)
+	}
+	expected := 0
+	if start < file.NumberOfRecords {
+		expected = int(file.NumberOfRecords - start)
+	}
+	if expected > int(count) {
+		expected = int(count)
+	}
+	if len(records) != expected {
+		return fmt.Errorf("read [%d] records of [%s] from [%d] on, the chain has [%d]", len(records), fname, start, expected)
+	}
+	for i, record := range records {
+		offset := (int(start) + i) * crypto.DataBlockSize
+		if !bytes.Equal(record[:], file.Data[offset:offset+crypto.DataBlockSize]) {
+			return fmt.Errorf("record [%d] of [%s] doesn't match the one in the chain", int(start)+i, fname)
+		}
+	}
+	return nil
+}
+
+// Checks a READ_REC response against the files of the chain
+func checkRecord(files map[shared.Filename]*shared.FileInfo, fname string, recordNum uint16, record [512]byte,
+	errorType shared.FailureType) error {
+	switch errorType {
+	case shared.FILE_DOES_NOT_EXIST:
+		return checkNumRecords(files, fname, 0, errorType)
+	case shared.RECORD_DOES_NOT_EXIST:
+		file, ok := files[shared.Filename(fname)]
+		if !ok {
+			return fmt.Errorf("file [%s] is not in the chain", fname)
+		}
+		if recordNum < file.NumberOfRecords {
+			return fmt.Errorf("record [%d] of [%s] is in the chain", recordNum, fname)
+		}
+		return nil
+	}
+	return checkRecords(files, fname, recordNum, 1, [][512]byte{record})
+}
+
+// Hashing a block of another type panics
+func knownBlockType(b *crypto.Block) bool {
+	switch b.Type {
+	case crypto.GenesisBlock, crypto.RegularBlock, crypto.NoOpBlock:
+		return true
+	}
+	return false
+}
+
+// Hashing or replaying a block with empty ops panics
+func hasNilOps(ops []*crypto.BlockOp) bool {
+	for _, op := range ops {
+		if op == nil || hasNilOps(op.Ops) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetches the blocks of the miner's longest chain the view doesn't have yet
+func (rfs RFSInstance) syncChain() error {
+	for {
+		clientRequest := shared.RFSClientRequest{RequestType: shared.GET_HEADERS, Locator: rfs.chain.locator()}
+
+		// Wait for response from miner, other requests can be sent meanwhile
+		minerResponse, err := rfs.conn.request(rfs.context(), clientRequest)
+		if err != nil {
+			return err
+		}
+		responseErr := rfs.generateResponseError(clientRequest, minerResponse)
+		if responseErr != nil {
+			return responseErr
+		}
+
+		added := 0
+		for i := range minerResponse.Headers {
+			isNew, err := rfs.chain.add(&minerResponse.Headers[i])
+			if err != nil {
+				return VerificationError{Miner: rfs.conn.minerAddr(), Reason: err.Error()}
+			}
+			if isNew {
+				added++
+			}
+		}
+		// the miner sends the blocks past the chain a few at a time
+		if added == 0 {
+			return nil
+		}
+	}
+}
+
+// Sends a read request, a verifying view also checks the response with
+// check against the files of the chain at the block and depth the read was
+// answered at. Only answers about the files are checked, others like
+// DISCONNECTED are returned as they are.
+func (rfs RFSInstance) verifiedRead(clientRequest shared.RFSClientRequest,
+	check func(files map[shared.Filename]*shared.FileInfo, minerResponse shared.RFSMinerResponse) error) (
+	shared.RFSMinerResponse, error) {
+	if rfs.chain == nil {
+		minerResponse, err := rfs.read(clientRequest)
+		if err == nil {
+			rfs.reportDepth(minerResponse)
+		}
+		return minerResponse, err
+	}
+	// the read block can't be older than what the miner already showed
+	err := rfs.syncChain()
+	if err != nil {
+		return shared.RFSMinerResponse{}, err
+	}
+	minHeight := rfs.chain.height()
+
+	minerResponse, err := rfs.read(clientRequest)
+	if err != nil {
+		return minerResponse, err
+	}
+	switch minerResponse.ErrorType {
+	case shared.NO_ERROR, shared.FILE_DOES_NOT_EXIST, shared.RECORD_DOES_NOT_EXIST:
+	default:
+		return minerResponse, nil
+	}
+
+	err = rfs.syncChain()
+	if err != nil {
+		return minerResponse, err
+	}
+	depth := minerResponse.ReadDepth
+	if rfs.useReadDepth && (depth.Create != rfs.readDepth || depth.Append != rfs.readDepth) {
+		return minerResponse, VerificationError{Miner: rfs.conn.minerAddr(),
+			Reason: fmt.Sprintf("read at depth [%d/%d] instead of [%d]", depth.Create, depth.Append, rfs.readDepth)}
+	}
+	files, err := rfs.chain.files(minerResponse.ReadBlock, depth, minHeight)
+	if err == nil {
+		err = check(files, minerResponse)
+	}
+	if err != nil {
+		return minerResponse, VerificationError{Miner: rfs.conn.minerAddr(), Reason: err.Error()}
+	}
+	rfs.reportDepth(minerResponse)
+	return minerResponse, nil
+}
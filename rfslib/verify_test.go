@@ -0,0 +1,140 @@
+package rfslib
+
+import (
+	"../crypto"
+	"../shared"
+	"crypto/md5"
+	"testing"
+)
+
+// Mines a block of ops on top of parent with the proof of work of verifyConf
+func mineBlock(parent *crypto.Block, ops ...*crypto.BlockOp) *crypto.Block {
+	b := &crypto.Block{Type: crypto.NoOpBlock, MinerId: "miner", Records: ops}
+	if len(ops) > 0 {
+		b.Type = crypto.RegularBlock
+	}
+	copy(b.PrevBlock[:], parent.Hash())
+	b.FindNonce(verifyConf.PowPerOpBlock, verifyConf.PowPerNoOpBlock)
+	return b
+}
+
+var genesis = &crypto.Block{Type: crypto.GenesisBlock, PrevBlock: [md5.Size]byte{1, 2, 3}}
+
+var verifyConf = VerifyConfig{
+	GenesisBlockHash: genesis.Id(),
+	PowPerOpBlock:    2,
+	PowPerNoOpBlock:  1,
+	Confirms:         1,
+}
+
+func TestSyncedChain(t *testing.T) {
+	record := [512]byte{4, 2}
+	appendOp := &crypto.BlockOp{Type: crypto.AppendFile, Filename: "f", RecordNumber: 0, Data: record}
+	b1 := mineBlock(genesis, &crypto.BlockOp{Type: crypto.CreateFile, Filename: "f"})
+	b2 := mineBlock(b1, &crypto.BlockOp{Type: crypto.Transaction, Ops: []*crypto.BlockOp{appendOp}})
+	b3 := mineBlock(b2)
+	b4 := mineBlock(b3, &crypto.BlockOp{Type: crypto.DeleteFile, Filename: "f"})
+	b5 := mineBlock(b4)
+	depth := shared.ConfirmDepth{Create: 1, Append: 1}
+
+	newChain := func(t *testing.T, blocks ...*crypto.Block) *syncedChain {
+		sc := newSyncedChain(verifyConf)
+		for _, b := range blocks {
+			added, err := sc.add(b)
+			ok(t, err)
+			assert(t, added, "should add block [%s]", b.Id())
+		}
+		return sc
+	}
+
+	t.Run("should verify reads against the replayed files", func(t *testing.T) {
+		sc := newChain(t, genesis, b1, b2, b3)
+		files, err := sc.files(b2.Id(), depth, 3)
+		ok(t, err)
+		ok(t, checkFileNames(files, []string{"f"}))
+		ok(t, checkNumRecords(files, "f", 1, shared.NO_ERROR))
+		ok(t, checkRecords(files, "f", 0, 10, [][512]byte{record}))
+		ok(t, checkRecord(files, "f", 0, record, shared.NO_ERROR))
+		ok(t, checkRecord(files, "f", 1, [512]byte{}, shared.RECORD_DOES_NOT_EXIST))
+		ok(t, checkRecord(files, "g", 0, [512]byte{}, shared.FILE_DOES_NOT_EXIST))
+		equals(t, []string{b3.Id(), b2.Id(), b1.Id(), genesis.Id()}, sc.locator())
+	})
+
+	t.Run("should not verify answers that don't match the replayed files", func(t *testing.T) {
+		sc := newChain(t, genesis, b1, b2, b3)
+		files, err := sc.files(b2.Id(), depth, 3)
+		ok(t, err)
+		assert(t, checkFileNames(files, []string{"f", "g"}) != nil, "should fail because g doesn't exist")
+		assert(t, checkFileNames(files, []string{}) != nil, "should fail because f is left out")
+		assert(t, checkNumRecords(files, "f", 2, shared.NO_ERROR) != nil, "should fail because f has 1 record")
+		assert(t, checkNumRecords(files, "f", 0, shared.FILE_DOES_NOT_EXIST) != nil, "should fail because f exists")
+		assert(t, checkRecords(files, "f", 0, 10, [][512]byte{}) != nil, "should fail because record 0 is left out")
+		assert(t, checkRecord(files, "f", 0, [512]byte{6, 6, 6}, shared.NO_ERROR) != nil,
+			"should fail because the record was changed")
+		assert(t, checkRecord(files, "f", 0, [512]byte{}, shared.RECORD_DOES_NOT_EXIST) != nil,
+			"should fail because record 0 exists")
+	})
+
+	t.Run("should only replay the ops confirmed at the depth of the read", func(t *testing.T) {
+		sc := newChain(t, genesis, b1, b2, b3)
+		files, err := sc.files(b2.Id(), shared.ConfirmDepth{Create: 1, Append: 3}, 3)
+		ok(t, err)
+		ok(t, checkNumRecords(files, "f", 0, shared.NO_ERROR))
+	})
+
+	t.Run("should prove that a file was deleted", func(t *testing.T) {
+		sc := newChain(t, genesis, b1, b2, b3, b4, b5)
+		files, err := sc.files(b4.Id(), depth, 5)
+		ok(t, err)
+		ok(t, checkFileNames(files, []string{}))
+		ok(t, checkNumRecords(files, "f", 0, shared.FILE_DOES_NOT_EXIST))
+		assert(t, checkRecords(files, "f", 0, 1, [][512]byte{record}) != nil, "should fail because f was deleted")
+	})
+
+	t.Run("should not verify reads without enough blocks on top", func(t *testing.T) {
+		sc := newChain(t, genesis, b1, b2)
+		_, err := sc.files(b2.Id(), depth, 2)
+		assert(t, err != nil, "should fail because the read block has no block on top")
+		_, err = sc.files(b1.Id(), shared.ConfirmDepth{}, 2)
+		assert(t, err != nil, "should fail because the depth is below Confirms")
+	})
+
+	t.Run("should not verify reads of an older chain", func(t *testing.T) {
+		sc := newChain(t, genesis, b1, b2, b3, b4, b5)
+		_, err := sc.files(b1.Id(), depth, 5)
+		assert(t, err != nil, "should fail because newer blocks were left out")
+	})
+
+	t.Run("should not verify reads of a block that is not in the chain", func(t *testing.T) {
+		fake := mineBlock(b1, &crypto.BlockOp{Type: crypto.AppendFile, Filename: "f", Data: [512]byte{6, 6, 6}})
+		sc := newChain(t, genesis, b1, b2, b3, fake)
+		_, err := sc.files(fake.Id(), depth, 3)
+		assert(t, err != nil, "should fail because the block is not in the longest chain")
+	})
+
+	t.Run("should reject blocks without enough proof of work", func(t *testing.T) {
+		sc := newChain(t, genesis)
+		b := mineBlock(genesis, appendOp)
+		for b.Valid(verifyConf.PowPerOpBlock, verifyConf.PowPerNoOpBlock) {
+			b.Nonce++
+		}
+		_, err := sc.add(b)
+		assert(t, err != nil, "should fail because of the proof of work")
+	})
+
+	t.Run("should reject blocks that don't follow the chain", func(t *testing.T) {
+		sc := newChain(t, genesis)
+		_, err := sc.add(b2)
+		assert(t, err != nil, "should fail because the parent is missing")
+
+		other := &crypto.Block{Type: crypto.GenesisBlock, PrevBlock: [md5.Size]byte{6, 6, 6}}
+		_, err = sc.add(other)
+		assert(t, err != nil, "should fail because it is another genesis block")
+
+		_, err = sc.add(&crypto.Block{Type: crypto.BlockType(42)})
+		assert(t, err != nil, "should fail because of the block type")
+
+		_, err = sc.add(&crypto.Block{Type: crypto.RegularBlock, Records: []*crypto.BlockOp{nil}})
+		assert(t, err != nil, "should fail because of the empty op")
+	})
+}
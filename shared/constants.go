@@ -13,6 +13,22 @@ const (
 	MAX_RECORD_COUNT uint16 = math.MaxUint16
 	NUM_COINS_PER_FILE_APPEND = 1
 	LISTENER_EXPIRATION = time.Minute * 30
+	DEFAULT_MAX_PENDING_OPS = 1000
+	DEFAULT_PENDING_OP_EXPIRY_BLOCKS = 100
+	DEFAULT_PENDING_OP_EXPIRY = LISTENER_EXPIRATION
+	OPS_PER_BLOCK = 10
+	// largest message between clients and miners, bigger frames close the connection
+	MAX_MESSAGE_SIZE = 1 << 20
+	// gob takes up to 2 bytes for each byte of a record, which keeps requests and responses
+	// with these many records well under MAX_MESSAGE_SIZE
+	MAX_RECS_PER_APPEND_REQUEST = 64
+	MAX_RECS_PER_READ_REQUEST = 64
+	// blocks sent as headers or as proof of the records of a read stop before taking this many bytes,
+	// the first one is always sent
+	MAX_BLOCKS_SIZE_PER_RESPONSE = MAX_MESSAGE_SIZE / 2
+	// bounds of the confirmation depth a read can ask for
+	MIN_READ_DEPTH = 0
+	MAX_READ_DEPTH = math.MaxUint8
 	LOGFILE                   = "miner"
 )
 
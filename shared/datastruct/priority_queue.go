@@ -10,10 +10,16 @@ import (
 type Item struct {
 	Value    interface{} // The value of the item; arbitrary.
 	Priority int         // The Priority of the item in the queue.
+	Sequence int         // Breaks ties between items of equal Priority, the lowest goes first.
 	// The index is needed by update and is maintained by the heap.Interface methods.
 	index int // The index of the item in the heap.
 }
 
+// Position of the item in the heap, -1 once it has been popped
+func (item *Item) Index() int {
+	return item.index
+}
+
 // A PriorityQueue implements heap.Interface and holds Items.
 type PriorityQueue []*Item
 
@@ -21,6 +27,9 @@ func (pq PriorityQueue) Len() int { return len(pq) }
 
 func (pq PriorityQueue) Less(i, j int) bool {
 	// We want Pop to give us the highest, not lowest, Priority so we use greater than here.
+	if pq[i].Priority == pq[j].Priority {
+		return pq[i].Sequence < pq[j].Sequence
+	}
 	return pq[i].Priority > pq[j].Priority
 }
 
@@ -1,5 +1,12 @@
 package shared
 
+import (
+	"../crypto"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
 type Filename string
 type FileData []byte
 type FileInfo struct {
@@ -7,3 +14,68 @@ type FileInfo struct {
 	NumberOfRecords uint16
 	Data            FileData
 }
+
+// Applies the ops of a block to the files fs, creates and deletes only if createOpsConfirmed, appends only if
+// appendOpsConfirmed and transactions only if both are. Miners and clients replay chains with it, so that
+// they agree on the files of a chain. Fails if an op doesn't fit the files.
+func ApplyFileOps(
+	fs map[Filename]*FileInfo,
+	ops []*crypto.BlockOp,
+	createOpsConfirmed bool,
+	appendOpsConfirmed bool) error {
+	for _, tx := range ops {
+		switch tx.Type {
+		case crypto.CreateFile:
+			if createOpsConfirmed {
+				if len(tx.Filename) > MAX_FILENAME_LENGTH {
+					return errors.New("filename is to big for the given file")
+				}
+
+				if _, exists := fs[Filename(tx.Filename)]; exists {
+					return errors.New("file " + tx.Filename + " is duplicated, not a valid transaction")
+				}
+				fi := FileInfo{
+					Data:            make([]byte, 0, crypto.DataBlockSize),
+					NumberOfRecords: 0,
+					Creator:         tx.Creator,
+				}
+				fs[Filename(tx.Filename)] = &fi
+			}
+		case crypto.AppendFile:
+			if appendOpsConfirmed {
+				if f, exists := fs[Filename(tx.Filename)]; exists {
+					if f.NumberOfRecords >= MAX_RECORD_COUNT {
+						return errors.New(fmt.Sprintf("file %s has reached maximum capacity", tx.Filename))
+					}
+					if tx.RecordNumber != f.NumberOfRecords {
+						return errors.New("append no " + strconv.Itoa(int(tx.RecordNumber)) +
+							" to file " + tx.Filename + " duplicated in chain, failing")
+					}
+					f.NumberOfRecords += 1
+					f.Data = append(f.Data, FileData(tx.Data[:])...)
+				} else {
+					return errors.New("file " + tx.Filename + " doesn't exist but tried to append")
+				}
+			}
+		case crypto.DeleteFile:
+			if createOpsConfirmed {
+				if _, exists := fs[Filename(tx.Filename)]; !exists {
+					return errors.New("file " + tx.Filename + " doesn't exist and cannot delete")
+				}
+				delete(fs, Filename(tx.Filename))
+			}
+		case crypto.Transfer:
+			// only moves coins
+		case crypto.Transaction:
+			// the ops of a transaction only show up once all of them are confirmed
+			txConfirmed := createOpsConfirmed && appendOpsConfirmed
+			err := ApplyFileOps(fs, tx.Ops, txConfirmed, txConfirmed)
+			if err != nil {
+				return err
+			}
+		default:
+			return errors.New("vous les hommes êtes tous les mêmes, Macho mais cheap, Bande de mauviettes infidèles")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package shared
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+)
+
+// Messages between clients and miners are sent as frames: the size of the
+// message as a 4 byte big endian integer followed by the gob encoded message.
+// Every frame has its own gob encoding so frames can be decoded on their own.
+const FRAME_HEADER_SIZE = 4
+
+// The header of a frame is invalid, nothing past it can be read from the stream
+type MalformedFrameError uint32
+
+func (e MalformedFrameError) Error() string {
+	return fmt.Sprintf("malformed frame of [%d] bytes, frames hold 1 to %d bytes", uint32(e), MAX_MESSAGE_SIZE)
+}
+
+// A whole frame was read but it doesn't hold the expected message, the next
+// frame can still be read
+type FrameDecodeError struct {
+	Err error
+}
+
+func (e FrameDecodeError) Error() string {
+	return fmt.Sprintf("cannot decode frame: %v", e.Err)
+}
+
+// Encodes msg into a frame, fails if the message is bigger than MAX_MESSAGE_SIZE
+func EncodeFrame(msg interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, FRAME_HEADER_SIZE))
+	err := gob.NewEncoder(&buf).Encode(msg)
+	if err != nil {
+		return nil, err
+	}
+	frame := buf.Bytes()
+	size := len(frame) - FRAME_HEADER_SIZE
+	if size > MAX_MESSAGE_SIZE {
+		return nil, MalformedFrameError(size)
+	}
+	binary.BigEndian.PutUint32(frame, uint32(size))
+	return frame, nil
+}
+
+// Writes msg as a single frame
+func WriteFrame(w io.Writer, msg interface{}) error {
+	frame, err := EncodeFrame(msg)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(frame)
+	return err
+}
+
+// Reads frames out of a stream, however they were split by the transport
+type FrameReader struct {
+	r *bufio.Reader
+	// set once the stream is out of sync, every read after that fails with it
+	err error
+}
+
+func NewFrameReader(r io.Reader) *FrameReader {
+	return &FrameReader{r: bufio.NewReader(r)}
+}
+
+// Reads the next frame into msg. Returns io.EOF if the stream ends between
+// two frames and FrameDecodeError if the frame doesn't hold a msg. Any other
+// error leaves the stream unusable, except for an error before the first
+// byte of the frame was read, e.g. a read deadline that ran out.
+func (fr *FrameReader) Read(msg interface{}) error {
+	if fr.err != nil {
+		return fr.err
+	}
+
+	var header [FRAME_HEADER_SIZE]byte
+	n, err := io.ReadFull(fr.r, header[:])
+	if err != nil {
+		if n > 0 {
+			fr.err = err
+		}
+		return err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size == 0 || size > MAX_MESSAGE_SIZE {
+		fr.err = MalformedFrameError(size)
+		return fr.err
+	}
+
+	body := make([]byte, size)
+	_, err = io.ReadFull(fr.r, body)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		fr.err = err
+		return err
+	}
+
+	err = gob.NewDecoder(bytes.NewReader(body)).Decode(msg)
+	if err != nil {
+		return FrameDecodeError{Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// Hands out the bytes of the stream one at a time, like a connection that splits everything
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (o oneByteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return o.r.Read(p[:1])
+}
+
+func TestFraming(t *testing.T) {
+	t.Run("should read back the frames written to a stream", func(t *testing.T) {
+		var stream bytes.Buffer
+		first := RFSClientRequest{RequestType: LIST_FILES}
+		second := RFSClientRequest{RequestType: APPEND_RECS, FileName: "file", AppendRecords: make([][512]byte, 10)}
+		ok(t, WriteFrame(&stream, first))
+		ok(t, WriteFrame(&stream, second))
+
+		frames := NewFrameReader(oneByteReader{&stream})
+		request := RFSClientRequest{}
+		ok(t, frames.Read(&request))
+		equals(t, first, request)
+		request = RFSClientRequest{}
+		ok(t, frames.Read(&request))
+		equals(t, second, request)
+		equals(t, io.EOF, frames.Read(&request))
+	})
+
+	t.Run("should refuse to encode messages bigger than the maximum", func(t *testing.T) {
+		_, err := EncodeFrame(make([]byte, MAX_MESSAGE_SIZE))
+		_, isMalformed := err.(MalformedFrameError)
+		assert(t, isMalformed, "should fail because the message is too big")
+	})
+
+	t.Run("should stop reading the stream after a malformed frame", func(t *testing.T) {
+		var stream bytes.Buffer
+		header := make([]byte, FRAME_HEADER_SIZE)
+		binary.BigEndian.PutUint32(header, MAX_MESSAGE_SIZE+1)
+		stream.Write(header)
+		ok(t, WriteFrame(&stream, RFSClientRequest{}))
+
+		frames := NewFrameReader(&stream)
+		request := RFSClientRequest{}
+		err := frames.Read(&request)
+		equals(t, MalformedFrameError(MAX_MESSAGE_SIZE+1), err)
+		equals(t, err, frames.Read(&request))
+	})
+
+	t.Run("should fail on a frame cut short", func(t *testing.T) {
+		frame, err := EncodeFrame(RFSClientRequest{FileName: "file"})
+		ok(t, err)
+		frames := NewFrameReader(bytes.NewReader(frame[:len(frame)-1]))
+		equals(t, io.ErrUnexpectedEOF, frames.Read(&RFSClientRequest{}))
+	})
+
+	t.Run("should skip a frame holding another message", func(t *testing.T) {
+		var stream bytes.Buffer
+		ok(t, WriteFrame(&stream, 0))
+		ok(t, WriteFrame(&stream, RFSClientRequest{FileName: "file"}))
+
+		frames := NewFrameReader(&stream)
+		request := RFSClientRequest{}
+		_, isDecodeError := frames.Read(&request).(FrameDecodeError)
+		assert(t, isDecodeError, "should fail to decode an int into a request")
+		ok(t, frames.Read(&request))
+		equals(t, "file", request.FileName)
+	})
+}
+
+// Taken from https://github.com/benbjohnson/testing
+// assert fails the test if the condition is false.
+func assert(tb testing.TB, condition bool, msg string, v ...interface{}) {
+	if !condition {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: "+msg+"\033[39m\n\n", append([]interface{}{filepath.Base(file), line}, v...)...)
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: unexpected error: %s\033[39m\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
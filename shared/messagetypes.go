@@ -1,5 +1,10 @@
 package shared
 
+import (
+	"../crypto"
+	"time"
+)
+
 // Client request types
 type RequestType int
 
@@ -10,6 +15,16 @@ const (
 	READ_REC
 	APPEND_REC
 	DELETE_FILE
+	APPEND_RECS
+	TRANSACTION
+	APPEND_REC_AT
+	WATCH
+	READ_RECS
+	OP_STATUS
+	CANCEL
+	GET_HEADERS
+	TRANSFER
+	GET_BALANCE
 )
 
 // Failure types
@@ -21,23 +36,176 @@ const (
 	FILE_DOES_NOT_EXIST
 	FILE_EXISTS
 	MAX_LEN_REACHED
-	NOT_ENOUGH_MONEY
+	NOT_ENOUGH_MONEY // the account paying for the op doesn't have enough coins, see RFSMinerResponse.Needed
 	APPEND_DUPLICATE
+	OP_REJECTED // op failed validation, handlers retry it if it passes again and report the reason otherwise
+	OP_EVICTED  // op was rejected or evicted because the mempool is full
+	OP_EXPIRED  // op stayed too long in the mempool without being mined
+	OP_DUPLICATE // op id is already used in the chain
+	TRANSACTION_INVALID // transaction is empty, nested or mixes creators
+	RECORD_CONFLICT // the file doesn't have the number of records a conditional append expected
+	RECORD_DOES_NOT_EXIST // the record to read wasn't appended before the read stopped waiting
+	INVALID_READ_DEPTH // the confirmation depth asked by a read is out of bounds
+	UNKNOWN_OP // the miner has no ticket for the op id
+	REQUEST_CANCELLED // the client cancelled the request before the miner was done with it
+	BAD_SIGNATURE // the op isn't signed by its client account, or the miner doesn't pay for ops of clients without one
+	INVALID_TRANSFER // the transfer has no recipient or no coins to transfer
+	OP_TIMED_OUT // the op wasn't confirmed before the miner stopped waiting for it, it may still be
+	INTERNAL_ERROR // the miner couldn't work out its own state, see its log
+	WATCH_NOT_FIRST // a WATCH request wasn't the first request on its connection
 	NO_ERROR = -1
 )
 
 type RFSClientRequest struct {
+	// Chosen by the client, the response to the request carries the same id. Requests on a
+	// connection are served concurrently so responses can come back in any order
+	RequestId    uint64
 	RequestType  RequestType
 	FileName     string
+	// Record to read, the first record of a READ_RECS request, or the record number an
+	// APPEND_REC_AT expects its record to get
 	RecordNum    uint16
+	// Number of records a READ_RECS request reads
+	ReadCount    uint16
+	// How long a READ_REC request waits for a record that doesn't exist yet, WaitTimeout only applies
+	// to WAIT_TIMEOUT
+	WaitMode     WaitMode
+	WaitTimeout  time.Duration
+	// How long a write waits for its account to afford its ops before failing with NOT_ENOUGH_MONEY.
+	// With NO_WAIT, the zero value, the write reports NOT_ENOUGH_MONEY right away. FundsWaitTimeout
+	// only applies to WAIT_TIMEOUT
+	FundsWaitMode    WaitMode
+	FundsWaitTimeout time.Duration
+	// Confirmation depth the blocks seen by a LIST_FILES, TOTAL_RECS, READ_REC or READ_RECS request
+	// need, for both creates and appends, 0 reads tentative data. If UseReadDepth is false the miner
+	// uses its configured ConfirmsPerFileCreate and ConfirmsPerFileAppend
+	UseReadDepth bool
+	ReadDepth    int
+	AppendRecord [512]byte
+	// Records of an APPEND_RECS request, appended in order
+	AppendRecords [][512]byte
+	// Ops of a TRANSACTION request, applied together or not at all
+	TransactionOps []TransactionOp
+	// A WATCH request watches every file whose name starts with FileName instead of a single file. It
+	// must be the first request on its connection, which then only carries the events of the watch.
+	// Other requests would get cancelled by the watch or have their responses interleaved with its
+	// events, so a WATCH sent after them fails with WATCH_NOT_FIRST and the connection goes on as before
+	WatchPrefix  bool
+	// Coins paid to the miner on top of the base fee for create, append and delete requests
+	Tip          uint32
+	// Idempotency key of a write, retries of a request with the same key are only applied once, even
+	// when sent to another miner. Also the ticket an OP_STATUS request asks about
+	OpId         string
+	// A CREATE_FILE, APPEND_REC or DELETE_FILE request is answered with a ticket as soon as the miner
+	// accepts it, instead of once it is confirmed
+	Async        bool
+	// Id of the request a CANCEL request cancels, the miner stops waiting for its op and answers it
+	// with REQUEST_CANCELLED. CANCEL requests get no response of their own
+	CancelId     uint64
+	// Ids of blocks the client has, newest first, a GET_HEADERS request gets the blocks of the longest
+	// chain that follow the first of them in the chain. The genesis block is sent too if none is
+	Locator      []string
+	// Client account the ops of a write request are charged to. If empty the miner pays for them if it
+	// was configured to, the request fails with BAD_SIGNATURE otherwise. Also the account a GET_BALANCE
+	// request asks about
+	Creator      string
+	// Signatures of the ops of a write request by the client of Creator, in the order the miner creates
+	// them: one for each record of an APPEND_RECS request, whose op ids are OpId followed by "-" and the
+	// index of the record, and a single one for any other request, the one of the transaction for a
+	// TRANSACTION request
+	Signatures   [][]byte
+	// Account a TRANSFER request moves Amount coins to
+	Recipient    string
+	Amount       uint32
+}
+
+type OpState int
+
+const (
+	OP_PENDING OpState = iota // waiting in the mempool
+	OP_MINED                  // in a block of the longest chain, but not deep enough yet
+	OP_CONFIRMED
+	OP_FAILED
+)
+
+// State of the op of an async request. Block and Depth are set once the op is mined, Depth being
+// the number of blocks on top of Block. RecordNum is set for confirmed appends and Reason for failed ops,
+// Balance and Needed for ops that failed with NOT_ENOUGH_MONEY
+type OpStatus struct {
+	State     OpState
+	FileName  string
+	Block     string
+	Depth     int
+	RecordNum uint16
+	Reason    FailureType
+	Balance   int
+	Needed    int
+}
+
+type WaitMode int
+
+const (
+	NO_WAIT WaitMode = iota
+	WAIT_TIMEOUT
+	WAIT_FOREVER
+)
+
+// A single file operation of a TRANSACTION request, RequestType is one of
+// CREATE_FILE, APPEND_REC or DELETE_FILE
+type TransactionOp struct {
+	RequestType  RequestType
+	FileName     string
 	AppendRecord [512]byte
 }
 
 type RFSMinerResponse struct {
+	// Id of the request this is the response to
+	RequestId  uint64
 	// Set the ErrorType to -1 if no error occurred while processing the client request
 	ErrorType  FailureType
 	FileNames  []string
 	NumRecords uint16
 	RecordNum  uint16
+	RecordNums []uint16
 	ReadRecord [512]byte
+	// Confirmed records of a READ_RECS request, at most MAX_RECS_PER_READ_REQUEST of them
+	ReadRecords [][512]byte
+	// Confirmation depth a read request was answered at
+	ReadDepth  ConfirmDepth
+	// Newest block whose ops a read request sees, miners that answer at the same block and depth
+	// see the same data
+	ReadBlock  string
+	// Blocks of the longest chain, oldest first, for a GET_HEADERS request. Despite the name the blocks
+	// are sent whole, with their ops, clients replay them to check what a read returns
+	Headers    []crypto.Block
+	// Ticket of an async request
+	OpId       string
+	OpStatus   OpStatus
+	// Coins of the account of a GET_BALANCE request in the longest chain. For a write that failed with
+	// NOT_ENOUGH_MONEY, the coins its account had and the ones its ops needed
+	Balance    int
+	Needed     int
+}
+
+// Number of blocks that have to follow the block of a create or of an append for it to be seen
+type ConfirmDepth struct {
+	Create int
+	Append int
+}
+
+type WatchEventType int
+
+const (
+	FILE_CREATED WatchEventType = iota
+	FILE_DELETED
+	RECORD_APPENDED
+)
+
+// Streamed by the miner on the connection of a WATCH request, after the response
+// to the request, for every confirmed change of a watched file
+type WatchEvent struct {
+	Type      WatchEventType
+	FileName  string
+	RecordNum uint16
+	Record    [512]byte
 }
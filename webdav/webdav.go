@@ -0,0 +1,297 @@
+package webdav
+
+import (
+	"../rfslib"
+	"../shared"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Serves the files of an RFS over WebDAV (class 1), so that they can be browsed with a file manager.
+// The namespace is flat: the root is the only collection and every RFS file is a resource in it,
+// whose contents are its records one after the other. Files are append-only, so a PUT creates a file
+// or extends it: the body has to start with the current contents of the file, as a GET returns them,
+// and the rest is appended as records, the last one padded with zero bytes. Any other write is
+// rejected with 409 Conflict.
+type Handler struct {
+	rfs rfslib.RFS
+}
+
+func NewHandler(rfs rfslib.RFS) *Handler {
+	return &Handler{rfs: rfs}
+}
+
+var lg = log.New(os.Stdout, "webdav: ", log.Ltime)
+
+const recordSize = len(rfslib.Record{})
+
+const allowedMethods = "OPTIONS, PROPFIND, GET, HEAD, PUT, DELETE"
+
+// <PROPFIND RESPONSE>
+
+type multistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	Dav       string        `xml:"xmlns:D,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+type davResponse struct {
+	Href     string      `xml:"D:href"`
+	Propstat davPropstat `xml:"D:propstat"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"D:prop"`
+	Status string  `xml:"D:status"`
+}
+
+type davProp struct {
+	DisplayName   string          `xml:"D:displayname"`
+	ResourceType  davResourceType `xml:"D:resourcetype"`
+	ContentLength *int64          `xml:"D:getcontentlength,omitempty"`
+	ContentType   string          `xml:"D:getcontenttype,omitempty"`
+}
+
+type davResourceType struct {
+	Collection *struct{} `xml:"D:collection,omitempty"`
+}
+
+// </PROPFIND RESPONSE>
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// file names are escaped, they can hold slashes
+	fname, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("DAV", "1")
+		w.Header().Set("Allow", allowedMethods)
+	case "PROPFIND":
+		h.propfind(w, r, fname)
+	case http.MethodGet, http.MethodHead:
+		if fname == "" {
+			h.listing(w, r)
+			return
+		}
+		h.get(w, r, fname)
+	case http.MethodPut:
+		h.put(w, r, fname)
+	case http.MethodDelete:
+		if fname == "" {
+			http.Error(w, "the root can't be deleted", http.StatusForbidden)
+			return
+		}
+		err := h.rfs.DeleteFile(fname)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		// the namespace has no collections to make, and files can't be moved, copied or locked
+		w.Header().Set("Allow", allowedMethods)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Properties of the root, and of its files unless Depth is 0, or of a single file
+func (h *Handler) propfind(w http.ResponseWriter, r *http.Request, fname string) {
+	responses := make([]davResponse, 0)
+	if fname == "" {
+		root := davResponse{Href: "/", Propstat: davPropstat{
+			Prop:   davProp{ResourceType: davResourceType{Collection: &struct{}{}}},
+			Status: "HTTP/1.1 200 OK"}}
+		responses = append(responses, root)
+
+		if r.Header.Get("Depth") != "0" {
+			fnames, err := h.rfs.ListFiles()
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			for _, fname := range fnames {
+				response, err := h.fileProps(fname)
+				if err != nil {
+					if _, deleted := err.(rfslib.FileDoesNotExistError); deleted {
+						continue
+					}
+					writeError(w, err)
+					return
+				}
+				responses = append(responses, response)
+			}
+		}
+	} else {
+		response, err := h.fileProps(fname)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		responses = append(responses, response)
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, xml.Header)
+	err := xml.NewEncoder(w).Encode(multistatus{Dav: "DAV:", Responses: responses})
+	if err != nil {
+		lg.Println(err)
+	}
+}
+
+func (h *Handler) fileProps(fname string) (davResponse, error) {
+	numRecs, err := h.rfs.TotalRecs(fname)
+	if err != nil {
+		return davResponse{}, err
+	}
+	size := int64(numRecs) * int64(recordSize)
+	return davResponse{
+		Href: "/" + url.PathEscape(fname),
+		Propstat: davPropstat{
+			Prop: davProp{
+				DisplayName:   fname,
+				ContentLength: &size,
+				ContentType:   "application/octet-stream"},
+			Status: "HTTP/1.1 200 OK"}}, nil
+}
+
+// A GET of the root lists its files, one per line
+func (h *Handler) listing(w http.ResponseWriter, r *http.Request) {
+	fnames, err := h.rfs.ListFiles()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	for _, fname := range fnames {
+		fmt.Fprintln(w, fname)
+	}
+}
+
+// Sends the records of the file, read a batch at a time
+func (h *Handler) get(w http.ResponseWriter, r *http.Request, fname string) {
+	numRecs, err := h.rfs.TotalRecs(fname)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(int(numRecs)*recordSize))
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	for start := 0; start < int(numRecs); start += shared.MAX_RECS_PER_READ_REQUEST {
+		records, err := h.rfs.ReadRecs(fname, uint16(start), shared.MAX_RECS_PER_READ_REQUEST)
+		if err != nil {
+			// the headers are gone already, all we can do is cut the body short
+			lg.Println(err)
+			return
+		}
+		for _, record := range records {
+			_, err := w.Write(record[:])
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Creates the file, or checks that the body starts with its current records, and appends the rest of
+// the body
+func (h *Handler) put(w http.ResponseWriter, r *http.Request, fname string) {
+	if fname == "" {
+		http.Error(w, "the root is not a file", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := http.StatusNoContent
+	numRecs, err := h.rfs.TotalRecs(fname)
+	if _, missing := err.(rfslib.FileDoesNotExistError); missing {
+		err = h.rfs.CreateFile(fname)
+		status = http.StatusCreated
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// files are append-only, the body can only extend them
+	buf := make([]byte, shared.MAX_RECS_PER_READ_REQUEST*recordSize)
+	for start := 0; start < int(numRecs); start += shared.MAX_RECS_PER_READ_REQUEST {
+		records, err := h.rfs.ReadRecs(fname, uint16(start), shared.MAX_RECS_PER_READ_REQUEST)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		n, _ := io.ReadFull(r.Body, buf[:len(records)*recordSize])
+		for i, record := range records {
+			if n < (i+1)*recordSize || !bytes.Equal(record[:], buf[i*recordSize:(i+1)*recordSize]) {
+				http.Error(w, "files are append-only, the body has to start with the current contents",
+					http.StatusConflict)
+				return
+			}
+		}
+	}
+
+	for {
+		n, readErr := io.ReadFull(r.Body, buf)
+		if n > 0 {
+			records := make([]rfslib.Record, (n+recordSize-1)/recordSize)
+			for i := range records {
+				copy(records[i][:], buf[i*recordSize:n])
+			}
+			_, err := h.rfs.AppendRecs(fname, records)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			http.Error(w, readErr.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	w.WriteHeader(status)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch err.(type) {
+	case rfslib.FileDoesNotExistError:
+		status = http.StatusNotFound
+	case rfslib.FileExistsError:
+		status = http.StatusConflict
+	case rfslib.BadFilenameError:
+		status = http.StatusBadRequest
+	case rfslib.FileMaxLenReachedError:
+		status = http.StatusInsufficientStorage
+	case rfslib.DisconnectedError, rfslib.OperationEvictedError:
+		status = http.StatusServiceUnavailable
+	case rfslib.OperationExpiredError, rfslib.ConfirmationTimeoutError:
+		status = http.StatusGatewayTimeout
+	case rfslib.OperationRejectedError:
+		status = http.StatusConflict
+	case rfslib.InsufficientFundsError:
+		status = http.StatusPaymentRequired
+	}
+	http.Error(w, err.Error(), status)
+}
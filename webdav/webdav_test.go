@@ -0,0 +1,189 @@
+package webdav
+
+import (
+	"../rfslib"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Keeps files in memory, only the calls used by Handler are implemented
+type memRFS struct {
+	rfslib.RFS
+	mux   *sync.Mutex
+	files map[string][]rfslib.Record
+}
+
+func newMemRFS() memRFS {
+	return memRFS{mux: new(sync.Mutex), files: make(map[string][]rfslib.Record)}
+}
+
+func (m memRFS) ListFiles() ([]string, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	fnames := make([]string, 0)
+	for fname := range m.files {
+		fnames = append(fnames, fname)
+	}
+	sort.Strings(fnames)
+	return fnames, nil
+}
+
+func (m memRFS) TotalRecs(fname string) (uint16, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	records, exists := m.files[fname]
+	if !exists {
+		return 0, rfslib.FileDoesNotExistError(fname)
+	}
+	return uint16(len(records)), nil
+}
+
+func (m memRFS) ReadRecs(fname string, start uint16, count uint16) ([]rfslib.Record, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	records := make([]rfslib.Record, 0)
+	for i := int(start); i < int(start)+int(count) && i < len(m.files[fname]); i++ {
+		records = append(records, m.files[fname][i])
+	}
+	return records, nil
+}
+
+func (m memRFS) CreateFile(fname string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if _, exists := m.files[fname]; exists {
+		return rfslib.FileExistsError(fname)
+	}
+	m.files[fname] = make([]rfslib.Record, 0)
+	return nil
+}
+
+func (m memRFS) DeleteFile(fname string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if _, exists := m.files[fname]; !exists {
+		return rfslib.FileDoesNotExistError(fname)
+	}
+	delete(m.files, fname)
+	return nil
+}
+
+func (m memRFS) AppendRecs(fname string, records []rfslib.Record) ([]uint16, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	recordNums := make([]uint16, len(records))
+	for i, record := range records {
+		recordNums[i] = uint16(len(m.files[fname]))
+		m.files[fname] = append(m.files[fname], record)
+	}
+	return recordNums, nil
+}
+
+func request(h http.Handler, method string, path string, body []byte) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(body)))
+	return w
+}
+
+func TestHandler(t *testing.T) {
+	t.Run("should create a file with PUT and read it back with GET", func(t *testing.T) {
+		m := newMemRFS()
+		h := NewHandler(m)
+		contents := []byte(strings.Repeat("records of 512 bytes! ", 2000))
+		w := request(h, http.MethodPut, "/notes.txt", contents)
+		equals(t, http.StatusCreated, w.Code)
+		equals(t, (len(contents)+511)/512, len(m.files["notes.txt"]))
+
+		w = request(h, http.MethodGet, "/notes.txt", nil)
+		equals(t, http.StatusOK, w.Code)
+		body, _ := ioutil.ReadAll(w.Body)
+		equals(t, len(m.files["notes.txt"])*512, len(body))
+		equals(t, contents, body[:len(contents)])
+		equals(t, bytes.Repeat([]byte{0}, len(body)-len(contents)), body[len(contents):])
+	})
+
+	t.Run("should extend a file when the body starts with its contents", func(t *testing.T) {
+		m := newMemRFS()
+		h := NewHandler(m)
+		equals(t, http.StatusCreated, request(h, http.MethodPut, "/f", []byte("first")).Code)
+		current := request(h, http.MethodGet, "/f", nil).Body.Bytes()
+
+		w := request(h, http.MethodPut, "/f", append(current, []byte("second")...))
+		equals(t, http.StatusNoContent, w.Code)
+		equals(t, 2, len(m.files["f"]))
+		equals(t, "second", string(bytes.TrimRight(m.files["f"][1][:], "\x00")))
+	})
+
+	t.Run("should reject writes that change the contents of a file", func(t *testing.T) {
+		m := newMemRFS()
+		h := NewHandler(m)
+		request(h, http.MethodPut, "/f", []byte("first"))
+
+		equals(t, http.StatusConflict, request(h, http.MethodPut, "/f", []byte("changed")).Code)
+		equals(t, http.StatusConflict, request(h, http.MethodPut, "/f", nil).Code)
+		equals(t, 1, len(m.files["f"]))
+		equals(t, "first", string(bytes.TrimRight(m.files["f"][0][:], "\x00")))
+	})
+
+	t.Run("should list the files with PROPFIND", func(t *testing.T) {
+		m := newMemRFS()
+		h := NewHandler(m)
+		request(h, http.MethodPut, "/a", make([]byte, 1024))
+		request(h, http.MethodPut, "/dir%2Fb", nil)
+
+		w := request(h, "PROPFIND", "/", nil)
+		equals(t, http.StatusMultiStatus, w.Code)
+		body := w.Body.String()
+		assert(t, strings.Contains(body, "<D:collection></D:collection>"), "should describe the root, got %s", body)
+		assert(t, strings.Contains(body, "<D:href>/a</D:href>"), "should list a, got %s", body)
+		assert(t, strings.Contains(body, "<D:getcontentlength>1024</D:getcontentlength>"),
+			"should give the size of a, got %s", body)
+		assert(t, strings.Contains(body, "<D:href>/dir%2Fb</D:href>"), "should list dir/b, got %s", body)
+
+		req := httptest.NewRequest("PROPFIND", "/", nil)
+		req.Header.Set("Depth", "0")
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		assert(t, !strings.Contains(w.Body.String(), "/a"), "should only describe the root with Depth 0")
+	})
+
+	t.Run("should map RFS errors to status codes", func(t *testing.T) {
+		m := newMemRFS()
+		h := NewHandler(m)
+		equals(t, http.StatusNotFound, request(h, http.MethodGet, "/missing", nil).Code)
+		equals(t, http.StatusNotFound, request(h, "PROPFIND", "/missing", nil).Code)
+		equals(t, http.StatusNotFound, request(h, http.MethodDelete, "/missing", nil).Code)
+		equals(t, http.StatusMethodNotAllowed, request(h, "MOVE", "/missing", nil).Code)
+
+		request(h, http.MethodPut, "/f", nil)
+		equals(t, http.StatusNoContent, request(h, http.MethodDelete, "/f", nil).Code)
+		equals(t, 0, len(m.files))
+	})
+}
+
+func assert(tb testing.TB, condition bool, msg string, v ...interface{}) {
+	if !condition {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: "+msg+"\033[39m\n\n", append([]interface{}{filepath.Base(file), line}, v...)...)
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}